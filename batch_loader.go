@@ -0,0 +1,106 @@
+package bbolt
+
+// BatchLoader bulk loads key/value pairs into a bucket, committing them in a
+// series of bounded transactions instead of one large one.
+//
+// Pairs passed to Put are buffered, and written to the database once
+// batchSize of them have accumulated, or when Flush or Close is called. Each
+// write runs in its own Update transaction, which resolves the target bucket
+// and creates any missing buckets along its path.
+//
+// A BatchLoader is not safe for concurrent use.
+type BatchLoader struct {
+	db         *DB
+	bucketPath [][]byte
+	batchSize  int
+	pending    []WritePair
+	err        error
+}
+
+// NewBatchLoader returns a BatchLoader writing into the bucket located at
+// bucketPath, committing a transaction every batchSize pairs.
+// If batchSize is not positive, DefaultMaxBatchSize is used.
+func (db *DB) NewBatchLoader(bucketPath [][]byte, batchSize int) *BatchLoader {
+	if batchSize <= 0 {
+		batchSize = DefaultMaxBatchSize
+	}
+
+	path := make([][]byte, len(bucketPath))
+	for i, name := range bucketPath {
+		path[i] = cloneBytes(name)
+	}
+
+	return &BatchLoader{
+		db:         db,
+		bucketPath: path,
+		batchSize:  batchSize,
+		pending:    make([]WritePair, 0, batchSize),
+	}
+}
+
+// Put buffers a key/value pair, flushing the buffer if it reaches the batch
+// size. The key and value are copied, so may be reused after Put returns.
+// Once a flush has failed, Put returns that error and buffers nothing.
+func (l *BatchLoader) Put(key, value []byte) error {
+	if l.err != nil {
+		return l.err
+	}
+
+	l.pending = append(l.pending, WritablePair(key, value))
+	if len(l.pending) >= l.batchSize {
+		return l.Flush()
+	}
+	return nil
+}
+
+// Flush writes all buffered pairs to the database in a single transaction.
+// Once a flush has failed, Flush returns that error without writing.
+func (l *BatchLoader) Flush() error {
+	if l.err != nil {
+		return l.err
+	} else if len(l.pending) == 0 {
+		return nil
+	}
+
+	l.err = l.db.Update(func(tx *Tx) error {
+		b, err := createBucketPath(tx, l.bucketPath)
+		if err != nil {
+			return err
+		}
+
+		for _, pair := range l.pending {
+			if err := b.Put(pair.key, pair.value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	l.pending = l.pending[:0]
+
+	return l.err
+}
+
+// Close flushes any remaining buffered pairs. The BatchLoader must not be
+// used afterwards.
+func (l *BatchLoader) Close() error {
+	return l.Flush()
+}
+
+// createBucketPath descends from the root of tx through bucketPath, creating
+// any buckets that do not exist yet, and returns the last one.
+func createBucketPath(tx *Tx, bucketPath [][]byte) (*Bucket, error) {
+	if len(bucketPath) == 0 {
+		return nil, ErrBucketNameRequired
+	}
+
+	b, err := tx.CreateBucketIfNotExists(bucketPath[0])
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range bucketPath[1:] {
+		if b, err = b.CreateBucketIfNotExists(name); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
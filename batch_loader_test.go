@@ -0,0 +1,79 @@
+package bbolt_test
+
+import (
+	"fmt"
+	"testing"
+
+	bolt "github.com/covalenthq/bbolt"
+)
+
+// Ensure that a batch loader commits every batchSize pairs and on Close.
+func TestBatchLoader(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	keyCount := func() (n int) {
+		if err := db.View(func(tx *bolt.Tx) error {
+			b := tx.Bucket([]byte("widgets"))
+			if b == nil {
+				return nil
+			}
+			n = b.Bucket([]byte("nested")).Stats().KeyN
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	l := db.NewBatchLoader([][]byte{[]byte("widgets"), []byte("nested")}, 1000)
+	for i := 0; i < 2500; i++ {
+		if err := l.Put([]byte(fmt.Sprintf("%04d", i)), []byte("0000")); err != nil {
+			t.Fatal(err)
+		}
+		if i == 998 {
+			if n := keyCount(); n != 0 {
+				t.Fatalf("unexpected key count before first flush: %d", n)
+			}
+		}
+	}
+	if n := keyCount(); n != 2000 {
+		t.Fatalf("unexpected key count before Close: %d", n)
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if n := keyCount(); n != 2500 {
+		t.Fatalf("unexpected key count after Close: %d", n)
+	}
+}
+
+// Ensure that a failed flush is surfaced and stops further writes.
+func TestBatchLoader_Error(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b.Put([]byte("nested"), []byte("scalar"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	l := db.NewBatchLoader([][]byte{[]byte("widgets"), []byte("nested")}, 2)
+	if err := l.Put([]byte("foo"), []byte("bar")); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Put([]byte("baz"), []byte("bat")); err != bolt.ErrIncompatibleValue {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := l.Put([]byte("qux"), []byte("quux")); err != bolt.ErrIncompatibleValue {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := l.Close(); err != bolt.ErrIncompatibleValue {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
@@ -2,7 +2,14 @@ package bbolt
 
 import (
 	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"sync/atomic"
 	"unsafe"
 )
 
@@ -21,6 +28,10 @@ const (
 	maxFillPercent = 1.0
 )
 
+// forEachContextCheckInterval is the number of keys ForEachContext visits
+// between checks of its context.
+const forEachContextCheckInterval = 1024
+
 // DefaultFillPercent is the percentage that split pages are filled.
 // This value can be changed by setting Bucket.FillPercent.
 const DefaultFillPercent = 0.5
@@ -34,6 +45,8 @@ type Bucket struct {
 	rootNode *node              // materialized node for the root page.
 	nodes    map[pgid]*node     // node cache
 
+	changeHandlers []func(key, oldValue, newValue []byte)
+
 	// Sets the threshold for filling nodes when they split. By default,
 	// the bucket will fill to 50% but it can be useful to increase this
 	// amount if you know that your write workloads are mostly append-only.
@@ -246,6 +259,56 @@ func (b *Bucket) deleteSelectedBucket(key []byte, child *Bucket) error {
 	return nil
 }
 
+// RenameBucket moves the nested bucket oldName to newName, along with its
+// whole subtree and sequence. Only the bucket's header is moved, so the cost
+// does not depend on the size of the bucket.
+// Returns ErrBucketNotFound if oldName does not exist, ErrIncompatibleValue if
+// either name refers to a non-bucket value, and ErrBucketExists if newName
+// already exists.
+func (b *Bucket) RenameBucket(oldName, newName []byte) error {
+	if b.tx.db == nil {
+		return ErrTxClosed
+	} else if !b.Writable() {
+		return ErrTxNotWritable
+	} else if len(newName) == 0 {
+		return ErrBucketNameRequired
+	} else if len(newName) > MaxKeySize {
+		return ErrKeyTooLarge
+	}
+
+	c := b.Cursor()
+	k, v, flags := c.seek(oldName)
+	if !bytes.Equal(oldName, k) {
+		return ErrBucketNotFound
+	} else if (flags & bucketLeafFlag) == 0 {
+		return ErrIncompatibleValue
+	}
+	value := cloneBytes(v)
+
+	k, _, flags = c.seek(newName)
+	if bytes.Equal(newName, k) {
+		if (flags & bucketLeafFlag) != 0 {
+			return ErrBucketExists
+		}
+		return ErrIncompatibleValue
+	}
+
+	// Write the header under the new name and remove the old one. A child
+	// opened in this transaction is moved in the cache as well, so that
+	// its pending changes are spilled under the new name.
+	newName = cloneBytes(newName)
+	c.node().put(newName, newName, value, 0, bucketLeafFlag)
+	c.seek(oldName)
+	c.node().del(oldName)
+
+	if child, ok := b.buckets[string(oldName)]; ok {
+		delete(b.buckets, string(oldName))
+		b.buckets[string(newName)] = child
+	}
+
+	return nil
+}
+
 // DeleteBucket deletes a bucket at the given key.
 // Returns an error if the bucket does not exists, or if the key represents a non-bucket value.
 func (b *Bucket) DeleteBucket(key []byte) error {
@@ -276,6 +339,43 @@ func (b *Bucket) DeleteBucket(key []byte) error {
 	return err
 }
 
+// Clear deletes every key and nested bucket in the bucket, leaving the bucket
+// itself and its sequence in place. The pages of the bucket and of its nested
+// buckets are released to the freelist. To also reset the sequence, call
+// SetSequence(0).
+// Returns an error if the bucket was created from a read-only transaction.
+func (b *Bucket) Clear() error {
+	if b.tx.db == nil {
+		return ErrTxClosed
+	} else if !b.Writable() {
+		return ErrTxNotWritable
+	}
+
+	// Release the pages of all child buckets, and report the deletion of
+	// every value to the change handlers.
+	err := b.ForEach(func(k, v []byte) error {
+		if v == nil {
+			return b.deleteSelectedBucket(k, b.Bucket(k))
+		}
+		b.notifyDelete(k, v)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Release this bucket's pages and start over from an empty, inline root.
+	b.nodes = nil
+	b.rootNode = nil
+	b.free()
+	b.page = nil
+	b.buckets = make(map[string]*Bucket)
+	b.nodes = make(map[pgid]*node)
+	b.rootNode = &node{bucket: b, isLeaf: true}
+
+	return nil
+}
+
 // Get retrieves the value for a key in the bucket.
 // Returns a nil value if the key does not exist or if the key is a nested bucket.
 // The returned value is only valid for the life of the transaction.
@@ -294,6 +394,55 @@ func (b *Bucket) Get(key []byte) []byte {
 	return v
 }
 
+// Has reports whether a key exists in the bucket and holds a value. It
+// returns false for keys that refer to nested buckets.
+// Unlike Get, it never references the value, which makes it cheaper for
+// probing many keys with large values.
+func (b *Bucket) Has(key []byte) bool {
+	c := b.Cursor()
+	c.stack = c.stack[:0]
+	c.search(key, b.root)
+
+	ok, flags := c.keyEquals(key)
+	return ok && (flags&bucketLeafFlag) == 0
+}
+
+// First returns the first key and value in the bucket, or nil for both if
+// the bucket is empty. As with a Cursor, the value is nil if the key is a
+// nested bucket. The returned key and value are only valid for the life of
+// the transaction.
+func (b *Bucket) First() (k, v []byte) {
+	return b.Cursor().First()
+}
+
+// Last returns the last key and value in the bucket, or nil for both if the
+// bucket is empty. As with a Cursor, the value is nil if the key is a nested
+// bucket. The returned key and value are only valid for the life of the
+// transaction.
+func (b *Bucket) Last() (k, v []byte) {
+	return b.Cursor().Last()
+}
+
+// GetReader returns a reader over the value for a key in the bucket.
+// The reader reads directly from the transaction's view of the value without
+// copying it, and so must not be used after the transaction is closed.
+// Returns ErrKeyNotFound if the key does not exist, or ErrIncompatibleValue
+// if the key is a nested bucket.
+func (b *Bucket) GetReader(key []byte) (io.Reader, error) {
+	if b.tx.db == nil {
+		return nil, ErrTxClosed
+	}
+
+	k, v, flags := b.Cursor().seek(key)
+
+	if !bytes.Equal(key, k) {
+		return nil, ErrKeyNotFound
+	} else if (flags & bucketLeafFlag) != 0 {
+		return nil, ErrIncompatibleValue
+	}
+	return bytes.NewReader(v), nil
+}
+
 // Put sets the value for a key in the bucket.
 // If the key exist then its previous value will be overwritten.
 // Supplied value must remain valid for the life of the transaction.
@@ -307,26 +456,228 @@ func (b *Bucket) Put(key []byte, value []byte) error {
 		return ErrKeyRequired
 	} else if len(key) > MaxKeySize {
 		return ErrKeyTooLarge
-	} else if int64(len(value)) > MaxValueSize {
-		return ErrValueTooLarge
+	} else if err := b.checkValueSize(key, len(value)); err != nil {
+		return err
 	}
 
 	// Move cursor to correct position.
 	c := b.Cursor()
-	k, _, flags := c.seek(key)
+	k, v, flags := c.seek(key)
 
 	// Return an error if there is an existing key with a bucket value.
-	if bytes.Equal(key, k) && (flags&bucketLeafFlag) != 0 {
+	exists := bytes.Equal(key, k)
+	if exists && (flags&bucketLeafFlag) != 0 {
 		return ErrIncompatibleValue
 	}
 
 	// Insert into node.
 	key = cloneBytes(key)
 	c.node().put(key, key, value, 0, 0)
+	b.notifyPut(key, v, exists, value)
+
+	return nil
+}
+
+// GetOrPut retrieves the value for a key in the bucket, storing defaultValue
+// under the key first if it does not exist. created reports whether the
+// default was stored, which distinguishes a missing key from an existing empty
+// value. The returned value is only valid for the life of the transaction.
+// Returns an error under the same conditions as Put, or ErrIncompatibleValue
+// if the key is a nested bucket.
+func (b *Bucket) GetOrPut(key, defaultValue []byte) (value []byte, created bool, err error) {
+	if b.tx.db == nil {
+		return nil, false, ErrTxClosed
+	} else if !b.Writable() {
+		return nil, false, ErrTxNotWritable
+	} else if len(key) == 0 {
+		return nil, false, ErrKeyRequired
+	} else if len(key) > MaxKeySize {
+		return nil, false, ErrKeyTooLarge
+	} else if err := b.checkValueSize(key, len(defaultValue)); err != nil {
+		return nil, false, err
+	}
+
+	// Move cursor to correct position.
+	c := b.Cursor()
+	k, v, flags := c.seek(key)
+
+	if bytes.Equal(key, k) {
+		if (flags & bucketLeafFlag) != 0 {
+			return nil, false, ErrIncompatibleValue
+		}
+		return v, false, nil
+	}
+
+	// Insert the default into node.
+	key = cloneBytes(key)
+	c.node().put(key, key, defaultValue, 0, 0)
+	b.notifyPut(key, nil, false, defaultValue)
+
+	return defaultValue, true, nil
+}
+
+// PutIfAbsent stores value under a key only if the key does not exist, and
+// reports whether it was written. An existing empty value counts as present.
+// Returns an error under the same conditions as Put, or ErrIncompatibleValue
+// if the key is a nested bucket.
+func (b *Bucket) PutIfAbsent(key, value []byte) (bool, error) {
+	_, created, err := b.GetOrPut(key, value)
+	return created, err
+}
+
+// CompareAndSwap sets the value for a key to new only if its current value
+// equals old, and reports whether the swap happened. A missing key is treated
+// as a nil value, so passing a nil old creates the key only if it is absent.
+// An empty non-nil old matches only an existing empty value. Passing a nil new
+// deletes the key. Returns an error under the same conditions as Put, or
+// ErrIncompatibleValue if the key is a nested bucket.
+func (b *Bucket) CompareAndSwap(key, old, new []byte) (bool, error) {
+	if b.tx.db == nil {
+		return false, ErrTxClosed
+	} else if !b.Writable() {
+		return false, ErrTxNotWritable
+	} else if len(key) == 0 {
+		return false, ErrKeyRequired
+	} else if len(key) > MaxKeySize {
+		return false, ErrKeyTooLarge
+	} else if err := b.checkValueSize(key, len(new)); err != nil {
+		return false, err
+	}
+
+	// Move cursor to correct position.
+	c := b.Cursor()
+	k, v, flags := c.seek(key)
+
+	exists := bytes.Equal(key, k)
+	if exists && (flags&bucketLeafFlag) != 0 {
+		return false, ErrIncompatibleValue
+	}
+	if exists != (old != nil) {
+		return false, nil
+	} else if exists && !bytes.Equal(v, old) {
+		return false, nil
+	}
+
+	if new == nil {
+		if exists {
+			c.node().del(key)
+			b.notifyDelete(key, v)
+		}
+		return true, nil
+	}
+	key = cloneBytes(key)
+	c.node().put(key, key, new, 0, 0)
+	b.notifyPut(key, v, exists, new)
+
+	return true, nil
+}
+
+// Modify replaces the value for a key with the result of fn, which is passed
+// the current value, or nil if the key does not exist. An existing empty value
+// is passed as an empty non-nil slice. If fn returns a nil value the key is
+// deleted, and if it returns an error nothing is written and the error is
+// returned. fn must not modify the bucket. Returns an error under the same
+// conditions as Put, or ErrIncompatibleValue if the key is a nested bucket.
+func (b *Bucket) Modify(key []byte, fn func(old []byte) (new []byte, err error)) error {
+	if b.tx.db == nil {
+		return ErrTxClosed
+	} else if !b.Writable() {
+		return ErrTxNotWritable
+	} else if len(key) == 0 {
+		return ErrKeyRequired
+	} else if len(key) > MaxKeySize {
+		return ErrKeyTooLarge
+	}
+
+	// Move cursor to correct position.
+	c := b.Cursor()
+	k, v, flags := c.seek(key)
+
+	exists := bytes.Equal(key, k)
+	if exists && (flags&bucketLeafFlag) != 0 {
+		return ErrIncompatibleValue
+	}
+	var old []byte
+	if exists {
+		old = v
+		if old == nil {
+			old = []byte{}
+		}
+	}
+
+	new, err := fn(old)
+	if err != nil {
+		return err
+	} else if err := b.checkValueSize(key, len(new)); err != nil {
+		return err
+	}
+
+	// Reuse the cursor position to write the result.
+	if new == nil {
+		if exists {
+			c.node().del(key)
+			b.notifyDelete(key, v)
+		}
+		return nil
+	}
+	key = cloneBytes(key)
+	c.node().put(key, key, new, 0, 0)
+	b.notifyPut(key, v, exists, new)
 
 	return nil
 }
 
+// IncrementUint64 adds delta to the 8-byte big-endian integer stored under a
+// key and returns the new value. A missing key is treated as zero. Returns
+// ErrInvalidCounter if the existing value is not exactly 8 bytes, or
+// ErrCounterOverflow if the result would fall outside the range of a uint64.
+func (b *Bucket) IncrementUint64(key []byte, delta int64) (uint64, error) {
+	if b.tx.db == nil {
+		return 0, ErrTxClosed
+	} else if !b.Writable() {
+		return 0, ErrTxNotWritable
+	} else if len(key) == 0 {
+		return 0, ErrKeyRequired
+	} else if len(key) > MaxKeySize {
+		return 0, ErrKeyTooLarge
+	}
+
+	// Move cursor to correct position.
+	c := b.Cursor()
+	k, v, flags := c.seek(key)
+
+	var n uint64
+	exists := bytes.Equal(key, k)
+	if exists {
+		if (flags & bucketLeafFlag) != 0 {
+			return 0, ErrIncompatibleValue
+		} else if len(v) != 8 {
+			return 0, ErrInvalidCounter
+		}
+		n = binary.BigEndian.Uint64(v)
+	}
+
+	if delta >= 0 {
+		if n+uint64(delta) < n {
+			return 0, ErrCounterOverflow
+		}
+		n += uint64(delta)
+	} else {
+		if uint64(-(delta + 1)) >= n {
+			return 0, ErrCounterOverflow
+		}
+		n -= uint64(-(delta + 1)) + 1
+	}
+
+	value := make([]byte, 8)
+	binary.BigEndian.PutUint64(value, n)
+	key = cloneBytes(key)
+	c.node().put(key, key, value, 0, 0)
+	b.notifyPut(key, v, exists, value)
+
+	return n, nil
+}
+
 // MultiPut sets the values for multiple keys in the bucket.
 // If the keys exist then their previous value will be overwritten.
 // Supplied value must remain valid for the life of the transaction.
@@ -362,12 +713,24 @@ func (b *Bucket) WritePairs(pairs []WritePair) error {
 		return ErrInvalidArgNumber
 	}
 
-	return b.WritePairs(pairs)
+	return b.writePairs(pairs)
+}
+
+// MultiPutPairs sets the values for multiple keys in the bucket, writing each
+// pair in the order given. Each pair is validated as in Put.
+// Returns the first error encountered.
+func (b *Bucket) MultiPutPairs(pairs ...WritePair) error {
+	for _, pair := range pairs {
+		if err := b.Put(pair.key, pair.value); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func (b *Bucket) writePairs(pairs []WritePair) error {
 	c := b.Cursor()
-	var k []byte
+	var k, v []byte
 	var flags uint32
 	var didFirst bool
 	for _, pair := range pairs {
@@ -375,38 +738,46 @@ func (b *Bucket) writePairs(pairs []WritePair) error {
 			return ErrKeyRequired
 		} else if len(pair.key) > MaxKeySize {
 			return ErrKeyTooLarge
-		} else if int64(len(pair.value)) > MaxValueSize {
-			return ErrValueTooLarge
+		} else if err := b.checkValueSize(pair.key, len(pair.value)); err != nil {
+			return err
 		}
 		// Move cursor to correct position.
 		if !didFirst {
-			k, _, flags = c.seek(pair.key)
+			k, v, flags = c.seek(pair.key)
 			didFirst = true
 		} else {
-			k, _, flags = c.seekTo(pair.key)
+			k, v, flags = c.seekTo(pair.key)
 		}
 		// Return an error if there is an existing key with a bucket value.
-		if bytes.Equal(pair.key, k) && (flags&bucketLeafFlag) != 0 {
+		exists := bytes.Equal(pair.key, k)
+		if exists && (flags&bucketLeafFlag) != 0 {
 			return ErrIncompatibleValue
 		}
 		// Insert into node. key and value are already cloned
 		if pair.value == nil {
 			c.node().del(pair.key)
+			if exists {
+				b.notifyDelete(pair.key, v)
+			}
 		} else {
 			c.node().put(pair.key, pair.key, pair.value, 0, 0)
+			b.notifyPut(pair.key, v, exists, pair.value)
 		}
 	}
 	return nil
 }
 
+// MultiGet retrieves, for each start/limit pair of keys, a copy of the value
+// of the first key in the bucket within [start, limit). Entries for ranges
+// holding no value are nil. Nested buckets are skipped.
+// The ranges are visited in order of their start key with a single cursor,
+// and the values returned in the order the ranges were given.
 func (b *Bucket) MultiGet(pairs ...[]byte) (values [][]byte, err error) {
 	if len(pairs) == 0 || len(pairs)%2 == 1 {
 		return nil, ErrInvalidArgNumber
 	}
-	c := b.Cursor()
-	var k, v []byte
-	var flags uint32
 	values = make([][]byte, len(pairs)/2)
+	starts := make([][]byte, len(pairs)/2)
 	for i := 0; i < len(pairs); i += 2 {
 		start := pairs[i]
 		limit := pairs[i+1]
@@ -419,13 +790,22 @@ func (b *Bucket) MultiGet(pairs ...[]byte) (values [][]byte, err error) {
 		} else if len(limit) > MaxKeySize {
 			return values, ErrKeyTooLarge
 		}
+		starts[i/2] = start
+	}
+
+	c := b.Cursor()
+	var k, v []byte
+	var flags uint32
+	for n, i := range sortedKeyOrder(starts) {
+		start := pairs[2*i]
+		limit := pairs[2*i+1]
 		// Move cursor to correct position
-		if i == 0 {
+		if n == 0 {
 			k, v, flags = c.seek(start)
 		} else {
 			// It might be that the current cursor position already satisfies
 			if bytes.Compare(k, start) != -1 && bytes.Compare(k, limit) == -1 {
-				values[i/2] = cloneBytes(v)
+				values[i] = cloneBytes(v)
 				continue
 			}
 			k, v, flags = c.seekTo(start)
@@ -442,12 +822,64 @@ func (b *Bucket) MultiGet(pairs ...[]byte) (values [][]byte, err error) {
 			break
 		}
 		if bytes.Compare(k, start) != -1 && bytes.Compare(k, limit) == -1 {
-			values[i/2] = cloneBytes(v)
+			values[i] = cloneBytes(v)
 		}
 	}
 	return values, nil
 }
 
+// sortedKeyOrder returns the indexes of keys in ascending order of the keys
+// they refer to, keeping equal keys in their original order.
+func sortedKeyOrder(keys [][]byte) []int {
+	order := make([]int, len(keys))
+	for i := range order {
+		order[i] = i
+	}
+
+	less := func(i, j int) bool { return bytes.Compare(keys[order[i]], keys[order[j]]) < 0 }
+	if !sort.SliceIsSorted(order, less) {
+		sort.SliceStable(order, less)
+	}
+	return order
+}
+
+// MultiGetWithPresence retrieves the values for multiple keys in the bucket.
+// Unlike Get, it distinguishes missing keys from keys holding an empty value:
+// present[i] reports whether keys[i] exists and holds a value, in which case
+// values[i] holds a copy of it. Keys referring to nested buckets are reported
+// as not present.
+// As with MultiGet, the keys are looked up in sorted order in a single cursor
+// pass.
+// Returns an error if any key is blank or too large.
+func (b *Bucket) MultiGetWithPresence(keys ...[]byte) (values [][]byte, present []bool, err error) {
+	for _, key := range keys {
+		if len(key) == 0 {
+			return nil, nil, ErrKeyRequired
+		} else if len(key) > MaxKeySize {
+			return nil, nil, ErrKeyTooLarge
+		}
+	}
+
+	values = make([][]byte, len(keys))
+	present = make([]bool, len(keys))
+	c := b.Cursor()
+	for n, i := range sortedKeyOrder(keys) {
+		var k, v []byte
+		var flags uint32
+		if n == 0 {
+			k, v, flags = c.seek(keys[i])
+		} else {
+			k, v, flags = c.seekTo(keys[i])
+		}
+
+		if bytes.Equal(keys[i], k) && (flags&bucketLeafFlag) == 0 {
+			values[i] = cloneBytes(v)
+			present[i] = true
+		}
+	}
+	return values, present, nil
+}
+
 // Delete removes a key from the bucket.
 // If the key does not exist then nothing is done and a nil error is returned.
 // Returns an error if the bucket was created from a read-only transaction.
@@ -460,7 +892,7 @@ func (b *Bucket) Delete(key []byte) error {
 
 	// Move cursor to correct position.
 	c := b.Cursor()
-	k, _, flags := c.seek(key)
+	k, v, flags := c.seek(key)
 
 	// Return nil if the key doesn't exist.
 	if !bytes.Equal(key, k) {
@@ -474,10 +906,103 @@ func (b *Bucket) Delete(key []byte) error {
 
 	// Delete the node if we have a matching key.
 	c.node().del(key)
+	b.notifyDelete(key, v)
 
 	return nil
 }
 
+// MultiDelete removes multiple keys from the bucket.
+// Keys that do not exist are skipped and do not cause an error.
+// Iteration stops at the first error, which is returned to the caller; any
+// keys deleted before that point are rolled back along with the transaction.
+func (b *Bucket) MultiDelete(keys ...[]byte) error {
+	for _, key := range keys {
+		if err := b.Delete(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeletePrefix removes every key in the bucket that begins with prefix, and
+// returns the number of keys removed. Nested buckets under the prefix are left
+// in place. An empty prefix removes every key in the bucket.
+// Returns an error if the bucket was created from a read-only transaction.
+func (b *Bucket) DeletePrefix(prefix []byte) (deleted int, err error) {
+	if b.tx.db == nil {
+		return 0, ErrTxClosed
+	} else if !b.Writable() {
+		return 0, ErrTxNotWritable
+	}
+
+	c := b.Cursor()
+	k, v := c.Seek(prefix)
+	for k != nil && bytes.HasPrefix(k, prefix) {
+		if v == nil {
+			k, v = c.Next()
+			continue
+		}
+
+		if err := c.Delete(); err != nil {
+			return deleted, err
+		}
+		deleted++
+
+		// Deleting shifts the following keys under the cursor, so seek past
+		// the deleted key again rather than calling Next.
+		k, v = c.Seek(k)
+	}
+	return deleted, nil
+}
+
+// OnChange registers a function to be called whenever a key in the bucket is
+// written or deleted within the current transaction. The function receives the
+// key along with its previous and new values; oldValue is nil when the key was
+// inserted and newValue is nil when it was deleted, while empty values are
+// reported as empty, non-nil slices. Nested buckets are not reported.
+//
+// Handlers are called synchronously, in registration order, right after each
+// write is applied, and so run before the data is durable: the transaction
+// may still fail to commit or be rolled back. They only apply to this Bucket
+// within its transaction and must be registered again in every Tx. The values
+// are only valid for the life of the transaction. A handler may write to other
+// buckets but must not modify this one.
+func (b *Bucket) OnChange(fn func(key, oldValue, newValue []byte)) {
+	b.changeHandlers = append(b.changeHandlers, fn)
+}
+
+// notifyPut calls the change handlers for a write of newValue to key.
+// oldValue is the previous value if the key existed.
+func (b *Bucket) notifyPut(key, oldValue []byte, existed bool, newValue []byte) {
+	if len(b.changeHandlers) == 0 {
+		return
+	}
+	if !existed {
+		oldValue = nil
+	} else if oldValue == nil {
+		oldValue = []byte{}
+	}
+	if newValue == nil {
+		newValue = []byte{}
+	}
+	for _, fn := range b.changeHandlers {
+		fn(key, oldValue, newValue)
+	}
+}
+
+// notifyDelete calls the change handlers for the deletion of key.
+func (b *Bucket) notifyDelete(key, oldValue []byte) {
+	if len(b.changeHandlers) == 0 {
+		return
+	}
+	if oldValue == nil {
+		oldValue = []byte{}
+	}
+	for _, fn := range b.changeHandlers {
+		fn(key, oldValue, nil)
+	}
+}
+
 // Sequence returns the current integer for the bucket without incrementing it.
 func (b *Bucket) Sequence() uint64 { return b.bucket.sequence }
 
@@ -519,6 +1044,30 @@ func (b *Bucket) NextSequence() (uint64, error) {
 	return b.bucket.sequence, nil
 }
 
+// ReserveSequence advances the bucket's sequence by n and returns the first
+// reserved value, so that the caller can assign start through start+n-1
+// itself. Reserving zero values returns the next sequence without advancing
+// it.
+func (b *Bucket) ReserveSequence(n uint64) (start uint64, err error) {
+	if b.tx.db == nil {
+		return 0, ErrTxClosed
+	} else if !b.Writable() {
+		return 0, ErrTxNotWritable
+	} else if b.bucket.sequence+n < b.bucket.sequence {
+		return 0, ErrSequenceOverflow
+	}
+
+	// Materialize the root node if it hasn't been already so that the
+	// bucket will be saved during commit.
+	if b.rootNode == nil {
+		_ = b.node(b.root, nil)
+	}
+
+	start = b.bucket.sequence + 1
+	b.bucket.sequence += n
+	return start, nil
+}
+
 // ForEach executes a function for each key/value pair in a bucket.
 // If the provided function returns an error then the iteration is stopped and
 // the error is returned to the caller. The provided function must not modify
@@ -536,15 +1085,322 @@ func (b *Bucket) ForEach(fn func(k, v []byte) error) error {
 	return nil
 }
 
+// ForEachContext executes a function for each key/value pair in a bucket,
+// as ForEach does, but also stops once ctx is done. The context is checked
+// every 1024 keys, and its error is returned if it has been cancelled.
+func (b *Bucket) ForEachContext(ctx context.Context, fn func(k, v []byte) error) error {
+	if b.tx.db == nil {
+		return ErrTxClosed
+	}
+	c := b.Cursor()
+	var i int
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		if i%forEachContextCheckInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+		}
+		i++
+		if err := fn(k, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ForEachReverse executes a function for each key/value pair in a bucket in
+// descending key order. Nested buckets are passed to the function with a nil
+// value. If the provided function returns an error then the iteration is
+// stopped and the error is returned to the caller. The provided function must
+// not modify the bucket; this will result in undefined behavior.
+func (b *Bucket) ForEachReverse(fn func(k, v []byte) error) error {
+	if b.tx.db == nil {
+		return ErrTxClosed
+	}
+	c := b.Cursor()
+	for k, v := c.Last(); k != nil; k, v = c.Prev() {
+		if err := fn(k, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ForEachRange executes a function for each key/value pair in a bucket whose
+// key falls within [start, end). A nil start begins at the first key and a nil
+// end continues to the last key of the bucket. If the provided function
+// returns an error then the iteration is stopped and the error is returned to
+// the caller. The provided function must not modify the bucket; this will
+// result in undefined behavior.
+func (b *Bucket) ForEachRange(start, end []byte, fn func(k, v []byte) error) error {
+	if b.tx.db == nil {
+		return ErrTxClosed
+	}
+	c := b.Cursor()
+	var k, v []byte
+	if start == nil {
+		k, v = c.First()
+	} else {
+		k, v = c.Seek(start)
+	}
+	for ; k != nil && (end == nil || bytes.Compare(k, end) < 0); k, v = c.Next() {
+		if err := fn(k, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ForEachPrefix executes a function for each key/value pair in a bucket whose
+// key begins with prefix. An empty prefix iterates over the whole bucket.
+// If the provided function returns an error then the iteration is stopped and
+// the error is returned to the caller. The provided function must not modify
+// the bucket; this will result in undefined behavior.
+func (b *Bucket) ForEachPrefix(prefix []byte, fn func(k, v []byte) error) error {
+	if b.tx.db == nil {
+		return ErrTxClosed
+	}
+	c := b.Cursor()
+	for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+		if err := fn(k, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// errShardStopped is returned within ForEachParallel to stop the remaining
+// shards once one of them has failed.
+var errShardStopped = errors.New("shard stopped")
+
+// ForEachParallel executes a function for each key/value pair in a bucket,
+// splitting the keys at the points returned by SampleKeys into up to shards
+// ranges that are scanned concurrently, one goroutine each. The function must therefore be safe to call from
+// multiple goroutines, and keys are not visited in order across shards.
+// If the provided function returns an error then the remaining shards are
+// stopped and the first error is returned to the caller. The provided
+// function must not modify the bucket; this will result in undefined
+// behavior.
+//
+// Each shard reads through its own read-only view of the bucket's
+// transaction, so all shards see the same snapshot and no further
+// transactions are opened, which could otherwise block behind a writer
+// waiting to remap the database. Buckets of a writable transaction, whose
+// changes only live in that transaction, are scanned sequentially instead.
+func (b *Bucket) ForEachParallel(shards int, fn func(k, v []byte) error) error {
+	if b.tx.db == nil {
+		return ErrTxClosed
+	}
+
+	var splits [][]byte
+	if !b.tx.writable && shards > 1 {
+		samples, err := b.SampleKeys(shards)
+		if err != nil {
+			return err
+		}
+		if len(samples) > 1 {
+			splits = samples[1:]
+		}
+	}
+	if len(splits) == 0 {
+		return b.ForEach(fn)
+	}
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+		stopped  int32
+	)
+	for i := 0; i <= len(splits); i++ {
+		var start, end []byte
+		if i > 0 {
+			start = splits[i-1]
+		}
+		if i < len(splits) {
+			end = splits[i]
+		}
+
+		wg.Add(1)
+		go func(shard *Bucket) {
+			defer wg.Done()
+			err := shard.ForEachRange(start, end, func(k, v []byte) error {
+				if atomic.LoadInt32(&stopped) != 0 {
+					return errShardStopped
+				}
+				return fn(k, v)
+			})
+			if err != nil && err != errShardStopped {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = err
+					atomic.StoreInt32(&stopped, 1)
+				}
+				mu.Unlock()
+			}
+		}(b.readView())
+	}
+	wg.Wait()
+
+	return firstErr
+}
+
+// readView returns a copy of a bucket of a read-only transaction that reads
+// through a transaction of its own sharing the same snapshot, so that it can
+// be used concurrently with b.
+func (b *Bucket) readView() *Bucket {
+	tx := &Tx{db: b.tx.db, meta: b.tx.meta}
+	view := *b
+	view.tx = tx
+	return &view
+}
+
+// SampleKeys returns about n keys spread evenly across the bucket, in key
+// order and starting with its first key, for use as split points when
+// partitioning a scan of the bucket.
+//
+// Keys are sampled from the boundaries between the pages of the bucket's
+// B+tree, walking down its branch pages only as far as is needed to find n
+// of them, so the cost grows with the number of branch pages rather than the
+// number of keys. Consecutive samples are therefore roughly, not exactly,
+// the same number of keys apart. A bucket held in a single page is sampled
+// from its keys directly. Fewer than n keys are returned when the bucket has
+// fewer keys than n, or when its leaf pages are too few to provide n
+// boundaries. Nested buckets are sampled like any other key.
+// The returned keys are only valid for the life of the transaction.
+// Returns ErrInvalidLimit if n is not positive.
+func (b *Bucket) SampleKeys(n int) ([][]byte, error) {
+	if b.tx.db == nil {
+		return nil, ErrTxClosed
+	} else if n <= 0 {
+		return nil, ErrInvalidLimit
+	}
+
+	keys, children := sampleElements(b.pageNode(b.root))
+	for len(keys) < n && len(children) > 0 {
+		var nextKeys [][]byte
+		var nextChildren []pgid
+		for _, id := range children {
+			ks, cs := sampleElements(b.pageNode(id))
+			if cs == nil {
+				// All leaves are at the same depth, so this is the leaf
+				// level: stop at the boundaries of the level above it.
+				nextKeys = nil
+				break
+			}
+			nextKeys = append(nextKeys, ks...)
+			nextChildren = append(nextChildren, cs...)
+		}
+		if nextKeys == nil {
+			break
+		}
+		keys, children = nextKeys, nextChildren
+	}
+
+	if len(keys) <= n {
+		return keys, nil
+	}
+	samples := make([][]byte, n)
+	for i := range samples {
+		samples[i] = keys[i*len(keys)/n]
+	}
+	return samples, nil
+}
+
+// sampleElements returns the keys of the elements of a page or node, along
+// with the child page ids when it is a branch. children is nil for leaves.
+func sampleElements(p *page, n *node) (keys [][]byte, children []pgid) {
+	if n != nil {
+		for _, inode := range n.inodes {
+			keys = append(keys, inode.key)
+			if !n.isLeaf {
+				children = append(children, inode.pgid)
+			}
+		}
+		return keys, children
+	}
+
+	isBranch := (p.flags & branchPageFlag) != 0
+	for i := 0; i < int(p.count); i++ {
+		if isBranch {
+			elem := p.branchPageElement(uint16(i))
+			keys = append(keys, append(p.keyPrefix(), elem.key()...))
+			children = append(children, elem.pgid)
+		} else {
+			keys = append(keys, append(p.keyPrefix(), p.leafPageElement(uint16(i)).key()...))
+		}
+	}
+	return keys, children
+}
+
+// Page returns up to limit key/value pairs whose keys sort strictly after
+// after, or from the first key when after is nil. Nested buckets are returned
+// with a nil value. The returned pairs are copies and remain valid after the
+// transaction is closed.
+//
+// next is the last key returned, to be passed as after to fetch the following
+// page, or nil once there are no keys left.
+func (b *Bucket) Page(after []byte, limit int) (pairs []WritePair, next []byte, err error) {
+	if b.tx.db == nil {
+		return nil, nil, ErrTxClosed
+	} else if limit <= 0 {
+		return nil, nil, ErrInvalidLimit
+	}
+
+	c := b.Cursor()
+	var k, v []byte
+	if after == nil {
+		k, v = c.First()
+	} else if k, v = c.Seek(after); bytes.Equal(k, after) {
+		k, v = c.Next()
+	}
+
+	for ; k != nil && len(pairs) < limit; k, v = c.Next() {
+		pair := WritePair{key: cloneBytes(k)}
+		if v != nil {
+			pair.value = cloneBytes(v)
+		}
+		pairs = append(pairs, pair)
+	}
+
+	if k != nil {
+		next = pairs[len(pairs)-1].key
+	}
+	return pairs, next, nil
+}
+
 // ForEachBucket executes a function for each bucket in this bucket.
 // If the provided function returns an error then the iteration is stopped and
 // the error is returned to the caller.
 func (b *Bucket) ForEachBucket(fn func(name []byte, sb *Bucket) error) error {
 	return b.ForEach(func(k, v []byte) error {
+		if v != nil {
+			return nil
+		}
 		return fn(k, b.Bucket(k))
 	})
 }
 
+// ForEachScalar executes a function for each key/value pair in this bucket,
+// skipping nested buckets.
+// If the provided function returns an error then the iteration is stopped and
+// the error is returned to the caller.
+func (b *Bucket) ForEachScalar(fn func(k, v []byte) error) error {
+	return b.ForEach(func(k, v []byte) error {
+		if v == nil {
+			return nil
+		}
+		return fn(k, v)
+	})
+}
+
+// BucketStats returns the same statistics as Stats. It lets code working
+// with a Bucketish get bucket statistics from a Tx, whose Stats method
+// returns transaction statistics instead.
+func (b *Bucket) BucketStats() BucketStats {
+	return b.Stats()
+}
+
 // Stat returns stats on a bucket.
 func (b *Bucket) Stats() BucketStats {
 	var s, subStats BucketStats
@@ -627,21 +1483,44 @@ func (b *Bucket) Stats() BucketStats {
 	return s
 }
 
-func (b *Bucket) StandaloneSize() (used uint64) {
+// StandaloneSize returns the number of bytes used by the pages of this bucket,
+// excluding its nested buckets.
+func (b *Bucket) StandaloneSize() uint64 {
+	s := b.SizeBreakdown()
+	return s.LeafBytes + s.BranchBytes + s.InlineBucketBytes
+}
+
+// SizeBreakdown returns the bytes used by the pages of this bucket, excluding
+// its nested buckets, split by the kind of page they are used in.
+func (b *Bucket) SizeBreakdown() (s SizeStats) {
 	b.forEachPage(func(p *page, depth int) {
 		if (p.flags & leafPageFlag) != 0 {
-			used += uint64(pageHeaderSize)
+			used := uint64(pageHeaderSize)
 
 			if p.count != 0 {
 				used += uint64(leafPageElementSize * int(p.count-1))
 				lastElement := p.leafPageElement(p.count - 1)
 				used += uint64(lastElement.pos + lastElement.ksize + lastElement.vsize)
+
+				// Keys are stored without the prefix shared by all keys of
+				// the page, which is stored once.
+				s.KeyValueBytes += uint64(p.prefixsize)
+				for i := uint16(0); i < p.count; i++ {
+					e := p.leafPageElement(i)
+					s.KeyValueBytes += uint64(e.ksize + e.vsize)
+				}
+			}
+
+			if b.root == 0 {
+				s.InlineBucketBytes += used
+			} else {
+				s.LeafBytes += used
 			}
 		} else if (p.flags & branchPageFlag) != 0 {
-			used += uint64(pageHeaderSize + (branchPageElementSize * int(p.count-1)))
+			s.BranchBytes += uint64(pageHeaderSize + (branchPageElementSize * int(p.count-1)))
 
 			lastElement := p.branchPageElement(p.count - 1)
-			used += uint64(lastElement.pos + lastElement.ksize)
+			s.BranchBytes += uint64(lastElement.pos + lastElement.ksize)
 		}
 	})
 
@@ -660,6 +1539,22 @@ func (b *Bucket) forEachPage(fn func(*page, int)) {
 	b.tx.forEachPage(b.root, 0, fn)
 }
 
+// pageCount returns the number of pages, including overflow pages, used by
+// the bucket and its nested buckets.
+func (b *Bucket) pageCount() int {
+	var n int
+	if b.page == nil {
+		b.forEachPage(func(p *page, _ int) {
+			n += 1 + int(p.overflow)
+		})
+	}
+	_ = b.ForEachBucket(func(_ []byte, sb *Bucket) error {
+		n += sb.pageCount()
+		return nil
+	})
+	return n
+}
+
 // forEachPageNode iterates over every page (or node) in a bucket.
 // This also includes inline pages.
 func (b *Bucket) forEachPageNode(fn func(*page, *node, int)) {
@@ -953,6 +1848,23 @@ func (s *BucketStats) Add(other BucketStats) {
 	s.InlineBucketInuse += other.InlineBucketInuse
 }
 
+// SizeStats breaks down the bytes used by the pages of a single bucket.
+type SizeStats struct {
+	LeafBytes         uint64 // bytes used in leaf pages
+	BranchBytes       uint64 // bytes used in branch pages
+	InlineBucketBytes uint64 // bytes used by the bucket when stored inline in its parent
+	KeyValueBytes     uint64 // bytes of stored key and value data, included in LeafBytes or InlineBucketBytes
+}
+
+// checkValueSize returns a *ValueTooLargeError if a value of size bytes
+// stored under key would exceed the database's value size limit.
+func (b *Bucket) checkValueSize(key []byte, size int) error {
+	if max := b.tx.db.maxValueSize; size > max {
+		return &ValueTooLargeError{Key: cloneBytes(key), Size: size, MaxSize: max}
+	}
+	return nil
+}
+
 // cloneBytes returns a copy of a given slice.
 func cloneBytes(v []byte) []byte {
 	var clone = make([]byte, len(v))
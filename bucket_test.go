@@ -2,14 +2,19 @@ package bbolt_test
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"io"
 	"log"
+	"math"
 	"math/rand"
 	"os"
+	"reflect"
 	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"testing/quick"
 
@@ -121,6 +126,161 @@ func TestBucket_Get_Capacity(t *testing.T) {
 	}
 }
 
+// Ensure that Has reports scalar keys, both before and after they are
+// written to prefix-compressed pages.
+func TestBucket_Has(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	check := func(tx *bolt.Tx) {
+		b := tx.Bucket([]byte("widgets"))
+		for i := 0; i < 1000; i++ {
+			if !b.Has([]byte(fmt.Sprintf("prefix-%04d", i))) {
+				t.Fatalf("expected key %d to exist", i)
+			}
+		}
+		for _, k := range []string{"prefix-", "prefix-10000", "prefix-0001x", "other", "child"} {
+			if b.Has([]byte(k)) {
+				t.Fatalf("unexpected key: %s", k)
+			}
+		}
+		if tx.Has([]byte("widgets")) {
+			t.Fatal("expected root to report no keys")
+		}
+	}
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 1000; i++ {
+			if err := b.Put([]byte(fmt.Sprintf("prefix-%04d", i)), make([]byte, 100)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := b.CreateBucket([]byte("child")); err != nil {
+			t.Fatal(err)
+		}
+		check(tx)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.View(func(tx *bolt.Tx) error {
+		check(tx)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that First and Last return the smallest and largest keys.
+func TestBucket_FirstLast(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		var root bolt.Bucketish = tx
+		if k, v := root.First(); k != nil || v != nil {
+			t.Fatalf("unexpected first bucket: %q", k)
+		}
+
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tx.CreateBucket([]byte("gadgets")); err != nil {
+			t.Fatal(err)
+		}
+		if k, v := b.First(); k != nil || v != nil {
+			t.Fatalf("unexpected first key: %q", k)
+		} else if k, v := b.Last(); k != nil || v != nil {
+			t.Fatalf("unexpected last key: %q", k)
+		}
+
+		for i := 1; i <= 1000; i++ {
+			if err := b.Put(u64tob(uint64(i)), []byte(fmt.Sprint(i))); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := b.CreateBucket(u64tob(0)); err != nil {
+			t.Fatal(err)
+		}
+
+		if k, v := b.First(); !bytes.Equal(k, u64tob(0)) || v != nil {
+			t.Fatalf("unexpected first: %x = %q", k, v)
+		} else if k, v := b.Last(); !bytes.Equal(k, u64tob(1000)) || string(v) != "1000" {
+			t.Fatalf("unexpected last: %x = %q", k, v)
+		}
+		if k, _ := root.First(); string(k) != "gadgets" {
+			t.Fatalf("unexpected first bucket: %q", k)
+		} else if k, _ := root.Last(); string(k) != "widgets" {
+			t.Fatalf("unexpected last bucket: %q", k)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that a large value can be read in chunks through a reader.
+func TestBucket_GetReader(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	value := []byte(strings.Repeat("0123456789abcdef", 64*1024))
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := b.Put([]byte("foo"), value); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := b.CreateBucket([]byte("bar")); err != nil {
+			t.Fatal(err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		r, err := b.GetReader([]byte("foo"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var buf bytes.Buffer
+		chunk := make([]byte, 4096)
+		for {
+			n, err := r.Read(chunk)
+			buf.Write(chunk[:n])
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatal(err)
+			}
+		}
+		if !bytes.Equal(buf.Bytes(), b.Get([]byte("foo"))) {
+			t.Fatal("unexpected value read from reader")
+		}
+
+		if _, err := b.GetReader([]byte("baz")); err != bolt.ErrKeyNotFound {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if _, err := b.GetReader([]byte("bar")); err != bolt.ErrIncompatibleValue {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
 // Ensure that a bucket can write a key/value.
 func TestBucket_Put(t *testing.T) {
 	db := MustOpenDB()
@@ -308,8 +468,8 @@ func TestBucket_Put_ReadOnly(t *testing.T) {
 	}
 }
 
-// Ensure that a bucket can delete an existing key.
-func TestBucket_Delete(t *testing.T) {
+// Ensure that GetOrPut stores a default only when the key is missing.
+func TestBucket_GetOrPut(t *testing.T) {
 	db := MustOpenDB()
 	defer db.MustClose()
 
@@ -318,14 +478,46 @@ func TestBucket_Delete(t *testing.T) {
 		if err != nil {
 			t.Fatal(err)
 		}
-		if err := b.Put([]byte("foo"), []byte("bar")); err != nil {
+		if err := b.Put([]byte("empty"), []byte{}); err != nil {
 			t.Fatal(err)
 		}
-		if err := b.Delete([]byte("foo")); err != nil {
+		if _, err := b.CreateBucket([]byte("child")); err != nil {
 			t.Fatal(err)
 		}
-		if v := b.Get([]byte("foo")); v != nil {
-			t.Fatalf("unexpected value: %v", v)
+
+		if v, created, err := b.GetOrPut([]byte("foo"), []byte("bar")); err != nil {
+			t.Fatal(err)
+		} else if !created || !bytes.Equal(v, []byte("bar")) {
+			t.Fatalf("unexpected result: %q, %v", v, created)
+		}
+		if v, created, err := b.GetOrPut([]byte("foo"), []byte("baz")); err != nil {
+			t.Fatal(err)
+		} else if created || !bytes.Equal(v, []byte("bar")) {
+			t.Fatalf("unexpected result: %q, %v", v, created)
+		}
+		if v, created, err := b.GetOrPut([]byte("empty"), []byte("baz")); err != nil {
+			t.Fatal(err)
+		} else if created || len(v) != 0 {
+			t.Fatalf("unexpected result: %q, %v", v, created)
+		}
+		if _, _, err := b.GetOrPut([]byte("child"), []byte("baz")); err != bolt.ErrIncompatibleValue {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if _, _, err := tx.GetOrPut([]byte("widgets"), []byte("baz")); err != bolt.ErrIncompatibleValue {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		if v := b.Get([]byte("foo")); !bytes.Equal(v, []byte("bar")) {
+			t.Fatalf("unexpected value: %q", v)
+		}
+		if _, _, err := b.GetOrPut([]byte("foo"), []byte("bar")); err != bolt.ErrTxNotWritable {
+			t.Fatalf("unexpected error: %s", err)
 		}
 		return nil
 	}); err != nil {
@@ -333,8 +525,8 @@ func TestBucket_Delete(t *testing.T) {
 	}
 }
 
-// Ensure that deleting a large set of keys will work correctly.
-func TestBucket_Delete_Large(t *testing.T) {
+// Ensure that PutIfAbsent only writes keys that do not exist.
+func TestBucket_PutIfAbsent(t *testing.T) {
 	db := MustOpenDB()
 	defer db.MustClose()
 
@@ -343,24 +535,33 @@ func TestBucket_Delete_Large(t *testing.T) {
 		if err != nil {
 			t.Fatal(err)
 		}
-
-		for i := 0; i < 100; i++ {
-			if err := b.Put([]byte(strconv.Itoa(i)), []byte(strings.Repeat("*", 1024))); err != nil {
-				t.Fatal(err)
-			}
+		if err := b.Put([]byte("empty"), []byte{}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := b.CreateBucket([]byte("child")); err != nil {
+			t.Fatal(err)
 		}
 
-		return nil
-	}); err != nil {
-		t.Fatal(err)
-	}
-
-	if err := db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte("widgets"))
-		for i := 0; i < 100; i++ {
-			if err := b.Delete([]byte(strconv.Itoa(i))); err != nil {
-				t.Fatal(err)
-			}
+		if ok, err := b.PutIfAbsent([]byte("foo"), []byte("bar")); err != nil {
+			t.Fatal(err)
+		} else if !ok {
+			t.Fatal("expected write")
+		}
+		if ok, err := b.PutIfAbsent([]byte("foo"), []byte("baz")); err != nil {
+			t.Fatal(err)
+		} else if ok {
+			t.Fatal("unexpected write")
+		}
+		if ok, err := b.PutIfAbsent([]byte("empty"), []byte("baz")); err != nil {
+			t.Fatal(err)
+		} else if ok {
+			t.Fatal("unexpected write")
+		}
+		if _, err := b.PutIfAbsent([]byte("child"), []byte("baz")); err != bolt.ErrIncompatibleValue {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if _, err := tx.PutIfAbsent([]byte("widgets"), []byte("baz")); err != bolt.ErrIncompatibleValue {
+			t.Fatalf("unexpected error: %s", err)
 		}
 		return nil
 	}); err != nil {
@@ -369,10 +570,13 @@ func TestBucket_Delete_Large(t *testing.T) {
 
 	if err := db.View(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte("widgets"))
-		for i := 0; i < 100; i++ {
-			if v := b.Get([]byte(strconv.Itoa(i))); v != nil {
-				t.Fatalf("unexpected value: %v, i=%d", v, i)
-			}
+		if v := b.Get([]byte("foo")); !bytes.Equal(v, []byte("bar")) {
+			t.Fatalf("unexpected value: %q", v)
+		} else if v := b.Get([]byte("empty")); len(v) != 0 {
+			t.Fatalf("unexpected value: %q", v)
+		}
+		if _, err := b.PutIfAbsent([]byte("bat"), []byte("bar")); err != bolt.ErrTxNotWritable {
+			t.Fatalf("unexpected error: %s", err)
 		}
 		return nil
 	}); err != nil {
@@ -380,70 +584,64 @@ func TestBucket_Delete_Large(t *testing.T) {
 	}
 }
 
-// Deleting a very large list of keys will cause the freelist to use overflow.
-func TestBucket_Delete_FreelistOverflow(t *testing.T) {
-	if testing.Short() {
-		t.Skip("skipping test in short mode.")
-	}
-
+// Ensure that CompareAndSwap only writes when the current value matches.
+func TestBucket_CompareAndSwap(t *testing.T) {
 	db := MustOpenDB()
 	defer db.MustClose()
 
-	k := make([]byte, 16)
-	for i := uint64(0); i < 10000; i++ {
-		if err := db.Update(func(tx *bolt.Tx) error {
-			b, err := tx.CreateBucketIfNotExists([]byte("0"))
-			if err != nil {
-				t.Fatalf("bucket error: %s", err)
-			}
-
-			for j := uint64(0); j < 1000; j++ {
-				binary.BigEndian.PutUint64(k[:8], i)
-				binary.BigEndian.PutUint64(k[8:], j)
-				if err := b.Put(k, nil); err != nil {
-					t.Fatalf("put error: %s", err)
-				}
-			}
-
-			return nil
-		}); err != nil {
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := b.CreateBucket([]byte("child")); err != nil {
 			t.Fatal(err)
 		}
-	}
 
-	// Delete all of them in one large transaction
-	if err := db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte("0"))
-		c := b.Cursor()
-		for k, _ := c.First(); k != nil; k, _ = c.Next() {
-			if err := c.Delete(); err != nil {
+		for _, tt := range []struct {
+			old, new []byte
+			swapped  bool
+			value    []byte
+		}{
+			{[]byte("bar"), []byte("baz"), false, nil},
+			{nil, []byte("bar"), true, []byte("bar")},
+			{nil, []byte("baz"), false, []byte("bar")},
+			{[]byte("baz"), []byte("bat"), false, []byte("bar")},
+			{[]byte("bar"), []byte{}, true, []byte{}},
+			{nil, []byte("bar"), false, []byte{}},
+			{[]byte{}, nil, true, nil},
+		} {
+			swapped, err := b.CompareAndSwap([]byte("foo"), tt.old, tt.new)
+			if err != nil {
 				t.Fatal(err)
+			} else if swapped != tt.swapped {
+				t.Fatalf("CompareAndSwap(%q, %q): unexpected swapped: %v", tt.old, tt.new, swapped)
 			}
+			if v := b.Get([]byte("foo")); !bytes.Equal(v, tt.value) || (v == nil) != (tt.value == nil) {
+				t.Fatalf("CompareAndSwap(%q, %q): unexpected value: %q", tt.old, tt.new, v)
+			}
+		}
+
+		if _, err := b.CompareAndSwap([]byte("child"), nil, []byte("bar")); err != bolt.ErrIncompatibleValue {
+			t.Fatalf("unexpected error: %s", err)
 		}
 		return nil
 	}); err != nil {
 		t.Fatal(err)
 	}
 
-	// Check more than an overflow's worth of pages are freed.
-	stats := db.Stats()
-	freePages := stats.FreePageN + stats.PendingPageN
-	if freePages <= 0xFFFF {
-		t.Fatalf("expected more than 0xFFFF free pages, got %v", freePages)
-	}
-
-	// Free page count should be preserved on reopen.
-	if err := db.DB.Close(); err != nil {
+	if err := db.View(func(tx *bolt.Tx) error {
+		if _, err := tx.Bucket([]byte("widgets")).CompareAndSwap([]byte("foo"), nil, []byte("bar")); err != bolt.ErrTxNotWritable {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		return nil
+	}); err != nil {
 		t.Fatal(err)
 	}
-	db.MustReopen()
-	if reopenFreePages := db.Stats().FreePageN; freePages != reopenFreePages {
-		t.Fatalf("expected %d free pages, got %+v", freePages, db.Stats())
-	}
 }
 
-// Ensure that deleting of non-existing key is a no-op.
-func TestBucket_Delete_NonExisting(t *testing.T) {
+// Ensure that Modify writes, deletes or leaves keys according to fn.
+func TestBucket_Modify(t *testing.T) {
 	db := MustOpenDB()
 	defer db.MustClose()
 
@@ -452,22 +650,74 @@ func TestBucket_Delete_NonExisting(t *testing.T) {
 		if err != nil {
 			t.Fatal(err)
 		}
+		if err := b.Put([]byte("foo"), []byte("bar")); err != nil {
+			t.Fatal(err)
+		}
+		if err := b.Put([]byte("empty"), []byte{}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := b.CreateBucket([]byte("child")); err != nil {
+			t.Fatal(err)
+		}
 
-		if _, err = b.CreateBucket([]byte("nested")); err != nil {
+		// Existing values are passed to fn and replaced.
+		if err := b.Modify([]byte("foo"), func(old []byte) ([]byte, error) {
+			return append(append([]byte{}, old...), "baz"...), nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		// Missing keys are passed as nil, and empty values as empty.
+		if err := b.Modify([]byte("new"), func(old []byte) ([]byte, error) {
+			if old != nil {
+				t.Fatalf("unexpected old value: %q", old)
+			}
+			return []byte("created"), nil
+		}); err != nil {
 			t.Fatal(err)
 		}
+		if err := b.Modify([]byte("empty"), func(old []byte) ([]byte, error) {
+			if old == nil || len(old) != 0 {
+				t.Fatalf("unexpected old value: %q", old)
+			}
+			return nil, nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		// Errors from fn leave the value untouched.
+		marker := errors.New("marker")
+		if err := b.Modify([]byte("foo"), func(old []byte) ([]byte, error) {
+			return nil, marker
+		}); err != marker {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if err := b.Modify([]byte("child"), func(old []byte) ([]byte, error) {
+			t.Fatal("unexpected call")
+			return nil, nil
+		}); err != bolt.ErrIncompatibleValue {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := tx.Modify([]byte("widgets"), nil); err != bolt.ErrIncompatibleValue {
+			t.Fatalf("unexpected error: %v", err)
+		}
 		return nil
 	}); err != nil {
 		t.Fatal(err)
 	}
 
-	if err := db.Update(func(tx *bolt.Tx) error {
+	if err := db.View(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte("widgets"))
-		if err := b.Delete([]byte("foo")); err != nil {
-			t.Fatal(err)
+		if v := b.Get([]byte("foo")); !bytes.Equal(v, []byte("barbaz")) {
+			t.Fatalf("unexpected value: %q", v)
+		} else if v := b.Get([]byte("new")); !bytes.Equal(v, []byte("created")) {
+			t.Fatalf("unexpected value: %q", v)
+		} else if b.Has([]byte("empty")) {
+			t.Fatal("expected key to be deleted")
 		}
-		if b.Bucket([]byte("nested")) == nil {
-			t.Fatal("nested bucket has been deleted")
+		if err := b.Modify([]byte("foo"), nil); err != bolt.ErrTxNotWritable {
+			t.Fatalf("unexpected error: %v", err)
 		}
 		return nil
 	}); err != nil {
@@ -475,107 +725,1341 @@ func TestBucket_Delete_NonExisting(t *testing.T) {
 	}
 }
 
-// Ensure that accessing and updating nested buckets is ok across transactions.
-func TestBucket_Nested(t *testing.T) {
+// Ensure that IncrementUint64 updates big-endian counters in place.
+func TestBucket_IncrementUint64(t *testing.T) {
 	db := MustOpenDB()
 	defer db.MustClose()
 
 	if err := db.Update(func(tx *bolt.Tx) error {
-		// Create a widgets bucket.
 		b, err := tx.CreateBucket([]byte("widgets"))
 		if err != nil {
 			t.Fatal(err)
 		}
+		if err := b.Put([]byte("short"), []byte("bar")); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := b.CreateBucket([]byte("child")); err != nil {
+			t.Fatal(err)
+		}
 
-		// Create a widgets/foo bucket.
-		_, err = b.CreateBucket([]byte("foo"))
+		for _, tt := range []struct {
+			delta int64
+			n     uint64
+		}{{5, 5}, {1, 6}, {-6, 0}, {math.MaxInt64, math.MaxInt64}} {
+			if n, err := b.IncrementUint64([]byte("counter"), tt.delta); err != nil {
+				t.Fatal(err)
+			} else if n != tt.n {
+				t.Fatalf("IncrementUint64(%d): unexpected value: %d", tt.delta, n)
+			}
+		}
+		if v := b.Get([]byte("counter")); !bytes.Equal(v, []byte{0x7f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}) {
+			t.Fatalf("unexpected value: %x", v)
+		}
+
+		if _, err := b.IncrementUint64([]byte("counter"), math.MinInt64); err != bolt.ErrCounterOverflow {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, err := b.IncrementUint64([]byte("counter"), math.MaxInt64); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := b.IncrementUint64([]byte("counter"), 2); err != bolt.ErrCounterOverflow {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, err := b.IncrementUint64([]byte("missing"), -1); err != bolt.ErrCounterOverflow {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, err := b.IncrementUint64([]byte("short"), 1); err != bolt.ErrInvalidCounter {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, err := b.IncrementUint64([]byte("child"), 1); err != bolt.ErrIncompatibleValue {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that change handlers observe inserts, updates and deletes.
+func TestBucket_OnChange(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	type change struct{ key, oldValue, newValue string }
+	describe := func(v []byte) string {
+		if v == nil {
+			return "<nil>"
+		}
+		return string(v)
+	}
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
 		if err != nil {
 			t.Fatal(err)
 		}
+		if err := b.Put([]byte("existing"), []byte("old")); err != nil {
+			t.Fatal(err)
+		}
 
-		// Create a widgets/bar key.
-		if err := b.Put([]byte("bar"), []byte("0000")); err != nil {
+		var changes []change
+		b.OnChange(func(key, oldValue, newValue []byte) {
+			changes = append(changes, change{string(key), describe(oldValue), describe(newValue)})
+		})
+
+		if err := b.Put([]byte("foo"), []byte("bar")); err != nil {
+			t.Fatal(err)
+		}
+		if err := b.Put([]byte("foo"), nil); err != nil {
+			t.Fatal(err)
+		}
+		if err := b.Put([]byte("existing"), []byte("new")); err != nil {
+			t.Fatal(err)
+		}
+		if err := b.Delete([]byte("foo")); err != nil {
+			t.Fatal(err)
+		}
+		if err := b.Delete([]byte("missing")); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := b.CreateBucket([]byte("child")); err != nil {
+			t.Fatal(err)
+		}
+		c := b.Cursor()
+		c.Seek([]byte("existing"))
+		if err := c.Delete(); err != nil {
 			t.Fatal(err)
 		}
 
+		exp := []change{
+			{"foo", "<nil>", "bar"},
+			{"foo", "bar", ""},
+			{"existing", "old", "new"},
+			{"foo", "", "<nil>"},
+			{"existing", "new", "<nil>"},
+		}
+		if len(changes) != len(exp) {
+			t.Fatalf("unexpected changes: %v", changes)
+		}
+		for i := range exp {
+			if changes[i] != exp[i] {
+				t.Fatalf("unexpected change %d: %v", i, changes[i])
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Handlers do not carry over into later transactions.
+	if err := db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("widgets")).Put([]byte("foo"), []byte("baz"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that a bucket can delete an existing key.
+func TestBucket_Delete(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := b.Put([]byte("foo"), []byte("bar")); err != nil {
+			t.Fatal(err)
+		}
+		if err := b.Delete([]byte("foo")); err != nil {
+			t.Fatal(err)
+		}
+		if v := b.Get([]byte("foo")); v != nil {
+			t.Fatalf("unexpected value: %v", v)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that deleting a large set of keys will work correctly.
+func TestBucket_Delete_Large(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 0; i < 100; i++ {
+			if err := b.Put([]byte(strconv.Itoa(i)), []byte(strings.Repeat("*", 1024))); err != nil {
+				t.Fatal(err)
+			}
+		}
+
 		return nil
 	}); err != nil {
 		t.Fatal(err)
 	}
-	db.MustCheck()
 
-	// Update widgets/bar.
 	if err := db.Update(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte("widgets"))
-		if err := b.Put([]byte("bar"), []byte("xxxx")); err != nil {
+		for i := 0; i < 100; i++ {
+			if err := b.Delete([]byte(strconv.Itoa(i))); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		for i := 0; i < 100; i++ {
+			if v := b.Get([]byte(strconv.Itoa(i))); v != nil {
+				t.Fatalf("unexpected value: %v, i=%d", v, i)
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Deleting a very large list of keys will cause the freelist to use overflow.
+func TestBucket_Delete_FreelistOverflow(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode.")
+	}
+
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	k := make([]byte, 16)
+	for i := uint64(0); i < 10000; i++ {
+		if err := db.Update(func(tx *bolt.Tx) error {
+			b, err := tx.CreateBucketIfNotExists([]byte("0"))
+			if err != nil {
+				t.Fatalf("bucket error: %s", err)
+			}
+
+			for j := uint64(0); j < 1000; j++ {
+				binary.BigEndian.PutUint64(k[:8], i)
+				binary.BigEndian.PutUint64(k[8:], j)
+				if err := b.Put(k, nil); err != nil {
+					t.Fatalf("put error: %s", err)
+				}
+			}
+
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Delete all of them in one large transaction
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("0"))
+		c := b.Cursor()
+		for k, _ := c.First(); k != nil; k, _ = c.Next() {
+			if err := c.Delete(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Check more than an overflow's worth of pages are freed.
+	stats := db.Stats()
+	freePages := stats.FreePageN + stats.PendingPageN
+	if freePages <= 0xFFFF {
+		t.Fatalf("expected more than 0xFFFF free pages, got %v", freePages)
+	}
+
+	// Free page count should be preserved on reopen.
+	if err := db.DB.Close(); err != nil {
+		t.Fatal(err)
+	}
+	db.MustReopen()
+	if reopenFreePages := db.Stats().FreePageN; freePages != reopenFreePages {
+		t.Fatalf("expected %d free pages, got %+v", freePages, db.Stats())
+	}
+}
+
+// Ensure that deleting of non-existing key is a no-op.
+func TestBucket_Delete_NonExisting(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err = b.CreateBucket([]byte("nested")); err != nil {
+			t.Fatal(err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		if err := b.Delete([]byte("foo")); err != nil {
+			t.Fatal(err)
+		}
+		if b.Bucket([]byte("nested")) == nil {
+			t.Fatal("nested bucket has been deleted")
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that a bucket can write a slice of WritePairs.
+func TestBucket_MultiPutPairs(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		pairs := []bolt.WritePair{
+			bolt.WritablePair([]byte("foo"), []byte("1")),
+			bolt.WritablePair([]byte("bar"), []byte("2")),
+		}
+		if err := b.MultiPutPairs(pairs...); err != nil {
 			t.Fatal(err)
 		}
-		return nil
-	}); err != nil {
-		t.Fatal(err)
-	}
-	db.MustCheck()
+		if err := b.MultiPutPairs(bolt.WritablePair(nil, []byte("3"))); err != bolt.ErrKeyRequired {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if err := tx.MultiPutPairs(pairs...); err != bolt.ErrIncompatibleValue {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		if v := b.Get([]byte("foo")); !bytes.Equal(v, []byte("1")) {
+			t.Fatalf("unexpected value: %v", v)
+		}
+		if v := b.Get([]byte("bar")); !bytes.Equal(v, []byte("2")) {
+			t.Fatalf("unexpected value: %v", v)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that a bucket can delete a mix of existing and non-existing keys.
+func TestBucket_MultiDelete(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, k := range []string{"bar", "baz", "foo"} {
+			if err := b.Put([]byte(k), []byte(k)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		if err := b.MultiDelete([]byte("bar"), []byte("missing"), []byte("foo")); err != nil {
+			t.Fatal(err)
+		}
+		if v := b.Get([]byte("bar")); v != nil {
+			t.Fatalf("unexpected value: %v", v)
+		}
+		if v := b.Get([]byte("foo")); v != nil {
+			t.Fatalf("unexpected value: %v", v)
+		}
+		if v := b.Get([]byte("baz")); !bytes.Equal(v, []byte("baz")) {
+			t.Fatalf("unexpected value: %v", v)
+		}
+		if err := tx.MultiDelete([]byte("widgets")); err != bolt.ErrIncompatibleValue {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that a bucket can delete every key under a prefix.
+func TestBucket_DeletePrefix(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Enough keys under the prefix to span several pages.
+		for i := 0; i < 1000; i++ {
+			if err := b.Put([]byte(fmt.Sprintf("user/%04d", i)), make([]byte, 100)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, k := range []string{"a", "user", "users", "z"} {
+			if err := b.Put([]byte(k), []byte(k)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := b.CreateBucket([]byte("user/bucket")); err != nil {
+			t.Fatal(err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+
+		var notified int
+		b.OnChange(func(key, oldValue, newValue []byte) {
+			if newValue != nil {
+				t.Fatalf("unexpected write to %q", key)
+			}
+			notified++
+		})
+
+		if n, err := b.DeletePrefix([]byte("user/")); err != nil {
+			t.Fatal(err)
+		} else if n != 1000 || notified != 1000 {
+			t.Fatalf("unexpected deleted count: %d (%d notified)", n, notified)
+		}
+
+		var keys []string
+		if err := b.ForEach(func(k, v []byte) error {
+			keys = append(keys, string(k))
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(keys, []string{"a", "user", "user/bucket", "users", "z"}) {
+			t.Fatalf("unexpected keys: %+v", keys)
+		}
+
+		if n, err := b.DeletePrefix([]byte("missing")); err != nil || n != 0 {
+			t.Fatalf("unexpected result: %d, %v", n, err)
+		}
+		if _, err := tx.DeletePrefix([]byte("widgets")); err != bolt.ErrIncompatibleValue {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.View(func(tx *bolt.Tx) error {
+		if _, err := tx.Bucket([]byte("widgets")).DeletePrefix(nil); err != bolt.ErrTxNotWritable {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that MultiGet returns the first value within each range, in the
+// order the ranges were given, even when they are not sorted.
+func TestBucket_MultiGet(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 10000; i += 3 {
+			if err := b.Put([]byte(fmt.Sprintf("%05d", i)), []byte(fmt.Sprintf("value-%d", i))); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := b.CreateBucket([]byte("10001")); err != nil {
+			t.Fatal(err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+
+		var pairs [][]byte
+		var exp [][]byte
+		for _, i := range rand.Perm(10003) {
+			start, limit := []byte(fmt.Sprintf("%05d", i)), []byte(fmt.Sprintf("%05d", i+2))
+			pairs = append(pairs, start, limit)
+
+			k, v := b.Cursor().Seek(start)
+			for k != nil && v == nil {
+				k, v = b.Cursor().Seek(append(k, 0))
+			}
+			if k != nil && bytes.Compare(k, limit) < 0 {
+				exp = append(exp, v)
+			} else {
+				exp = append(exp, nil)
+			}
+		}
+
+		values, err := b.MultiGet(pairs...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := range exp {
+			if !bytes.Equal(values[i], exp[i]) || (values[i] == nil) != (exp[i] == nil) {
+				t.Fatalf("unexpected value for range %s: %q != %q", pairs[2*i], values[i], exp[i])
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func BenchmarkBucket_MultiGet(b *testing.B) {
+	benchmarkBucketMultiGet(b, func(bkt *bolt.Bucket, keys [][]byte) {
+		pairs := make([][]byte, 0, 2*len(keys))
+		for _, k := range keys {
+			pairs = append(pairs, k, append(k[:len(k):len(k)], 0))
+		}
+		if _, err := bkt.MultiGet(pairs...); err != nil {
+			b.Fatal(err)
+		}
+	})
+}
+
+func BenchmarkBucket_MultiGet_Naive(b *testing.B) {
+	benchmarkBucketMultiGet(b, func(bkt *bolt.Bucket, keys [][]byte) {
+		values := make([][]byte, len(keys))
+		for i, k := range keys {
+			if v := bkt.Get(k); v != nil {
+				values[i] = append([]byte(nil), v...)
+			}
+		}
+	})
+}
+
+// benchmarkBucketMultiGet measures fn looking up 10k random keys in a bucket
+// of 1M keys.
+func benchmarkBucketMultiGet(b *testing.B, fn func(bkt *bolt.Bucket, keys [][]byte)) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		bkt, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			b.Fatal(err)
+		}
+		bkt.FillPercent = 1.0
+		for i := 0; i < 1000000; i++ {
+			if err := bkt.Put([]byte(fmt.Sprintf("%08d", i)), make([]byte, 32)); err != nil {
+				b.Fatal(err)
+			}
+		}
+		return nil
+	}); err != nil {
+		b.Fatal(err)
+	}
+
+	keys := make([][]byte, 10000)
+	for i := range keys {
+		keys[i] = []byte(fmt.Sprintf("%08d", rand.Intn(1000000)))
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := db.View(func(tx *bolt.Tx) error {
+			fn(tx.Bucket([]byte("widgets")), keys)
+			return nil
+		}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// Ensure that MultiGetWithPresence distinguishes empty values from missing
+// keys, whether or not the keys are sorted.
+func TestBucket_MultiGetWithPresence(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 1000; i += 2 {
+			if err := b.Put([]byte(fmt.Sprintf("%04d", i)), []byte(fmt.Sprintf("value-%d", i))); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := b.Put([]byte("empty"), []byte{}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := b.CreateBucket([]byte("child")); err != nil {
+			t.Fatal(err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+
+		for _, order := range [][]int{{0, 1, 998, 999, 1000}, {999, 0, 1000, 998, 1}} {
+			keys := make([][]byte, 0, len(order)+2)
+			for _, i := range order {
+				keys = append(keys, []byte(fmt.Sprintf("%04d", i)))
+			}
+			keys = append(keys, []byte("child"), []byte("empty"))
+
+			values, present, err := b.MultiGetWithPresence(keys...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for j, i := range order {
+				if exp := i%2 == 0 && i < 1000; present[j] != exp {
+					t.Fatalf("unexpected presence of %d: %v", i, present[j])
+				} else if exp && string(values[j]) != fmt.Sprintf("value-%d", i) {
+					t.Fatalf("unexpected value of %d: %s", i, values[j])
+				} else if !exp && values[j] != nil {
+					t.Fatalf("unexpected value of %d: %s", i, values[j])
+				}
+			}
+			if present[len(order)] {
+				t.Fatal("expected nested bucket to be reported as not present")
+			}
+			if !present[len(order)+1] || len(values[len(order)+1]) != 0 {
+				t.Fatalf("unexpected empty value: %v %v", present[len(order)+1], values[len(order)+1])
+			}
+		}
+
+		if _, _, err := b.MultiGetWithPresence([]byte("foo"), nil); err != bolt.ErrKeyRequired {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that accessing and updating nested buckets is ok across transactions.
+func TestBucket_Nested(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		// Create a widgets bucket.
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Create a widgets/foo bucket.
+		_, err = b.CreateBucket([]byte("foo"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Create a widgets/bar key.
+		if err := b.Put([]byte("bar"), []byte("0000")); err != nil {
+			t.Fatal(err)
+		}
+
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	db.MustCheck()
+
+	// Update widgets/bar.
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		if err := b.Put([]byte("bar"), []byte("xxxx")); err != nil {
+			t.Fatal(err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	db.MustCheck()
+
+	// Cause a split.
+	if err := db.Update(func(tx *bolt.Tx) error {
+		var b = tx.Bucket([]byte("widgets"))
+		for i := 0; i < 10000; i++ {
+			if err := b.Put([]byte(strconv.Itoa(i)), []byte(strconv.Itoa(i))); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	db.MustCheck()
+
+	// Insert into widgets/foo/baz.
+	if err := db.Update(func(tx *bolt.Tx) error {
+		var b = tx.Bucket([]byte("widgets"))
+		if err := b.Bucket([]byte("foo")).Put([]byte("baz"), []byte("yyyy")); err != nil {
+			t.Fatal(err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	db.MustCheck()
+
+	// Verify.
+	if err := db.View(func(tx *bolt.Tx) error {
+		var b = tx.Bucket([]byte("widgets"))
+		if v := b.Bucket([]byte("foo")).Get([]byte("baz")); !bytes.Equal(v, []byte("yyyy")) {
+			t.Fatalf("unexpected value: %v", v)
+		}
+		if v := b.Get([]byte("bar")); !bytes.Equal(v, []byte("xxxx")) {
+			t.Fatalf("unexpected value: %v", v)
+		}
+		for i := 0; i < 10000; i++ {
+			if v := b.Get([]byte(strconv.Itoa(i))); !bytes.Equal(v, []byte(strconv.Itoa(i))) {
+				t.Fatalf("unexpected value: %v", v)
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that deleting a bucket using Delete() returns an error.
+func TestBucket_Delete_Bucket(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := b.CreateBucket([]byte("foo")); err != nil {
+			t.Fatal(err)
+		}
+		if err := b.Delete([]byte("foo")); err != bolt.ErrIncompatibleValue {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that deleting a key on a read-only bucket returns an error.
+func TestBucket_Delete_ReadOnly(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucket([]byte("widgets")); err != nil {
+			t.Fatal(err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.View(func(tx *bolt.Tx) error {
+		if err := tx.Bucket([]byte("widgets")).Delete([]byte("foo")); err != bolt.ErrTxNotWritable {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that a deleting value while the transaction is closed returns an error.
+func TestBucket_Delete_Closed(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	tx, err := db.Begin(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := tx.CreateBucket([]byte("widgets"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Delete([]byte("foo")); err != bolt.ErrTxClosed {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
+
+// Ensure that deleting a bucket causes nested buckets to be deleted.
+func TestBucket_DeleteBucket_Nested(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		widgets, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		foo, err := widgets.CreateBucket([]byte("foo"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		bar, err := foo.CreateBucket([]byte("bar"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := bar.Put([]byte("baz"), []byte("bat")); err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.Bucket([]byte("widgets")).DeleteBucket([]byte("foo")); err != nil {
+			t.Fatal(err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that deleting a bucket causes nested buckets to be deleted after they have been committed.
+func TestBucket_DeleteBucket_Nested2(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		widgets, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		foo, err := widgets.CreateBucket([]byte("foo"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		bar, err := foo.CreateBucket([]byte("bar"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := bar.Put([]byte("baz"), []byte("bat")); err != nil {
+			t.Fatal(err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		widgets := tx.Bucket([]byte("widgets"))
+		if widgets == nil {
+			t.Fatal("expected widgets bucket")
+		}
+
+		foo := widgets.Bucket([]byte("foo"))
+		if foo == nil {
+			t.Fatal("expected foo bucket")
+		}
+
+		bar := foo.Bucket([]byte("bar"))
+		if bar == nil {
+			t.Fatal("expected bar bucket")
+		}
+
+		if v := bar.Get([]byte("baz")); !bytes.Equal(v, []byte("bat")) {
+			t.Fatalf("unexpected value: %v", v)
+		}
+		if err := tx.DeleteBucket([]byte("widgets")); err != nil {
+			t.Fatal(err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.View(func(tx *bolt.Tx) error {
+		if tx.Bucket([]byte("widgets")) != nil {
+			t.Fatal("expected bucket to be deleted")
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that deleting a child bucket with multiple pages causes all pages to get collected.
+// NOTE: Consistency check in bolt_test.DB.Close() will panic if pages not freed properly.
+func TestBucket_DeleteBucket_Large(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		widgets, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		foo, err := widgets.CreateBucket([]byte("foo"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 0; i < 1000; i++ {
+			if err := foo.Put([]byte(fmt.Sprintf("%d", i)), []byte(fmt.Sprintf("%0100d", i))); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		if err := tx.DeleteBucket([]byte("widgets")); err != nil {
+			t.Fatal(err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that clearing a bucket releases its pages and those of its nested
+// buckets while keeping the bucket and its sequence.
+func TestBucket_Clear(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	for _, putAfterClear := range []bool{false, true} {
+		if err := db.Update(func(tx *bolt.Tx) error {
+			widgets, err := tx.CreateBucketIfNotExists([]byte("widgets"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := widgets.SetSequence(7); err != nil {
+				t.Fatal(err)
+			}
+			foo, err := widgets.CreateBucket([]byte("foo"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < 1000; i++ {
+				if err := widgets.Put([]byte(fmt.Sprintf("%d", i)), []byte(fmt.Sprintf("%0100d", i))); err != nil {
+					t.Fatal(err)
+				}
+				if err := foo.Put([]byte(fmt.Sprintf("%d", i)), []byte(fmt.Sprintf("%0100d", i))); err != nil {
+					t.Fatal(err)
+				}
+			}
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := db.Update(func(tx *bolt.Tx) error {
+			widgets := tx.Bucket([]byte("widgets"))
+			if err := widgets.Clear(); err != nil {
+				t.Fatal(err)
+			}
+			if k, _ := widgets.Cursor().First(); k != nil {
+				t.Fatalf("unexpected key: %s", k)
+			}
+			if putAfterClear {
+				if err := widgets.Put([]byte("bar"), []byte("baz")); err != nil {
+					t.Fatal(err)
+				}
+			}
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := db.View(func(tx *bolt.Tx) error {
+			widgets := tx.Bucket([]byte("widgets"))
+			if widgets == nil {
+				t.Fatal("expected bucket")
+			} else if seq := widgets.Sequence(); seq != 7 {
+				t.Fatalf("unexpected sequence: %d", seq)
+			} else if widgets.Bucket([]byte("foo")) != nil {
+				t.Fatal("expected nested bucket to be deleted")
+			}
+
+			var n int
+			if err := widgets.ForEach(func(k, v []byte) error {
+				n++
+				return nil
+			}); err != nil {
+				t.Fatal(err)
+			}
+			if exp := map[bool]int{false: 0, true: 1}[putAfterClear]; n != exp {
+				t.Fatalf("unexpected key count: %d", n)
+			}
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		db.MustCheck()
+
+		if err := db.Update(func(tx *bolt.Tx) error {
+			return tx.DeleteBucket([]byte("widgets"))
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		for _, name := range []string{"widgets", "gadgets"} {
+			if _, err := tx.CreateBucket([]byte(name)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return tx.Clear()
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.View(func(tx *bolt.Tx) error {
+		if k, _ := tx.Cursor().First(); k != nil {
+			t.Fatalf("unexpected bucket: %s", k)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that a simple value retrieved via Bucket() returns a nil.
+func TestBucket_Bucket_IncompatibleValue(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		widgets, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := widgets.Put([]byte("foo"), []byte("bar")); err != nil {
+			t.Fatal(err)
+		}
+		if b := tx.Bucket([]byte("widgets")).Bucket([]byte("foo")); b != nil {
+			t.Fatal("expected nil bucket")
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that creating a bucket on an existing non-bucket key returns an error.
+func TestBucket_CreateBucket_IncompatibleValue(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+	if err := db.Update(func(tx *bolt.Tx) error {
+		widgets, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := widgets.Put([]byte("foo"), []byte("bar")); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := widgets.CreateBucket([]byte("foo")); err != bolt.ErrIncompatibleValue {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that deleting a bucket on an existing non-bucket key returns an error.
+func TestBucket_DeleteBucket_IncompatibleValue(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		widgets, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := widgets.Put([]byte("foo"), []byte("bar")); err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.Bucket([]byte("widgets")).DeleteBucket([]byte("foo")); err != bolt.ErrIncompatibleValue {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that nested buckets can be renamed along with their subtree.
+func TestBucket_RenameBucket(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		widgets, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := widgets.Put([]byte("foo"), []byte("bar")); err != nil {
+			t.Fatal(err)
+		}
+		// One large bucket, with pages of its own, and one inline bucket.
+		large, err := widgets.CreateBucket([]byte("large"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 1000; i++ {
+			if err := large.Put(u64tob(uint64(i)), make([]byte, 100)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := large.SetSequence(42); err != nil {
+			t.Fatal(err)
+		}
+		small, err := widgets.CreateBucket([]byte("small"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := small.CreateBucket([]byte("nested")); err != nil {
+			t.Fatal(err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		widgets := tx.Bucket([]byte("widgets"))
+
+		// Changes made before the rename follow the bucket to its new name.
+		if err := widgets.Bucket([]byte("large")).Put([]byte("new"), []byte("value")); err != nil {
+			t.Fatal(err)
+		}
+		if err := widgets.RenameBucket([]byte("large"), []byte("renamed")); err != nil {
+			t.Fatal(err)
+		}
+		if err := widgets.RenameBucket([]byte("small"), []byte("tiny")); err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.RenameBucket([]byte("widgets"), []byte("gadgets")); err != nil {
+			t.Fatal(err)
+		}
+
+		gadgets := tx.Bucket([]byte("gadgets"))
+		for _, tt := range []struct {
+			oldName, newName string
+			err              error
+		}{
+			{"missing", "other", bolt.ErrBucketNotFound},
+			{"foo", "other", bolt.ErrIncompatibleValue},
+			{"renamed", "tiny", bolt.ErrBucketExists},
+			{"renamed", "foo", bolt.ErrIncompatibleValue},
+			{"renamed", "", bolt.ErrBucketNameRequired},
+		} {
+			if err := gadgets.RenameBucket([]byte(tt.oldName), []byte(tt.newName)); err != tt.err {
+				t.Fatalf("%s -> %s: unexpected error: %v", tt.oldName, tt.newName, err)
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.DB.Close(); err != nil {
+		t.Fatal(err)
+	}
+	db.MustReopen()
+	if err := db.View(func(tx *bolt.Tx) error {
+		if tx.Bucket([]byte("widgets")) != nil {
+			t.Fatal("expected old bucket to be gone")
+		}
+		gadgets := tx.Bucket([]byte("gadgets"))
+		if v := gadgets.Get([]byte("foo")); !bytes.Equal(v, []byte("bar")) {
+			t.Fatalf("unexpected value: %v", v)
+		}
+		if gadgets.Bucket([]byte("large")) != nil || gadgets.Bucket([]byte("small")) != nil {
+			t.Fatal("expected old nested buckets to be gone")
+		}
+
+		renamed := gadgets.Bucket([]byte("renamed"))
+		if seq := renamed.Sequence(); seq != 42 {
+			t.Fatalf("unexpected sequence: %d", seq)
+		}
+		if v := renamed.Get([]byte("new")); !bytes.Equal(v, []byte("value")) {
+			t.Fatalf("unexpected value: %v", v)
+		}
+		if v := renamed.Get(u64tob(999)); len(v) != 100 {
+			t.Fatalf("unexpected value: %v", v)
+		}
+		if gadgets.Bucket([]byte("tiny")).Bucket([]byte("nested")) == nil {
+			t.Fatal("expected nested bucket to be moved")
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure bucket can set and update its sequence number.
+func TestBucket_Sequence(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		bkt, err := tx.CreateBucket([]byte("0"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Retrieve sequence.
+		if v := bkt.Sequence(); v != 0 {
+			t.Fatalf("unexpected sequence: %d", v)
+		}
+
+		// Update sequence.
+		if err := bkt.SetSequence(1000); err != nil {
+			t.Fatal(err)
+		}
+
+		// Read sequence again.
+		if v := bkt.Sequence(); v != 1000 {
+			t.Fatalf("unexpected sequence: %d", v)
+		}
+
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Verify sequence in separate transaction.
+	if err := db.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket([]byte("0")).Sequence(); v != 1000 {
+			t.Fatalf("unexpected sequence: %d", v)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that a bucket can return an autoincrementing sequence.
+func TestBucket_NextSequence(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		widgets, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		woojits, err := tx.CreateBucket([]byte("woojits"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Make sure sequence increments.
+		if seq, err := widgets.NextSequence(); err != nil {
+			t.Fatal(err)
+		} else if seq != 1 {
+			t.Fatalf("unexpecte sequence: %d", seq)
+		}
+
+		if seq, err := widgets.NextSequence(); err != nil {
+			t.Fatal(err)
+		} else if seq != 2 {
+			t.Fatalf("unexpected sequence: %d", seq)
+		}
 
-	// Cause a split.
-	if err := db.Update(func(tx *bolt.Tx) error {
-		var b = tx.Bucket([]byte("widgets"))
-		for i := 0; i < 10000; i++ {
-			if err := b.Put([]byte(strconv.Itoa(i)), []byte(strconv.Itoa(i))); err != nil {
-				t.Fatal(err)
-			}
+		// Buckets should be separate.
+		if seq, err := woojits.NextSequence(); err != nil {
+			t.Fatal(err)
+		} else if seq != 1 {
+			t.Fatalf("unexpected sequence: %d", 1)
 		}
+
 		return nil
 	}); err != nil {
 		t.Fatal(err)
 	}
-	db.MustCheck()
+}
+
+// Ensure that a bucket will persist an autoincrementing sequence even if its
+// the only thing updated on the bucket.
+// https://github.com/boltdb/bolt/issues/296
+func TestBucket_NextSequence_Persist(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
 
-	// Insert into widgets/foo/baz.
 	if err := db.Update(func(tx *bolt.Tx) error {
-		var b = tx.Bucket([]byte("widgets"))
-		if err := b.Bucket([]byte("foo")).Put([]byte("baz"), []byte("yyyy")); err != nil {
+		if _, err := tx.CreateBucket([]byte("widgets")); err != nil {
 			t.Fatal(err)
 		}
 		return nil
 	}); err != nil {
 		t.Fatal(err)
 	}
-	db.MustCheck()
 
-	// Verify.
-	if err := db.View(func(tx *bolt.Tx) error {
-		var b = tx.Bucket([]byte("widgets"))
-		if v := b.Bucket([]byte("foo")).Get([]byte("baz")); !bytes.Equal(v, []byte("yyyy")) {
-			t.Fatalf("unexpected value: %v", v)
-		}
-		if v := b.Get([]byte("bar")); !bytes.Equal(v, []byte("xxxx")) {
-			t.Fatalf("unexpected value: %v", v)
-		}
-		for i := 0; i < 10000; i++ {
-			if v := b.Get([]byte(strconv.Itoa(i))); !bytes.Equal(v, []byte(strconv.Itoa(i))) {
-				t.Fatalf("unexpected value: %v", v)
-			}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.Bucket([]byte("widgets")).NextSequence(); err != nil {
+			t.Fatal(err)
 		}
 		return nil
 	}); err != nil {
 		t.Fatal(err)
 	}
-}
 
-// Ensure that deleting a bucket using Delete() returns an error.
-func TestBucket_Delete_Bucket(t *testing.T) {
-	db := MustOpenDB()
-	defer db.MustClose()
 	if err := db.Update(func(tx *bolt.Tx) error {
-		b, err := tx.CreateBucket([]byte("widgets"))
+		seq, err := tx.Bucket([]byte("widgets")).NextSequence()
 		if err != nil {
-			t.Fatal(err)
-		}
-		if _, err := b.CreateBucket([]byte("foo")); err != nil {
-			t.Fatal(err)
-		}
-		if err := b.Delete([]byte("foo")); err != bolt.ErrIncompatibleValue {
 			t.Fatalf("unexpected error: %s", err)
+		} else if seq != 2 {
+			t.Fatalf("unexpected sequence: %d", seq)
 		}
 		return nil
 	}); err != nil {
@@ -583,8 +2067,8 @@ func TestBucket_Delete_Bucket(t *testing.T) {
 	}
 }
 
-// Ensure that deleting a key on a read-only bucket returns an error.
-func TestBucket_Delete_ReadOnly(t *testing.T) {
+// Ensure that retrieving the next sequence on a read-only bucket returns an error.
+func TestBucket_NextSequence_ReadOnly(t *testing.T) {
 	db := MustOpenDB()
 	defer db.MustClose()
 
@@ -598,7 +2082,8 @@ func TestBucket_Delete_ReadOnly(t *testing.T) {
 	}
 
 	if err := db.View(func(tx *bolt.Tx) error {
-		if err := tx.Bucket([]byte("widgets")).Delete([]byte("foo")); err != bolt.ErrTxNotWritable {
+		_, err := tx.Bucket([]byte("widgets")).NextSequence()
+		if err != bolt.ErrTxNotWritable {
 			t.Fatalf("unexpected error: %s", err)
 		}
 		return nil
@@ -607,54 +2092,68 @@ func TestBucket_Delete_ReadOnly(t *testing.T) {
 	}
 }
 
-// Ensure that a deleting value while the transaction is closed returns an error.
-func TestBucket_Delete_Closed(t *testing.T) {
+// Ensure that retrieving the next sequence for a bucket on a closed database return an error.
+func TestBucket_NextSequence_Closed(t *testing.T) {
 	db := MustOpenDB()
 	defer db.MustClose()
-
 	tx, err := db.Begin(true)
 	if err != nil {
 		t.Fatal(err)
 	}
-
 	b, err := tx.CreateBucket([]byte("widgets"))
 	if err != nil {
 		t.Fatal(err)
 	}
-
 	if err := tx.Rollback(); err != nil {
 		t.Fatal(err)
 	}
-	if err := b.Delete([]byte("foo")); err != bolt.ErrTxClosed {
-		t.Fatalf("unexpected error: %s", err)
+	if _, err := b.NextSequence(); err != bolt.ErrTxClosed {
+		t.Fatal(err)
 	}
 }
 
-// Ensure that deleting a bucket causes nested buckets to be deleted.
-func TestBucket_DeleteBucket_Nested(t *testing.T) {
+// Ensure that a range of sequence values can be reserved at once and is
+// persisted.
+func TestBucket_ReserveSequence(t *testing.T) {
 	db := MustOpenDB()
 	defer db.MustClose()
 
 	if err := db.Update(func(tx *bolt.Tx) error {
-		widgets, err := tx.CreateBucket([]byte("widgets"))
+		b, err := tx.CreateBucket([]byte("widgets"))
 		if err != nil {
 			t.Fatal(err)
 		}
-
-		foo, err := widgets.CreateBucket([]byte("foo"))
-		if err != nil {
+		if _, err := b.NextSequence(); err != nil {
 			t.Fatal(err)
 		}
 
-		bar, err := foo.CreateBucket([]byte("bar"))
-		if err != nil {
+		if start, err := b.ReserveSequence(100); err != nil {
 			t.Fatal(err)
+		} else if start != 2 {
+			t.Fatalf("unexpected start: %d", start)
 		}
-		if err := bar.Put([]byte("baz"), []byte("bat")); err != nil {
+		if start, err := b.ReserveSequence(0); err != nil {
 			t.Fatal(err)
+		} else if start != 102 {
+			t.Fatalf("unexpected start: %d", start)
 		}
-		if err := tx.Bucket([]byte("widgets")).DeleteBucket([]byte("foo")); err != nil {
+		if _, err := b.ReserveSequence(^uint64(0)); err != bolt.ErrSequenceOverflow {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, err := tx.ReserveSequence(1); err != bolt.ErrIncompatibleValue {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		seq, err := tx.Bucket([]byte("widgets")).NextSequence()
+		if err != nil {
 			t.Fatal(err)
+		} else if seq != 102 {
+			t.Fatalf("unexpected sequence: %d", seq)
 		}
 		return nil
 	}); err != nil {
@@ -662,102 +2161,95 @@ func TestBucket_DeleteBucket_Nested(t *testing.T) {
 	}
 }
 
-// Ensure that deleting a bucket causes nested buckets to be deleted after they have been committed.
-func TestBucket_DeleteBucket_Nested2(t *testing.T) {
+// Ensure a user can loop over all key/value pairs in a bucket.
+func TestBucket_ForEach(t *testing.T) {
 	db := MustOpenDB()
 	defer db.MustClose()
 
 	if err := db.Update(func(tx *bolt.Tx) error {
-		widgets, err := tx.CreateBucket([]byte("widgets"))
+		b, err := tx.CreateBucket([]byte("widgets"))
 		if err != nil {
 			t.Fatal(err)
 		}
-
-		foo, err := widgets.CreateBucket([]byte("foo"))
-		if err != nil {
+		if err := b.Put([]byte("foo"), []byte("0000")); err != nil {
 			t.Fatal(err)
 		}
-
-		bar, err := foo.CreateBucket([]byte("bar"))
-		if err != nil {
+		if err := b.Put([]byte("baz"), []byte("0001")); err != nil {
 			t.Fatal(err)
 		}
-
-		if err := bar.Put([]byte("baz"), []byte("bat")); err != nil {
+		if err := b.Put([]byte("bar"), []byte("0002")); err != nil {
 			t.Fatal(err)
 		}
-		return nil
-	}); err != nil {
-		t.Fatal(err)
-	}
-
-	if err := db.Update(func(tx *bolt.Tx) error {
-		widgets := tx.Bucket([]byte("widgets"))
-		if widgets == nil {
-			t.Fatal("expected widgets bucket")
-		}
-
-		foo := widgets.Bucket([]byte("foo"))
-		if foo == nil {
-			t.Fatal("expected foo bucket")
-		}
-
-		bar := foo.Bucket([]byte("bar"))
-		if bar == nil {
-			t.Fatal("expected bar bucket")
-		}
 
-		if v := bar.Get([]byte("baz")); !bytes.Equal(v, []byte("bat")) {
-			t.Fatalf("unexpected value: %v", v)
-		}
-		if err := tx.DeleteBucket([]byte("widgets")); err != nil {
+		var index int
+		if err := b.ForEach(func(k, v []byte) error {
+			switch index {
+			case 0:
+				if !bytes.Equal(k, []byte("bar")) {
+					t.Fatalf("unexpected key: %v", k)
+				} else if !bytes.Equal(v, []byte("0002")) {
+					t.Fatalf("unexpected value: %v", v)
+				}
+			case 1:
+				if !bytes.Equal(k, []byte("baz")) {
+					t.Fatalf("unexpected key: %v", k)
+				} else if !bytes.Equal(v, []byte("0001")) {
+					t.Fatalf("unexpected value: %v", v)
+				}
+			case 2:
+				if !bytes.Equal(k, []byte("foo")) {
+					t.Fatalf("unexpected key: %v", k)
+				} else if !bytes.Equal(v, []byte("0000")) {
+					t.Fatalf("unexpected value: %v", v)
+				}
+			}
+			index++
+			return nil
+		}); err != nil {
 			t.Fatal(err)
 		}
-		return nil
-	}); err != nil {
-		t.Fatal(err)
-	}
 
-	if err := db.View(func(tx *bolt.Tx) error {
-		if tx.Bucket([]byte("widgets")) != nil {
-			t.Fatal("expected bucket to be deleted")
+		if index != 3 {
+			t.Fatalf("unexpected index: %d", index)
 		}
+
 		return nil
 	}); err != nil {
 		t.Fatal(err)
 	}
 }
 
-// Ensure that deleting a child bucket with multiple pages causes all pages to get collected.
-// NOTE: Consistency check in bolt_test.DB.Close() will panic if pages not freed properly.
-func TestBucket_DeleteBucket_Large(t *testing.T) {
+// Ensure that cancelling the context stops iteration promptly.
+func TestBucket_ForEachContext_Cancel(t *testing.T) {
 	db := MustOpenDB()
 	defer db.MustClose()
 
 	if err := db.Update(func(tx *bolt.Tx) error {
-		widgets, err := tx.CreateBucket([]byte("widgets"))
-		if err != nil {
-			t.Fatal(err)
-		}
-
-		foo, err := widgets.CreateBucket([]byte("foo"))
+		b, err := tx.CreateBucket([]byte("widgets"))
 		if err != nil {
 			t.Fatal(err)
 		}
-
-		for i := 0; i < 1000; i++ {
-			if err := foo.Put([]byte(fmt.Sprintf("%d", i)), []byte(fmt.Sprintf("%0100d", i))); err != nil {
+		for i := 0; i < 5000; i++ {
+			if err := b.Put([]byte(fmt.Sprintf("%04d", i)), []byte("0000")); err != nil {
 				t.Fatal(err)
 			}
 		}
-		return nil
-	}); err != nil {
-		t.Fatal(err)
-	}
 
-	if err := db.Update(func(tx *bolt.Tx) error {
-		if err := tx.DeleteBucket([]byte("widgets")); err != nil {
-			t.Fatal(err)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		var n int
+		if err := b.ForEachContext(ctx, func(k, v []byte) error {
+			n++
+			if n == 100 {
+				cancel()
+			}
+			return nil
+		}); err != context.Canceled {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if n != 1024 {
+			t.Fatalf("unexpected number of keys visited: %d", n)
 		}
 		return nil
 	}); err != nil {
@@ -765,22 +2257,50 @@ func TestBucket_DeleteBucket_Large(t *testing.T) {
 	}
 }
 
-// Ensure that a simple value retrieved via Bucket() returns a nil.
-func TestBucket_Bucket_IncompatibleValue(t *testing.T) {
+// Ensure a user can loop over all key/value pairs in a bucket in reverse order.
+func TestBucket_ForEachReverse(t *testing.T) {
 	db := MustOpenDB()
 	defer db.MustClose()
 
 	if err := db.Update(func(tx *bolt.Tx) error {
-		widgets, err := tx.CreateBucket([]byte("widgets"))
+		b, err := tx.CreateBucket([]byte("widgets"))
 		if err != nil {
 			t.Fatal(err)
 		}
+		if err := b.Put([]byte("foo"), []byte("0000")); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := b.CreateBucket([]byte("baz")); err != nil {
+			t.Fatal(err)
+		}
+		if err := b.Put([]byte("bar"), []byte("0002")); err != nil {
+			t.Fatal(err)
+		}
 
-		if err := widgets.Put([]byte("foo"), []byte("bar")); err != nil {
+		var keys []string
+		if err := b.ForEachReverse(func(k, v []byte) error {
+			if string(k) == "baz" && v != nil {
+				t.Fatalf("unexpected value for nested bucket: %v", v)
+			}
+			keys = append(keys, string(k))
+			return nil
+		}); err != nil {
 			t.Fatal(err)
 		}
-		if b := tx.Bucket([]byte("widgets")).Bucket([]byte("foo")); b != nil {
-			t.Fatal("expected nil bucket")
+		if !reflect.DeepEqual(keys, []string{"foo", "baz", "bar"}) {
+			t.Fatalf("unexpected keys: %+v", keys)
+		}
+
+		keys = nil
+		errStop := errors.New("stop")
+		if err := b.ForEachReverse(func(k, v []byte) error {
+			keys = append(keys, string(k))
+			return errStop
+		}); err != errStop {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if !reflect.DeepEqual(keys, []string{"foo"}) {
+			t.Fatalf("unexpected keys: %+v", keys)
 		}
 		return nil
 	}); err != nil {
@@ -788,21 +2308,44 @@ func TestBucket_Bucket_IncompatibleValue(t *testing.T) {
 	}
 }
 
-// Ensure that creating a bucket on an existing non-bucket key returns an error.
-func TestBucket_CreateBucket_IncompatibleValue(t *testing.T) {
+// Ensure a user can loop over the key/value pairs within a key range.
+func TestBucket_ForEachRange(t *testing.T) {
 	db := MustOpenDB()
 	defer db.MustClose()
+
 	if err := db.Update(func(tx *bolt.Tx) error {
-		widgets, err := tx.CreateBucket([]byte("widgets"))
+		b, err := tx.CreateBucket([]byte("widgets"))
 		if err != nil {
 			t.Fatal(err)
 		}
-
-		if err := widgets.Put([]byte("foo"), []byte("bar")); err != nil {
-			t.Fatal(err)
+		for _, k := range []string{"a", "b", "c", "d", "e"} {
+			if err := b.Put([]byte(k), []byte(k)); err != nil {
+				t.Fatal(err)
+			}
 		}
-		if _, err := widgets.CreateBucket([]byte("foo")); err != bolt.ErrIncompatibleValue {
-			t.Fatalf("unexpected error: %s", err)
+
+		for _, tt := range []struct {
+			start, end []byte
+			want       []string
+		}{
+			{[]byte("b"), []byte("d"), []string{"b", "c"}},
+			{[]byte("bb"), []byte("dd"), []string{"c", "d"}},
+			{nil, []byte("c"), []string{"a", "b"}},
+			{[]byte("d"), nil, []string{"d", "e"}},
+			{nil, nil, []string{"a", "b", "c", "d", "e"}},
+			{[]byte("f"), nil, nil},
+			{[]byte("c"), []byte("c"), nil},
+		} {
+			var keys []string
+			if err := b.ForEachRange(tt.start, tt.end, func(k, v []byte) error {
+				keys = append(keys, string(k))
+				return nil
+			}); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(keys, tt.want) {
+				t.Fatalf("unexpected keys for [%q, %q): %+v", tt.start, tt.end, keys)
+			}
 		}
 		return nil
 	}); err != nil {
@@ -810,21 +2353,43 @@ func TestBucket_CreateBucket_IncompatibleValue(t *testing.T) {
 	}
 }
 
-// Ensure that deleting a bucket on an existing non-bucket key returns an error.
-func TestBucket_DeleteBucket_IncompatibleValue(t *testing.T) {
+// Ensure a user can loop over the key/value pairs sharing a key prefix.
+func TestBucket_ForEachPrefix(t *testing.T) {
 	db := MustOpenDB()
 	defer db.MustClose()
 
 	if err := db.Update(func(tx *bolt.Tx) error {
-		widgets, err := tx.CreateBucket([]byte("widgets"))
+		b, err := tx.CreateBucket([]byte("widgets"))
 		if err != nil {
 			t.Fatal(err)
 		}
-		if err := widgets.Put([]byte("foo"), []byte("bar")); err != nil {
-			t.Fatal(err)
+		for _, k := range []string{"a", "user/1", "user/2", "users", "z"} {
+			if err := b.Put([]byte(k), []byte(k)); err != nil {
+				t.Fatal(err)
+			}
 		}
-		if err := tx.Bucket([]byte("widgets")).DeleteBucket([]byte("foo")); err != bolt.ErrIncompatibleValue {
-			t.Fatalf("unexpected error: %s", err)
+
+		for _, tt := range []struct {
+			prefix []byte
+			want   []string
+		}{
+			{[]byte("user/"), []string{"user/1", "user/2"}},
+			{[]byte("user"), []string{"user/1", "user/2", "users"}},
+			{nil, []string{"a", "user/1", "user/2", "users", "z"}},
+			{[]byte("b"), nil},
+			{[]byte("zz"), nil},
+			{[]byte("\xff"), nil},
+		} {
+			var keys []string
+			if err := b.ForEachPrefix(tt.prefix, func(k, v []byte) error {
+				keys = append(keys, string(k))
+				return nil
+			}); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(keys, tt.want) {
+				t.Fatalf("unexpected keys for prefix %q: %+v", tt.prefix, keys)
+			}
 		}
 		return nil
 	}); err != nil {
@@ -832,41 +2397,58 @@ func TestBucket_DeleteBucket_IncompatibleValue(t *testing.T) {
 	}
 }
 
-// Ensure bucket can set and update its sequence number.
-func TestBucket_Sequence(t *testing.T) {
+// Ensure that ForEachScalar and ForEachBucket split a bucket's keys between them.
+func TestBucket_ForEachScalar(t *testing.T) {
 	db := MustOpenDB()
 	defer db.MustClose()
 
 	if err := db.Update(func(tx *bolt.Tx) error {
-		bkt, err := tx.CreateBucket([]byte("0"))
+		b, err := tx.CreateBucket([]byte("widgets"))
 		if err != nil {
 			t.Fatal(err)
 		}
-
-		// Retrieve sequence.
-		if v := bkt.Sequence(); v != 0 {
-			t.Fatalf("unexpected sequence: %d", v)
+		for _, k := range []string{"a", "c"} {
+			if err := b.Put([]byte(k), []byte(k)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, k := range []string{"b", "d"} {
+			if _, err := b.CreateBucket([]byte(k)); err != nil {
+				t.Fatal(err)
+			}
 		}
 
-		// Update sequence.
-		if err := bkt.SetSequence(1000); err != nil {
+		var scalars, buckets []string
+		if err := b.ForEachScalar(func(k, v []byte) error {
+			if !bytes.Equal(k, v) {
+				t.Fatalf("unexpected value for %q: %v", k, v)
+			}
+			scalars = append(scalars, string(k))
+			return nil
+		}); err != nil {
 			t.Fatal(err)
 		}
-
-		// Read sequence again.
-		if v := bkt.Sequence(); v != 1000 {
-			t.Fatalf("unexpected sequence: %d", v)
+		if err := b.ForEachBucket(func(name []byte, sb *bolt.Bucket) error {
+			if sb == nil {
+				t.Fatalf("unexpected nil bucket for %q", name)
+			}
+			buckets = append(buckets, string(name))
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(scalars, []string{"a", "c"}) {
+			t.Fatalf("unexpected scalars: %+v", scalars)
+		}
+		if !reflect.DeepEqual(buckets, []string{"b", "d"}) {
+			t.Fatalf("unexpected buckets: %+v", buckets)
 		}
 
-		return nil
-	}); err != nil {
-		t.Fatal(err)
-	}
-
-	// Verify sequence in separate transaction.
-	if err := db.View(func(tx *bolt.Tx) error {
-		if v := tx.Bucket([]byte("0")).Sequence(); v != 1000 {
-			t.Fatalf("unexpected sequence: %d", v)
+		if err := tx.ForEachScalar(func(k, v []byte) error {
+			t.Fatalf("unexpected root scalar: %q", k)
+			return nil
+		}); err != nil {
+			t.Fatal(err)
 		}
 		return nil
 	}); err != nil {
@@ -874,103 +2456,167 @@ func TestBucket_Sequence(t *testing.T) {
 	}
 }
 
-// Ensure that a bucket can return an autoincrementing sequence.
-func TestBucket_NextSequence(t *testing.T) {
+// Ensure that sampled keys are ordered, present and roughly evenly spaced.
+func TestBucket_SampleKeys(t *testing.T) {
 	db := MustOpenDB()
 	defer db.MustClose()
 
+	const count = 10000
 	if err := db.Update(func(tx *bolt.Tx) error {
-		widgets, err := tx.CreateBucket([]byte("widgets"))
+		b, err := tx.CreateBucket([]byte("widgets"))
 		if err != nil {
 			t.Fatal(err)
 		}
-		woojits, err := tx.CreateBucket([]byte("woojits"))
+		for i := 0; i < count; i++ {
+			if err := b.Put(u64tob(uint64(i)), make([]byte, 100)); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		small, err := tx.CreateBucket([]byte("small"))
 		if err != nil {
 			t.Fatal(err)
 		}
-
-		// Make sure sequence increments.
-		if seq, err := widgets.NextSequence(); err != nil {
+		for _, k := range []string{"a", "b", "c"} {
+			if err := small.Put([]byte(k), []byte(k)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := tx.CreateBucket([]byte("empty")); err != nil {
 			t.Fatal(err)
-		} else if seq != 1 {
-			t.Fatalf("unexpecte sequence: %d", seq)
 		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
 
-		if seq, err := widgets.NextSequence(); err != nil {
+	if err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+
+		const n = 8
+		samples, err := b.SampleKeys(n)
+		if err != nil {
 			t.Fatal(err)
-		} else if seq != 2 {
-			t.Fatalf("unexpected sequence: %d", seq)
+		} else if len(samples) != n {
+			t.Fatalf("unexpected sample count: %d", len(samples))
+		} else if !bytes.Equal(samples[0], u64tob(0)) {
+			t.Fatalf("unexpected first sample: %x", samples[0])
+		}
+		prev := uint64(0)
+		for i, k := range append(samples[1:], u64tob(count)) {
+			if i < n-1 && b.Get(k) == nil {
+				t.Fatalf("sample %x not in bucket", k)
+			}
+			num := binary.BigEndian.Uint64(k)
+			if gap := num - prev; gap < count/n/2 || gap > count/n*2 {
+				t.Fatalf("uneven gap before sample %d: %d", i+1, gap)
+			}
+			prev = num
 		}
 
-		// Buckets should be separate.
-		if seq, err := woojits.NextSequence(); err != nil {
+		// Too many samples are limited by the leaf pages, not the keys.
+		if samples, err := b.SampleKeys(count); err != nil {
 			t.Fatal(err)
-		} else if seq != 1 {
-			t.Fatalf("unexpected sequence: %d", 1)
+		} else if len(samples) < 2 || len(samples) >= count {
+			t.Fatalf("unexpected sample count: %d", len(samples))
 		}
 
+		if samples, err := tx.Bucket([]byte("small")).SampleKeys(10); err != nil {
+			t.Fatal(err)
+		} else if len(samples) != 3 {
+			t.Fatalf("unexpected sample count: %d", len(samples))
+		}
+		if samples, err := tx.Bucket([]byte("empty")).SampleKeys(10); err != nil {
+			t.Fatal(err)
+		} else if len(samples) != 0 {
+			t.Fatalf("unexpected sample count: %d", len(samples))
+		}
+		if _, err := b.SampleKeys(0); err != bolt.ErrInvalidLimit {
+			t.Fatalf("unexpected error: %v", err)
+		}
 		return nil
 	}); err != nil {
 		t.Fatal(err)
 	}
 }
 
-// Ensure that a bucket will persist an autoincrementing sequence even if its
-// the only thing updated on the bucket.
-// https://github.com/boltdb/bolt/issues/296
-func TestBucket_NextSequence_Persist(t *testing.T) {
+// Ensure that a parallel scan visits every key exactly once.
+func TestBucket_ForEachParallel(t *testing.T) {
 	db := MustOpenDB()
 	defer db.MustClose()
 
+	const count = 10000
 	if err := db.Update(func(tx *bolt.Tx) error {
-		if _, err := tx.CreateBucket([]byte("widgets")); err != nil {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
 			t.Fatal(err)
 		}
+		for i := 0; i < count; i++ {
+			if err := b.Put(u64tob(uint64(i)), make([]byte, 100)); err != nil {
+				t.Fatal(err)
+			}
+		}
 		return nil
 	}); err != nil {
 		t.Fatal(err)
 	}
 
-	if err := db.Update(func(tx *bolt.Tx) error {
-		if _, err := tx.Bucket([]byte("widgets")).NextSequence(); err != nil {
+	scan := func(b *bolt.Bucket, shards int) map[uint64]int {
+		var mu sync.Mutex
+		seen := make(map[uint64]int)
+		if err := b.ForEachParallel(shards, func(k, v []byte) error {
+			mu.Lock()
+			seen[binary.BigEndian.Uint64(k)]++
+			mu.Unlock()
+			return nil
+		}); err != nil {
 			t.Fatal(err)
 		}
-		return nil
-	}); err != nil {
-		t.Fatal(err)
+		return seen
 	}
-
-	if err := db.Update(func(tx *bolt.Tx) error {
-		seq, err := tx.Bucket([]byte("widgets")).NextSequence()
-		if err != nil {
-			t.Fatalf("unexpected error: %s", err)
-		} else if seq != 2 {
-			t.Fatalf("unexpected sequence: %d", seq)
+	check := func(seen map[uint64]int) {
+		if len(seen) != count {
+			t.Fatalf("unexpected key count: %d", len(seen))
+		}
+		for k, n := range seen {
+			if n != 1 {
+				t.Fatalf("key %d visited %d times", k, n)
+			}
 		}
-		return nil
-	}); err != nil {
-		t.Fatal(err)
 	}
-}
 
-// Ensure that retrieving the next sequence on a read-only bucket returns an error.
-func TestBucket_NextSequence_ReadOnly(t *testing.T) {
-	db := MustOpenDB()
-	defer db.MustClose()
+	if err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		for _, shards := range []int{0, 1, 4, 1000} {
+			check(scan(b, shards))
+		}
 
-	if err := db.Update(func(tx *bolt.Tx) error {
-		if _, err := tx.CreateBucket([]byte("widgets")); err != nil {
-			t.Fatal(err)
+		// The first error stops the scan and is returned.
+		errStop := errors.New("stop")
+		if err := b.ForEachParallel(4, func(k, v []byte) error {
+			if binary.BigEndian.Uint64(k) == count/2 {
+				return errStop
+			}
+			return nil
+		}); err != errStop {
+			t.Fatalf("unexpected error: %v", err)
 		}
 		return nil
 	}); err != nil {
 		t.Fatal(err)
 	}
 
-	if err := db.View(func(tx *bolt.Tx) error {
-		_, err := tx.Bucket([]byte("widgets")).NextSequence()
-		if err != bolt.ErrTxNotWritable {
-			t.Fatalf("unexpected error: %s", err)
+	// Writable transactions are scanned sequentially, including their changes.
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		if err := b.Delete(u64tob(0)); err != nil {
+			t.Fatal(err)
+		}
+		if err := b.Put(u64tob(count), nil); err != nil {
+			t.Fatal(err)
+		}
+		if seen := scan(b, 4); len(seen) != count || seen[0] != 0 || seen[count] != 1 {
+			t.Fatalf("unexpected keys: %d", len(seen))
 		}
 		return nil
 	}); err != nil {
@@ -978,28 +2624,8 @@ func TestBucket_NextSequence_ReadOnly(t *testing.T) {
 	}
 }
 
-// Ensure that retrieving the next sequence for a bucket on a closed database return an error.
-func TestBucket_NextSequence_Closed(t *testing.T) {
-	db := MustOpenDB()
-	defer db.MustClose()
-	tx, err := db.Begin(true)
-	if err != nil {
-		t.Fatal(err)
-	}
-	b, err := tx.CreateBucket([]byte("widgets"))
-	if err != nil {
-		t.Fatal(err)
-	}
-	if err := tx.Rollback(); err != nil {
-		t.Fatal(err)
-	}
-	if _, err := b.NextSequence(); err != bolt.ErrTxClosed {
-		t.Fatal(err)
-	}
-}
-
-// Ensure a user can loop over all key/value pairs in a bucket.
-func TestBucket_ForEach(t *testing.T) {
+// Ensure a user can page through the keys of a bucket.
+func TestBucket_Page(t *testing.T) {
 	db := MustOpenDB()
 	defer db.MustClose()
 
@@ -1008,52 +2634,59 @@ func TestBucket_ForEach(t *testing.T) {
 		if err != nil {
 			t.Fatal(err)
 		}
-		if err := b.Put([]byte("foo"), []byte("0000")); err != nil {
-			t.Fatal(err)
-		}
-		if err := b.Put([]byte("baz"), []byte("0001")); err != nil {
-			t.Fatal(err)
+		for _, k := range []string{"a", "b", "d", "e"} {
+			if err := b.Put([]byte(k), []byte(k)); err != nil {
+				t.Fatal(err)
+			}
 		}
-		if err := b.Put([]byte("bar"), []byte("0002")); err != nil {
+		if _, err := b.CreateBucket([]byte("c")); err != nil {
 			t.Fatal(err)
 		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
 
-		var index int
-		if err := b.ForEach(func(k, v []byte) error {
-			switch index {
-			case 0:
-				if !bytes.Equal(k, []byte("bar")) {
-					t.Fatalf("unexpected key: %v", k)
-				} else if !bytes.Equal(v, []byte("0002")) {
-					t.Fatalf("unexpected value: %v", v)
-				}
-			case 1:
-				if !bytes.Equal(k, []byte("baz")) {
-					t.Fatalf("unexpected key: %v", k)
-				} else if !bytes.Equal(v, []byte("0001")) {
-					t.Fatalf("unexpected value: %v", v)
-				}
-			case 2:
-				if !bytes.Equal(k, []byte("foo")) {
-					t.Fatalf("unexpected key: %v", k)
-				} else if !bytes.Equal(v, []byte("0000")) {
-					t.Fatalf("unexpected value: %v", v)
+	var pages [][]string
+	if err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		var after []byte
+		for {
+			pairs, next, err := b.Page(after, 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var keys []string
+			for _, pair := range pairs {
+				if string(pair.Key()) == "c" && pair.Value() != nil {
+					t.Fatalf("unexpected value for nested bucket: %v", pair.Value())
 				}
+				keys = append(keys, string(pair.Key()))
 			}
-			index++
-			return nil
-		}); err != nil {
-			t.Fatal(err)
+			pages = append(pages, keys)
+			if next == nil {
+				break
+			}
+			after = next
 		}
 
-		if index != 3 {
-			t.Fatalf("unexpected index: %d", index)
+		if pairs, next, err := b.Page([]byte("bb"), 10); err != nil {
+			t.Fatal(err)
+		} else if len(pairs) != 3 || string(pairs[0].Key()) != "c" || next != nil {
+			t.Fatalf("unexpected page after missing key: %d pairs, next %q", len(pairs), next)
 		}
 
+		if _, _, err := b.Page(nil, 0); err != bolt.ErrInvalidLimit {
+			t.Fatalf("unexpected error: %v", err)
+		}
 		return nil
 	}); err != nil {
 		t.Fatal(err)
 	}
+
+	if !reflect.DeepEqual(pages, [][]string{{"a", "b"}, {"c", "d"}, {"e"}}) {
+		t.Fatalf("unexpected pages: %+v", pages)
+	}
 }
 
 // Ensure a database can stop iteration early.
@@ -1174,7 +2807,8 @@ func TestBucket_Put_ValueTooLarge(t *testing.T) {
 		if err != nil {
 			t.Fatal(err)
 		}
-		if err := b.Put([]byte("foo"), make([]byte, bolt.MaxValueSize+1)); err != bolt.ErrValueTooLarge {
+		err = b.Put([]byte("foo"), make([]byte, bolt.MaxValueSize+1))
+		if e, ok := err.(*bolt.ValueTooLargeError); !ok || e.Unwrap() != bolt.ErrValueTooLarge {
 			t.Fatalf("unexpected error: %s", err)
 		}
 		return nil
@@ -1183,6 +2817,99 @@ func TestBucket_Put_ValueTooLarge(t *testing.T) {
 	}
 }
 
+// Ensure that Options.MaxValueSize limits the size of written values.
+func TestBucket_Put_MaxValueSize(t *testing.T) {
+	db := MustOpenWithOption(&bolt.Options{MaxValueSize: 100})
+	defer db.MustClose()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := b.Put([]byte("foo"), make([]byte, 100)); err != nil {
+			t.Fatal(err)
+		}
+
+		err = b.Put([]byte("bar"), make([]byte, 101))
+		if e, ok := err.(*bolt.ValueTooLargeError); !ok {
+			t.Fatalf("unexpected error: %v", err)
+		} else if string(e.Key) != "bar" || e.Size != 101 || e.MaxSize != 100 {
+			t.Fatalf("unexpected error fields: %+v", e)
+		} else if s := e.Error(); s != `value too large: key "bar" is 101 bytes, limit is 100` {
+			t.Fatalf("unexpected message: %s", s)
+		}
+
+		// Other writes are limited too.
+		if err := b.MultiPut([]byte("baz"), make([]byte, 101)); err == nil {
+			t.Fatal("expected error")
+		}
+		if _, _, err := b.GetOrPut([]byte("baz"), make([]byte, 101)); err == nil {
+			t.Fatal("expected error")
+		}
+		if b.Get([]byte("bar")) != nil || b.Get([]byte("baz")) != nil {
+			t.Fatal("unexpected value written")
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure a bucket can break down the size of its pages.
+func TestBucket_SizeBreakdown(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		small, err := tx.CreateBucket([]byte("small"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := small.Put([]byte("foo"), []byte("bar")); err != nil {
+			t.Fatal(err)
+		}
+
+		large, err := tx.CreateBucket([]byte("large"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 1000; i++ {
+			if err := large.Put([]byte(fmt.Sprintf("%04d", i)), []byte("value")); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.View(func(tx *bolt.Tx) error {
+		small := tx.Bucket([]byte("small"))
+		s := small.SizeBreakdown()
+		if s.LeafBytes != 0 || s.BranchBytes != 0 || s.InlineBucketBytes == 0 {
+			t.Fatalf("unexpected inline bucket breakdown: %+v", s)
+		} else if s.KeyValueBytes != 6 {
+			t.Fatalf("unexpected KeyValueBytes: %d", s.KeyValueBytes)
+		} else if small.StandaloneSize() != s.InlineBucketBytes {
+			t.Fatalf("unexpected StandaloneSize: %d", small.StandaloneSize())
+		}
+
+		large := tx.Bucket([]byte("large"))
+		s = large.SizeBreakdown()
+		if s.LeafBytes == 0 || s.BranchBytes == 0 || s.InlineBucketBytes != 0 {
+			t.Fatalf("unexpected large bucket breakdown: %+v", s)
+		} else if s.KeyValueBytes < 1000*5 || s.KeyValueBytes > 1000*9 {
+			t.Fatalf("unexpected KeyValueBytes: %d", s.KeyValueBytes)
+		} else if large.StandaloneSize() != s.LeafBytes+s.BranchBytes {
+			t.Fatalf("unexpected StandaloneSize: %d", large.StandaloneSize())
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
 // Ensure a bucket can calculate stats.
 func TestBucket_Stats(t *testing.T) {
 	db := MustOpenDB()
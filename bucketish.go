@@ -1,5 +1,7 @@
 package bbolt
 
+import "context"
+
 type Bucketish interface {
 	// common to Tx and Bucket
 	Bucket(name []byte) *Bucket
@@ -7,7 +9,25 @@ type Bucketish interface {
 	CreateBucketIfNotExists(key []byte) (*Bucket, error)
 	Cursor() *Cursor
 	ForEachBucket(fn func(name []byte, b *Bucket) error) error
+	ForEachScalar(fn func(k, v []byte) error) error
 	DeleteBucket(key []byte) error
+	RenameBucket(oldName, newName []byte) error
+	Clear() error
 	Writable() bool
+	Has(key []byte) bool
+	First() (k, v []byte)
+	Last() (k, v []byte)
+	GetOrPut(key, defaultValue []byte) ([]byte, bool, error)
+	PutIfAbsent(key, value []byte) (bool, error)
+	Modify(key []byte, fn func(old []byte) ([]byte, error)) error
+	ReserveSequence(n uint64) (uint64, error)
 	ForEach(fn func(k, v []byte) error) error
+	ForEachContext(ctx context.Context, fn func(k, v []byte) error) error
+	ForEachReverse(fn func(k, v []byte) error) error
+	ForEachRange(start, end []byte, fn func(k, v []byte) error) error
+	ForEachPrefix(prefix []byte, fn func(k, v []byte) error) error
+	MultiPutPairs(pairs ...WritePair) error
+	MultiDelete(keys ...[]byte) error
+	DeletePrefix(prefix []byte) (int, error)
+	BucketStats() BucketStats
 }
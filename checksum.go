@@ -0,0 +1,53 @@
+package bbolt
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+)
+
+// checksumMarker is the final byte of the trailer written by PutChecksummed,
+// identifying the trailer format.
+const checksumMarker = 0xc1
+
+// checksumTrailerSize is the size of the trailer written by PutChecksummed: a
+// little-endian CRC-32C of the value followed by checksumMarker.
+const checksumTrailerSize = 5
+
+var castagnoliTable = crc32.MakeTable(crc32.Castagnoli)
+
+// PutChecksummed sets the value for a key in the bucket as Put does, with a
+// CRC-32C checksum of the value appended in a small trailer. The value should
+// be read back with GetChecksummed, which verifies it. Values written with Put
+// are unaffected, so checksums can be adopted per key.
+func (b *Bucket) PutChecksummed(key []byte, value []byte) error {
+	if err := b.checkValueSize(key, len(value)+checksumTrailerSize); err != nil {
+		return err
+	}
+
+	v := make([]byte, len(value)+checksumTrailerSize)
+	copy(v, value)
+	binary.LittleEndian.PutUint32(v[len(value):], crc32.Checksum(value, castagnoliTable))
+	v[len(v)-1] = checksumMarker
+
+	return b.Put(key, v)
+}
+
+// GetChecksummed retrieves a value written by PutChecksummed, verifying its
+// checksum and stripping the trailer. Returns ErrKeyNotFound if the key does
+// not exist or is a nested bucket, and ErrChecksumMismatch if the value has no
+// valid trailer or does not match its checksum.
+// The returned value is only valid for the life of the transaction.
+func (b *Bucket) GetChecksummed(key []byte) ([]byte, error) {
+	v := b.Get(key)
+	if v == nil {
+		return nil, ErrKeyNotFound
+	} else if len(v) < checksumTrailerSize || v[len(v)-1] != checksumMarker {
+		return nil, ErrChecksumMismatch
+	}
+
+	value := v[:len(v)-checksumTrailerSize]
+	if binary.LittleEndian.Uint32(v[len(value):]) != crc32.Checksum(value, castagnoliTable) {
+		return nil, ErrChecksumMismatch
+	}
+	return value, nil
+}
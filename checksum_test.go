@@ -0,0 +1,56 @@
+package bbolt_test
+
+import (
+	"bytes"
+	"testing"
+
+	bolt "github.com/covalenthq/bbolt"
+)
+
+// Ensure that checksummed values round-trip and that corruption is detected.
+func TestBucket_PutChecksummed(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, v := range []string{"bar", ""} {
+			if err := b.PutChecksummed([]byte("foo"), []byte(v)); err != nil {
+				t.Fatal(err)
+			}
+			if got, err := b.GetChecksummed([]byte("foo")); err != nil {
+				t.Fatal(err)
+			} else if !bytes.Equal(got, []byte(v)) {
+				t.Fatalf("unexpected value: %q", got)
+			}
+		}
+
+		if err := b.PutChecksummed([]byte("foo"), []byte("bar")); err != nil {
+			t.Fatal(err)
+		}
+		corrupt := append([]byte(nil), b.Get([]byte("foo"))...)
+		corrupt[0] ^= 0xff
+		if err := b.Put([]byte("foo"), corrupt); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := b.GetChecksummed([]byte("foo")); err != bolt.ErrChecksumMismatch {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if err := b.Put([]byte("raw"), []byte("bar")); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := b.GetChecksummed([]byte("raw")); err != bolt.ErrChecksumMismatch {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, err := b.GetChecksummed([]byte("missing")); err != bolt.ErrKeyNotFound {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -1,15 +1,29 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/url"
 	"os"
+	"os/signal"
 	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"text/tabwriter"
+	"time"
+	"unicode"
+	"unicode/utf8"
 
 	bolt "github.com/covalenthq/bbolt"
 )
@@ -51,15 +65,76 @@ var (
 	// ErrKeyRequired is returned when a key is not specified.
 	ErrKeyRequired = errors.New("key required")
 
+	// ErrEmptyKeySegment is returned when a key path has an empty segment,
+	// which cannot name a key as Bolt does not allow empty keys.
+	ErrEmptyKeySegment = errors.New("empty key in path")
+
+	// ErrScalarAtRoot is returned when writing a scalar value directly under
+	// the root of a database, which only holds buckets.
+	ErrScalarAtRoot = errors.New("the root of a database only holds buckets")
+
 	// ErrKeyIsBucket is returned when a key expected to resolve to a scalar value
 	// instead resolves to a bucket.
 	ErrKeyIsBucket = errors.New("key is bucket")
 
+	// ErrKeyIsNotBucket is returned when a key expected to resolve to a bucket
+	// instead resolves to a scalar value.
+	ErrKeyIsNotBucket = errors.New("key is not a bucket")
+
 	// ErrKeyNotFound is returned when a key is not found.
 	ErrKeyNotFound = errors.New("key not found")
 
 	// ErrKeyNotFound is returned when a key is found.
 	ErrKeyFound = errors.New("key found")
+
+	// ErrNotExist is returned by exists when a URI does not resolve. It causes
+	// the process to exit with an error without printing anything.
+	ErrNotExist = errors.New("does not exist")
+
+	// ErrDestIsDescendant is returned when a bucket targeted for copying or
+	// moving would be written underneath itself.
+	ErrDestIsDescendant = errors.New("cannot copy or move a bucket into its own descendant")
+
+	// ErrDestExists is returned when the name a bucket is renamed to is
+	// already taken in its parent.
+	ErrDestExists = errors.New("destination already exists")
+
+	// ErrConfirmationRequired is returned when a large bucket targeted for
+	// deletion cannot be confirmed interactively and --force was not given.
+	ErrConfirmationRequired = errors.New("refusing to delete large bucket without confirmation (use --force)")
+
+	// ErrPageNotFound is returned when a page id lies beyond the end of the
+	// database.
+	ErrPageNotFound = errors.New("page not found")
+
+	// ErrCheckFailed is returned when a database fails its consistency check.
+	ErrCheckFailed = errors.New("consistency check failed")
+
+	// ErrAborted is returned when the user declines a confirmation prompt.
+	ErrAborted = errors.New("aborted")
+
+	// ErrUnknownEncoding is returned when an unsupported output encoding is
+	// requested.
+	ErrUnknownEncoding = errors.New("unknown encoding")
+
+	// ErrMalformedRow is returned when a row passed to load does not consist
+	// of exactly a key and a value.
+	ErrMalformedRow = errors.New("malformed row")
+
+	// ErrMalformedDocument is returned when a document passed to import does
+	// not consist solely of nested objects and string values.
+	ErrMalformedDocument = errors.New("malformed import document")
+
+	// ErrDatabaseLocked is returned when a database stays locked by another
+	// process for longer than the open timeout.
+	ErrDatabaseLocked = errors.New("database is locked by another process")
+
+	// ErrShellActive is returned when shell is run from within shell mode.
+	ErrShellActive = errors.New("already in shell mode")
+
+	// ErrUnterminatedQuote is returned when a shell mode command line ends
+	// inside a quoted string.
+	ErrUnterminatedQuote = errors.New("unterminated quote")
 )
 
 type commandEnvironment struct {
@@ -71,12 +146,28 @@ type commandEnvironment struct {
 
 	mounts    map[string]string
 	txHandles map[string]*bolt.Tx
+
+	// dbs holds databases kept open by shell mode, keyed by path.
+	dbs map[string]*bolt.DB
+	cwd string
+
+	encoding    string
+	dryRun      bool
+	openTimeout time.Duration
+
+	// print0 ends records printed by ls, range, head, tail, keys, grep and
+	// find with a NUL byte instead of a newline, and quote shell-quotes their
+	// fields.
+	print0 bool
+	quote  bool
 }
 
 func main() {
 	if err := execSubcommand(os.Args[1:]); err == ErrUsage {
 		fmt.Fprintln(os.Stderr, Usage())
 		os.Exit(2)
+	} else if err == ErrNotExist {
+		os.Exit(1)
 	} else if err != nil {
 		fmt.Println(err.Error())
 		os.Exit(1)
@@ -85,8 +176,49 @@ func main() {
 
 func execSubcommand(args []string) error {
 	mounts := make(map[string]string)
+	encoding := "hex"
+	dryRun := false
+	print0 := false
+	quote := false
+	openTimeout := defaultOpenTimeout
+
+	for len(args) >= 1 && (args[0] == "--dry-run" || args[0] == "--print0" || args[0] == "--quote" || len(args) >= 2 && (args[0] == "-d" || args[0] == "--database" || args[0] == "--encoding" || args[0] == "--timeout")) {
+		switch args[0] {
+		case "--dry-run":
+			dryRun = true
+			args = args[1:]
+			continue
+		case "--print0":
+			print0 = true
+			args = args[1:]
+			continue
+		case "--quote":
+			quote = true
+			args = args[1:]
+			continue
+		}
+
+		if args[0] == "--timeout" {
+			d, err := time.ParseDuration(args[1])
+			if err != nil || d < 0 {
+				return fmt.Errorf("invalid timeout: %q", args[1])
+			}
+			openTimeout = d
+			args = args[2:]
+			continue
+		}
+
+		if args[0] == "--encoding" {
+			switch args[1] {
+			case "hex", "utf8", "base64", "auto":
+				encoding = args[1]
+			default:
+				return ErrUnknownEncoding
+			}
+			args = args[2:]
+			continue
+		}
 
-	for len(args) >= 2 && (args[0] == "-d" || args[0] == "--database") {
 		alias_and_path := strings.SplitN(args[1], ":", 2)
 
 		var alias, path_part string
@@ -119,36 +251,98 @@ func execSubcommand(args []string) error {
 	}
 
 	cmdEnv := &commandEnvironment{
-		mounts:    mounts,
-		txHandles: make(map[string]*bolt.Tx),
-		args:      args,
-		inIO:      os.Stdin,
-		outIO:     os.Stdout,
-		errIO:     os.Stderr,
+		mounts:      mounts,
+		txHandles:   make(map[string]*bolt.Tx),
+		args:        args,
+		inIO:        os.Stdin,
+		outIO:       os.Stdout,
+		errIO:       os.Stderr,
+		encoding:    encoding,
+		dryRun:      dryRun,
+		openTimeout: openTimeout,
+		print0:      print0,
+		quote:       quote,
 	}
 
-	// Execute command.
+	return runCommand(cmdEnv, subcommand)
+}
+
+func runCommand(cmdEnv *commandEnvironment, subcommand string) error {
 	switch subcommand {
 	case "help":
 		return ErrUsage
+	case "shell":
+		return runShell(cmdEnv)
 	case "touch":
 		return touchDatabaseFile(cmdEnv)
+	case "compact":
+		return compactDatabaseFile(cmdEnv)
+	case "backup":
+		return backupDatabaseFile(cmdEnv)
+	case "check":
+		return checkDatabaseFile(cmdEnv)
+	case "page":
+		return inspectPage(cmdEnv)
+	case "txid":
+		return printTxID(cmdEnv)
 	case "get":
 		return getKey(cmdEnv)
+	case "exists":
+		return checkExists(cmdEnv)
 	case "put":
 		return putKeyValue(cmdEnv)
+	case "append":
+		return appendValue(cmdEnv)
 	case "mkdir":
 		return makeBucket(cmdEnv)
 	case "rm":
 		return removeKey(cmdEnv)
+	case "clear":
+		return clearBucket(cmdEnv)
 	case "cp":
 		return copyKeyWithFile(cmdEnv)
+	case "mv":
+		return moveKey(cmdEnv)
+	case "rename":
+		return renameBucket(cmdEnv)
 	case "ls":
 		return listKeys(cmdEnv)
+	case "keys":
+		return listKeyNames(cmdEnv)
+	case "grep":
+		return grepSubtree(cmdEnv)
+	case "find":
+		return findKey(cmdEnv)
+	case "range":
+		return listKeyRange(cmdEnv)
+	case "head":
+		return listKeyEnd(cmdEnv, false)
+	case "tail":
+		return listKeyEnd(cmdEnv, true)
 	case "tree":
 		return printBucketTree(cmdEnv)
+	case "diff":
+		return diffBuckets(cmdEnv)
+	case "watch":
+		return watchBucket(cmdEnv)
 	case "du":
 		return diskUsage(cmdEnv)
+	case "count":
+		return countKeys(cmdEnv)
+	case "stats":
+		return printStats(cmdEnv)
+	case "seq":
+		return bucketSequence(cmdEnv)
+	case "incr":
+		return incrementCounter(cmdEnv)
+	case "export":
+		return exportBucketTree(cmdEnv)
+	case "import":
+		return importBucketTree(cmdEnv)
+	case "load":
+		return loadKeyValues(cmdEnv)
+	case "sync":
+		return syncSubtree(cmdEnv)
 	default:
 		return ErrUnknownCommand
 	}
@@ -165,6 +359,19 @@ Google Cloud Storage's gsutil(1).
 boltutil uses 'bolt://dbname/keypath' URIs to refer to buckets and keys
 within Bolt database files.
 
+Each segment of the key path is percent-decoded on its own, so a key that
+contains a slash is written as a single segment such as 'a%2Fb', and a space
+as '%20'. URIs printed by boltutil are escaped the same way, and the prefix
+given to sync is a key path written the same way.
+
+Key paths resolve as follows:
+
+    bolt://foo, bolt://foo/  the root of the database, which only holds
+                             buckets, so values are written inside a bucket
+    bolt://foo/a             the key a, whether a bucket or a value
+    bolt://foo/a/            the bucket a itself; fails if a is a value
+    bolt://foo/a//b          an error, as Bolt does not allow empty keys
+
 To refer to a key within a database, it must be mounted to an alias,
 using the -d or --database flag. The syntax of the flag is:
 
@@ -181,40 +388,255 @@ file, with the '.db' extension stripped, will be used as the dbname:
     # mounts <bolt://foo/...>
     boltutil --db "x/y/z/foo.db" [...]
 
+### DRY RUNS
+
+With the --dry-run flag, commands that write to a database list each key path
+they would write or delete, and roll back their transaction instead of
+committing it:
+
+    boltutil --dry-run -d "foo:foo.db" rm -r bolt://foo/old
+
+### OUTPUT ENCODING
+
+Keys and values are printed as hex by default. The --encoding flag selects
+another rendering for ls, get, tree, du and page:
+
+    --encoding hex|utf8|base64|auto
+
+'auto' prints keys and values as UTF-8 when they are valid UTF-8, and as hex
+otherwise.
+
+grep matches its pattern against this rendering, so with the default hex
+encoding a pattern such as '^0x00' matches values starting with a zero byte.
+
+The same encoding is used to read keys passed as flag arguments, such as
+ls --prefix and rm --prefix, the new name given to rename, the value given to
+append, the key given to find, and the bounds passed to range. An empty range
+bound, '', leaves that end of the range open. Hex arguments may be written
+with or without a leading '0x'.
+
+### SCRIPTING
+
+Keys and values may themselves contain newlines. The --print0 flag ends each
+record printed by ls, range, head, tail, keys, grep and find with a NUL byte
+instead of a newline, for use with 'xargs -0':
+
+    boltutil --print0 --encoding utf8 -d "foo:foo.db" keys bolt://foo/ | xargs -0 ...
+
+The --quote flag quotes each key, value and URI those commands print so
+that it can be pasted into a POSIX shell. Text that is not printable UTF-8
+is written with $'...' escapes, which bash, ksh and zsh understand.
+
+### LOCKING
+
+A database can be written by one process at a time, and commands that only
+read a database take a shared lock on it that lets other readers in. If
+another process holds a conflicting lock, boltutil waits for it for as long
+as the --timeout flag allows, 5 seconds by default, and then fails with
+"database is locked by another process":
+
+    --timeout DURATION
+
+The duration uses Go syntax, such as '500ms' or '1m'. A timeout of 0 waits
+indefinitely.
+
+### SHELL MODE
+
+'boltutil shell' keeps the mounted databases open and reads commands from
+stdin, one per line, using the same syntax as the command line. It also
+accepts:
+
+    cd [<bolt-uri>]  set the current bucket
+    pwd              print the current bucket
+    history          list previous commands; '!!' and '!N' repeat them
+    exit             leave the shell
+
+A path without the bolt:// scheme is resolved relative to the current bucket,
+so that 'ls sub', 'get ../key' and 'cd /' work as they would in a file
+system. cp still treats such paths as local files. ls, keys, tree, du, count,
+stats and grep default to the current bucket when no path is given. History is kept
+in ~/.boltutil_history.
+
 ### USAGES
 
-  boltutil touch <bolt-alias>
+  boltutil shell
+  boltutil touch [--page-size N] <bolt-alias>
+  boltutil compact <bolt-alias> <dest-path>
+  boltutil backup [--compact] <bolt-alias> <dest-path|->
+  boltutil check [--summary] <bolt-alias>
+  boltutil page <bolt-alias> <pageid>
+  boltutil page <bolt-alias> --meta
+  boltutil txid <bolt-alias>
 
   boltutil get <bolt-uri>
+  boltutil exists [-v] [--key-only] <bolt-uri>
   boltutil put <bolt-uri> <value>
+  boltutil put [--stdin] <bolt-uri> [-]
+  boltutil append <bolt-uri> <value>
 
   boltutil mkdir <bolt-uri>
-  boltutil rm [-r] <bolt-uri>
+  boltutil rm [-r] [-f] [--confirm-threshold N] <bolt-uri>
+  boltutil rm --prefix PREFIX [-f] [--confirm-threshold N] <bolt-uri>
+  boltutil clear [-f] <bolt-uri>
   boltutil cp [-r] <bolt-uri> <bolt-uri>
-
-  boltutil ls <bolt-uri>
-  boltutil tree [-d MAXDEPTH] <bolt-uri>
-  boltutil du [-d MAXDEPTH] <bolt-uri>
+  boltutil mv [-r] <bolt-uri> <bolt-uri>
+  boltutil rename <bolt-uri> <new-name>
+
+  boltutil ls <bolt-uri> [--glob PATTERN] [--prefix PREFIX] [--summary]
+  boltutil keys [-0] <bolt-uri> [--glob PATTERN] [--prefix PREFIX]
+  boltutil grep [-r] [--keys] [-c] <pattern> <bolt-uri>
+  boltutil find <bolt-alias> <key> [--value PATTERN | --buckets]
+  boltutil range <bolt-uri> <start> <end> [--limit N] [--reverse]
+  boltutil head <bolt-uri> [-n N]
+  boltutil tail <bolt-uri> [-n N]
+  boltutil tree [-d MAXDEPTH] <bolt-uri> [--format plain|json|yaml]
+  boltutil du [-d MAXDEPTH] [--breakdown] [--sort size] <bolt-uri>
+  boltutil count [-r] [--buckets] <bolt-uri>
+  boltutil stats <bolt-uri>
+  boltutil seq get <bolt-uri>
+  boltutil seq set <bolt-uri> <n>
+  boltutil incr <bolt-uri> [delta]
+  boltutil diff [-r] <bolt-uri> <bolt-uri>
+  boltutil watch [-r] <bolt-uri> [--interval DURATION]
+
+  boltutil export [--hex] [-d MAXDEPTH] <bolt-uri>
+  boltutil import [--hex] [--merge] <bolt-uri>
+  boltutil load <bolt-uri> [--format tsv|csv] [--base64]
+
+  boltutil sync <src-alias> <dest-alias> <prefix>
 `, "\n")
 }
 
-func resolveBoltURI(env *commandEnvironment, rawURI string, wantWritableTx bool, cb func(*bolt.Location) error) error {
+func parseBoltURI(rawURI string) (mountAlias string, keyPath []string, err error) {
 	uri, err := url.Parse(rawURI)
 	if err != nil {
-		return err
+		return "", nil, err
 	}
 	if uri.Scheme != "bolt" {
-		return ErrBoltURIRequired
+		return "", nil, ErrBoltURIRequired
+	}
+
+	keyPath, err = unescapeKeyPath(uri.EscapedPath())
+	if err != nil {
+		return "", nil, err
+	}
+	return uri.Hostname(), keyPath, nil
+}
+
+// parseBoltURI is like the package-level parseBoltURI, except that in shell mode a
+// path without the bolt:// scheme is resolved against the current bucket. Such
+// a path may use '.' and '..' segments, and a leading '/' refers to the root
+// of the current database.
+func (env *commandEnvironment) parseBoltURI(rawURI string) (mountAlias string, keyPath []string, err error) {
+	if isBoltURI(rawURI) || env.cwd == "" {
+		return parseBoltURI(rawURI)
+	}
+
+	if rawURI == "" {
+		return "", nil, ErrEmptyKeySegment
+	}
+	segments, err := splitKeyPath(rawURI)
+	if err != nil {
+		return "", nil, err
+	}
+
+	mountAlias, keyPath, err = parseBoltURI(env.cwd)
+	if err != nil {
+		return "", nil, err
+	}
+	if strings.HasPrefix(rawURI, "/") {
+		keyPath = nil
+	}
+
+	for _, segment := range segments {
+		switch segment {
+		case ".":
+		case "..":
+			if len(keyPath) > 0 {
+				keyPath = keyPath[:len(keyPath)-1]
+			}
+		default:
+			key, err := url.PathUnescape(segment)
+			if err != nil {
+				return "", nil, err
+			}
+			keyPath = append(keyPath, key)
+		}
+	}
+	return mountAlias, keyPath, nil
+}
+
+// splitKeyPath splits an escaped key path into its segments. A leading and a
+// trailing slash are ignored, but any other empty segment is an error.
+func splitKeyPath(p string) ([]string, error) {
+	p = strings.TrimSuffix(strings.TrimPrefix(p, "/"), "/")
+	if p == "" {
+		return nil, nil
+	}
+
+	segments := strings.Split(p, "/")
+	for _, segment := range segments {
+		if segment == "" {
+			return nil, ErrEmptyKeySegment
+		}
+	}
+	return segments, nil
+}
+
+// unescapeKeyPath splits an escaped key path with splitKeyPath and
+// percent-decodes each segment. The path is split before it is unescaped, so
+// that a key containing a slash can be written as a single %2F-escaped
+// segment.
+func unescapeKeyPath(p string) ([]string, error) {
+	segments, err := splitKeyPath(p)
+	if err != nil {
+		return nil, err
+	}
+
+	var keyPath []string
+	for _, segment := range segments {
+		key, err := url.PathUnescape(segment)
+		if err != nil {
+			return nil, err
+		}
+		keyPath = append(keyPath, key)
+	}
+	return keyPath, nil
+}
+
+// orCurrentBucket returns args, or the current bucket if args is empty and
+// the command is running in shell mode.
+func (env *commandEnvironment) orCurrentBucket(args []string) []string {
+	if len(args) == 0 && env.cwd != "" {
+		return []string{"."}
+	}
+	return args
+}
+
+func resolveBoltURI(env *commandEnvironment, rawURI string, wantWritableTx bool, cb func(*bolt.Location) error) error {
+	mountAlias, keyPath, err := env.parseBoltURI(rawURI)
+	if err != nil {
+		return err
 	}
 
-	mountAlias := uri.Hostname()
+	// A trailing slash names the bucket itself, so it may not resolve to a
+	// scalar value.
+	if strings.HasSuffix(rawURI, "/") {
+		next := cb
+		cb = func(loc *bolt.Location) error {
+			if loc.IsScalar() {
+				return ErrKeyIsNotBucket
+			}
+			return next(loc)
+		}
+	}
 
 	if txHandle, ok := env.txHandles[mountAlias]; ok {
 		if wantWritableTx && !txHandle.Writable() {
 			return bolt.ErrTxNotWritable
 		}
 
-		loc, err := navigateToLocation(txHandle, uri.Path)
+		loc, err := navigateToLocation(txHandle, keyPath)
 		if err != nil {
 			return err
 		}
@@ -227,17 +649,21 @@ func resolveBoltURI(env *commandEnvironment, rawURI string, wantWritableTx bool,
 		return ErrAliasNotFound
 	}
 
+	// Reads only take a shared lock, so that they can run alongside other
+	// readers of the same file.
+	var options *bolt.Options
 	if !wantWritableTx {
 		if _, err := os.Stat(path); os.IsNotExist(err) {
 			return ErrFileNotFound
 		}
+		options = &bolt.Options{ReadOnly: true}
 	}
 
-	db, err := bolt.Open(path, 0666, nil)
+	db, closeDB, err := env.openDB(path, 0666, options)
 	if err != nil {
 		return err
 	}
-	defer db.Close()
+	defer closeDB()
 
 	task := func(txHandle *bolt.Tx) error {
 		env.txHandles[mountAlias] = txHandle
@@ -245,7 +671,7 @@ func resolveBoltURI(env *commandEnvironment, rawURI string, wantWritableTx bool,
 			delete(env.txHandles, mountAlias)
 		}()
 
-		loc, err := navigateToLocation(txHandle, uri.Path)
+		loc, err := navigateToLocation(txHandle, keyPath)
 		if err != nil {
 			return err
 		}
@@ -253,13 +679,53 @@ func resolveBoltURI(env *commandEnvironment, rawURI string, wantWritableTx bool,
 		return cb(loc)
 	}
 
-	if wantWritableTx {
+	if wantWritableTx && env.dryRun {
+		// Perform the writes so that they can be reported, but never commit them.
+		txHandle, err := db.Begin(true)
+		if err != nil {
+			return err
+		}
+		defer txHandle.Rollback()
+
+		return task(txHandle)
+	} else if wantWritableTx {
 		return db.Update(task)
 	} else {
 		return db.View(task)
 	}
 }
 
+// defaultOpenTimeout is how long openDB waits for another process to release
+// the lock on a database when --timeout is not given.
+const defaultOpenTimeout = 5 * time.Second
+
+// openDB opens the database at path, or returns the handle shell mode keeps
+// open for it. The returned function must be called to release the database.
+// Unless options set their own timeout, openDB waits for the lock for as long
+// as --timeout allows. Returns ErrDatabaseLocked if another process holds the
+// database lock for longer than that.
+func (env *commandEnvironment) openDB(path string, mode os.FileMode, options *bolt.Options) (*bolt.DB, func() error, error) {
+	if db, ok := env.dbs[path]; ok {
+		return db, func() error { return nil }, nil
+	}
+
+	o := *bolt.DefaultOptions
+	if options != nil {
+		o = *options
+	}
+	if o.Timeout == 0 {
+		o.Timeout = env.openTimeout
+	}
+
+	db, err := bolt.Open(path, mode, &o)
+	if err == bolt.ErrTimeout {
+		return nil, nil, ErrDatabaseLocked
+	} else if err != nil {
+		return nil, nil, err
+	}
+	return db, db.Close, nil
+}
+
 func isBoltURI(rawURI string) bool {
 	uri, err := url.Parse(rawURI)
 	if err != nil {
@@ -272,32 +738,30 @@ func isBoltURI(rawURI string) bool {
 	return true
 }
 
-func slashP(c rune) bool {
-	return c == '/'
-}
-
-func navigateToLocation(txHandle *bolt.Tx, path string) (*bolt.Location, error) {
-	keyPath := strings.FieldsFunc(strings.Trim(path, "/"), slashP)
-
-	var keyPathLast []byte
-
-	if len(keyPath) > 0 {
-		keyPathLast = []byte(keyPath[len(keyPath)-1])
-		keyPath = keyPath[:len(keyPath)-1]
+func navigateToLocation(txHandle *bolt.Tx, keyPath []string) (*bolt.Location, error) {
+	path := make([][]byte, len(keyPath))
+	for i, childKey := range keyPath {
+		path[i] = []byte(childKey)
 	}
 
-	bish := bolt.Bucketish(txHandle)
-	for _, childKey := range keyPath {
-		bish = bish.Bucket([]byte(childKey))
-		if b, ok := bish.(*bolt.Bucket); !ok || b == nil {
-			return nil, ErrBucketNotFound
-		}
+	loc, err := bolt.Navigate(txHandle, path)
+	if err == bolt.ErrBucketNotFound {
+		return nil, ErrBucketNotFound
 	}
-
-	return bolt.NewLocation(bish, keyPathLast), nil
+	return loc, err
 }
 
 func touchDatabaseFile(env *commandEnvironment) error {
+	var options *bolt.Options
+	if len(env.args) >= 2 && env.args[0] == "--page-size" {
+		pageSize, err := strconv.Atoi(env.args[1])
+		if err != nil {
+			return fmt.Errorf("invalid page size: %q", env.args[1])
+		}
+		options = &bolt.Options{PageSize: pageSize}
+		env.args = env.args[2:]
+	}
+
 	if len(env.args) != 1 {
 		return ErrUsage
 	}
@@ -309,282 +773,2920 @@ func touchDatabaseFile(env *commandEnvironment) error {
 		return ErrAliasNotFound
 	}
 
-	db, err := bolt.Open(path, 0666, nil)
+	_, closeDB, err := env.openDB(path, 0666, options)
 	if err != nil {
 		return err
 	}
-	defer db.Close()
+	defer closeDB()
 
 	return nil
 }
 
-func getKey(env *commandEnvironment) error {
-	if len(env.args) != 1 {
-		return ErrUsage
-	}
-
-	return resolveBoltURI(env, env.args[0], false, func(loc *bolt.Location) error {
-		something := loc.ResolveHere()
-
-		if v, ok := something.([]byte); ok && v != nil {
-			fmt.Printf("%#x\n", v)
-			return nil
-		} else if rb, ok := something.(*bolt.Tx); ok && rb != nil {
-			return ErrKeyIsBucket
-		} else if b, ok := something.(*bolt.Bucket); ok && b != nil {
-			return ErrKeyIsBucket
-		} else {
-			return ErrKeyNotFound
-		}
-	})
-}
-
-func putKeyValue(env *commandEnvironment) error {
+func compactDatabaseFile(env *commandEnvironment) error {
 	if len(env.args) != 2 {
 		return ErrUsage
 	}
 
-	return resolveBoltURI(env, env.args[0], true, func(loc *bolt.Location) error {
-		return loc.PutHere([]byte(env.args[1]))
-	})
-}
+	mountAlias, destPath := env.args[0], env.args[1]
 
-func makeBucket(env *commandEnvironment) error {
-	if len(env.args) != 1 {
-		return ErrUsage
+	srcPath, ok := env.mounts[mountAlias]
+	if !ok {
+		return ErrAliasNotFound
 	}
 
-	return resolveBoltURI(env, env.args[0], true, func(loc *bolt.Location) error {
-		_, err := loc.CreateBucketHereIfNotExists()
+	fi, err := os.Stat(srcPath)
+	if os.IsNotExist(err) {
+		return ErrFileNotFound
+	} else if err != nil {
 		return err
-	})
-}
+	}
+	initialSize := fi.Size()
 
-func removeKey(env *commandEnvironment) error {
-	recurse := false
-	if len(env.args) >= 1 && (env.args[0] == "-r" || env.args[0] == "--recurse") {
-		recurse = true
-		env.args = env.args[1:]
+	src, closeSrc, err := env.openDB(srcPath, 0444, &bolt.Options{ReadOnly: true})
+	if err != nil {
+		return err
 	}
+	defer closeSrc()
 
-	if len(env.args) != 1 {
-		return ErrUsage
+	if err := createViaTempFile(destPath, fi.Mode(), func(tmpPath string) error {
+		return compactInto(src, tmpPath, fi.Mode())
+	}); err != nil {
+		return err
 	}
 
-	return resolveBoltURI(env, env.args[0], true, func(loc *bolt.Location) error {
-		something := loc.ResolveHere()
+	fi, err = os.Stat(destPath)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(env.outIO, "%s -> %s\n", formatByteSize(uint64(initialSize)), formatByteSize(uint64(fi.Size())))
 
-		if rb, ok := something.(*bolt.Tx); ok && rb != nil {
-			return ErrBucketIsRoot
-		} else if b, ok := something.(*bolt.Bucket); ok && b != nil {
-			if !(bucketIsEmpty(b) || recurse) {
-				return ErrBucketNotEmpty
-			}
-			return loc.DeleteBucketHere()
-		} else if v, ok := something.([]byte); ok && v != nil {
-			return loc.DeleteHere()
-		} else {
-			return ErrKeyNotFound
-		}
-	})
+	return nil
 }
 
-func bucketIsEmpty(b *bolt.Bucket) bool {
-	err := b.ForEach(func(k, v []byte) error {
-		return ErrKeyFound
-	})
-
-	return err == nil
-}
+func backupDatabaseFile(env *commandEnvironment) error {
+	compact := false
+	var positional []string
+	for _, arg := range env.args {
+		switch {
+		case arg == "--compact":
+			compact = true
+		case strings.HasPrefix(arg, "-") && arg != "-":
+			return ErrUsage
+		default:
+			positional = append(positional, arg)
+		}
+	}
+	env.args = positional
 
-func copyKeyWithFile(env *commandEnvironment) error {
 	if len(env.args) != 2 {
 		return ErrUsage
 	}
 
-	srcIsBolt := isBoltURI(env.args[0])
-	destIsBolt := isBoltURI(env.args[1])
+	mountAlias, destPath := env.args[0], env.args[1]
 
-	if srcIsBolt && destIsBolt {
-		return resolveBoltURI(env, env.args[0], false, func(srcLoc *bolt.Location) error {
-			return resolveBoltURI(env, env.args[1], true, func(destLoc *bolt.Location) error {
-				v := srcLoc.GetHere()
-				if v == nil {
-					return ErrKeyNotFound
-				}
+	srcPath, ok := env.mounts[mountAlias]
+	if !ok {
+		return ErrAliasNotFound
+	}
 
-				return destLoc.PutHere(v)
-			})
-		})
-	} else if !destIsBolt {
-		return resolveBoltURI(env, env.args[0], false, func(loc *bolt.Location) error {
-			v := loc.GetHere()
-			if v == nil {
-				return ErrKeyNotFound
-			}
+	fi, err := os.Stat(srcPath)
+	if os.IsNotExist(err) {
+		return ErrFileNotFound
+	} else if err != nil {
+		return err
+	}
 
-			return ioutil.WriteFile(env.args[1], v, 0644)
-		})
-	} else if !srcIsBolt {
-		return resolveBoltURI(env, env.args[1], true, func(loc *bolt.Location) error {
-			v, err := ioutil.ReadFile(env.args[0])
+	src, closeSrc, err := env.openDB(srcPath, 0444, &bolt.Options{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer closeSrc()
+
+	// Either way, the source is read within a single read-only transaction,
+	// so the copy is consistent as of the moment the backup started.
+	var n int64
+	writeBackup := func(tmpPath string) error {
+		if compact {
+			if err := compactInto(src, tmpPath, fi.Mode()); err != nil {
+				return err
+			}
+			tfi, err := os.Stat(tmpPath)
 			if err != nil {
 				return err
 			}
+			n = tfi.Size()
+			return nil
+		}
 
-			return loc.PutHere(v)
-		})
-	} else {
-		return errors.New("at least one of src and dest must be a <bolt://...> URI")
+		f, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_TRUNC, fi.Mode())
+		if err != nil {
+			return err
+		}
+		if n, err = src.Backup(f); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
 	}
-}
 
-func listKeys(env *commandEnvironment) error {
-	if len(env.args) != 1 {
-		return ErrUsage
+	if destPath != "-" {
+		if err := createViaTempFile(destPath, fi.Mode(), writeBackup); err != nil {
+			return err
+		}
+		fmt.Fprintf(env.outIO, "wrote %d bytes to %s\n", n, destPath)
+		return nil
 	}
 
-	return resolveBoltURI(env, env.args[0], false, func(loc *bolt.Location) error {
-		something := loc.ResolveHere()
-		var listKeysOf bolt.Bucketish
-
-		if b, ok := something.(*bolt.Bucket); ok && b != nil {
-			fmt.Printf("[is a bucket]\n")
-			listKeysOf = b
-		} else if rb, ok := something.(*bolt.Tx); ok && rb != nil {
-			fmt.Printf("[is a root bucket]\n")
-			listKeysOf = rb
-		} else if v, ok := something.([]byte); ok && v != nil {
-			fmt.Printf("[is a scalar value]\n")
-			return nil
-		} else {
-			return ErrKeyNotFound
+	// The backup itself goes to stdout, so report on stderr.
+	if !compact {
+		if n, err = src.Backup(env.outIO); err != nil {
+			return err
 		}
+	} else {
+		tmpFile, err := ioutil.TempFile("", "boltutil-backup")
+		if err != nil {
+			return err
+		}
+		tmpPath := tmpFile.Name()
+		tmpFile.Close()
+		defer os.Remove(tmpPath)
 
-		return listKeysOf.ForEach(func(k []byte, v []byte) error {
-			if v == nil {
-				fmt.Printf("%#x (bucket)\n", k)
-			} else if len(v) < 50 {
-				fmt.Printf("%#x = %#x\n", k, v)
-			} else {
-				fmt.Printf("%#x = <%d bytes>\n", k, len(v))
-			}
-			return nil
-		})
-	})
-}
-
-func printBucketTree(env *commandEnvironment) (err error) {
-	maxDepth := int64(-1)
-	if len(env.args) >= 2 && (env.args[0] == "-d" || env.args[0] == "--max-depth") {
-		maxDepth, err = strconv.ParseInt(env.args[1], 10, 64)
+		if err := writeBackup(tmpPath); err != nil {
+			return err
+		}
+		f, err := os.Open(tmpPath)
 		if err != nil {
 			return err
 		}
-		env.args = env.args[2:]
+		defer f.Close()
+		if n, err = io.Copy(env.outIO, f); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(env.errIO, "wrote %d bytes\n", n)
+	return nil
+}
+
+func checkDatabaseFile(env *commandEnvironment) error {
+	summary := false
+	if len(env.args) >= 1 && env.args[0] == "--summary" {
+		summary = true
+		env.args = env.args[1:]
 	}
 
 	if len(env.args) != 1 {
 		return ErrUsage
 	}
 
-	return resolveBoltURI(env, env.args[0], false, func(loc *bolt.Location) error {
-		something := loc.ResolveHere()
-		var bish bolt.Bucketish
+	path, ok := env.mounts[env.args[0]]
+	if !ok {
+		return ErrAliasNotFound
+	}
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return ErrFileNotFound
+	}
 
-		if b, ok := something.(*bolt.Bucket); ok && b != nil {
-			bish = b
-		} else if rb, ok := something.(*bolt.Tx); ok && rb != nil {
-			bish = rb
-		} else {
-			return ErrBucketNotFound
+	db, closeDB, err := env.openDB(path, 0444, &bolt.Options{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer closeDB()
+
+	errs := db.Check()
+
+	if summary {
+		var pageN int64
+		if err := db.View(func(tx *bolt.Tx) error {
+			pageN = tx.Size() / int64(db.Info().PageSize)
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		leakN := 0
+		for _, err := range errs {
+			if _, ok := err.(*bolt.UnreachablePageError); ok {
+				leakN++
+			}
+		}
+
+		fmt.Fprintf(env.outIO, "pages: %d\n", pageN)
+		fmt.Fprintf(env.outIO, "free pages: %d\n", db.Stats().FreePageN)
+		fmt.Fprintf(env.outIO, "leaked pages: %d\n", leakN)
+		fmt.Fprintf(env.outIO, "problems: %d\n", len(errs))
+	} else {
+		for _, err := range errs {
+			fmt.Fprintln(env.outIO, err)
+		}
+		if len(errs) == 0 {
+			fmt.Fprintln(env.outIO, "OK")
+		}
+	}
+
+	if len(errs) > 0 {
+		return ErrCheckFailed
+	}
+	return nil
+}
+
+func inspectPage(env *commandEnvironment) error {
+	if len(env.args) != 2 {
+		return ErrUsage
+	}
+
+	path, ok := env.mounts[env.args[0]]
+	if !ok {
+		return ErrAliasNotFound
+	}
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return ErrFileNotFound
+	}
+
+	showMeta := env.args[1] == "--meta"
+	var id int
+	if !showMeta {
+		var err error
+		if id, err = strconv.Atoi(env.args[1]); err != nil || id < 0 {
+			return fmt.Errorf("invalid page id: %q", env.args[1])
+		}
+	}
+
+	db, closeDB, err := env.openDB(path, 0444, &bolt.Options{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer closeDB()
+
+	return db.View(func(tx *bolt.Tx) error {
+		if showMeta {
+			for id := 0; id < 2; id++ {
+				p, err := tx.Page(id)
+				if err != nil {
+					return fmt.Errorf("cannot decode %s", err)
+				}
+				printMetaPage(env, p, tx.ID())
+			}
+			return nil
+		}
+
+		p, err := tx.Page(id)
+		if err != nil {
+			return fmt.Errorf("cannot decode %s", err)
+		} else if p == nil {
+			return ErrPageNotFound
+		}
+		if p.Meta != nil {
+			printMetaPage(env, p, tx.ID())
+			return nil
+		}
+
+		fmt.Fprintf(env.outIO, "page: %d\n", p.ID)
+		fmt.Fprintf(env.outIO, "type: %s\n", p.Type)
+		fmt.Fprintf(env.outIO, "flags: 0x%02x\n", p.Flags)
+		fmt.Fprintf(env.outIO, "overflow: %d\n", p.OverflowCount)
+		fmt.Fprintf(env.outIO, "count: %d\n", p.Count)
+
+		w := tabwriter.NewWriter(env.outIO, 0, 8, 2, ' ', 0)
+		for _, e := range p.Elements {
+			switch {
+			case p.Type == "branch":
+				fmt.Fprintf(w, "  %d\t%s\t-> page %d\n", e.Offset, env.formatBytes(e.Key), e.Child)
+			case e.IsBucket:
+				fmt.Fprintf(w, "  %d\t%s\t%d bytes (bucket)\n", e.Offset, env.formatBytes(e.Key), e.ValueSize)
+			default:
+				fmt.Fprintf(w, "  %d\t%s\t%d bytes\n", e.Offset, env.formatBytes(e.Key), e.ValueSize)
+			}
 		}
+		return w.Flush()
+	})
+}
 
-		printBucketTreeNode(bish, 0, maxDepth)
+// printTxID prints the id of the transaction a reader of the database sees,
+// which is the id of the last committed write, and the size of the database
+// as of that transaction.
+func printTxID(env *commandEnvironment) error {
+	if len(env.args) != 1 {
+		return ErrUsage
+	}
 
+	return resolveBoltURI(env, "bolt://"+env.args[0], false, func(loc *bolt.Location) error {
+		tx := loc.RootBucketHere()
+		if tx == nil {
+			return ErrBucketNotFound
+		}
+		fmt.Fprintf(env.outIO, "txid: %d\n", tx.ID())
+		fmt.Fprintf(env.outIO, "size: %d (%s)\n", tx.Size(), formatByteSize(uint64(tx.Size())))
 		return nil
 	})
 }
 
-func printBucketTreeNode(bish bolt.Bucketish, atDepth int64, maxDepth int64) {
-	if atDepth == maxDepth {
-		return
+// printMetaPage prints the fields of a meta page, marking it active when it
+// holds the transaction id the database was opened at.
+func printMetaPage(env *commandEnvironment, p *bolt.PageInfo, txid int) {
+	status := ""
+	if p.Meta.Valid && p.Meta.TxID == txid {
+		status = " (active)"
+	}
+	checksum := "ok"
+	if !p.Meta.Valid {
+		checksum = "invalid"
 	}
 
-	indentStr := strings.Repeat(" ", int(atDepth*2))
+	fmt.Fprintf(env.outIO, "meta %d%s\n", p.ID, status)
+	fmt.Fprintf(env.outIO, "  version: %d\n", p.Meta.Version)
+	fmt.Fprintf(env.outIO, "  page size: %d\n", p.Meta.PageSize)
+	fmt.Fprintf(env.outIO, "  root: %d\n", p.Meta.Root)
+	fmt.Fprintf(env.outIO, "  freelist: %d\n", p.Meta.Freelist)
+	fmt.Fprintf(env.outIO, "  pages: %d\n", p.Meta.PageN)
+	fmt.Fprintf(env.outIO, "  txid: %d\n", p.Meta.TxID)
+	fmt.Fprintf(env.outIO, "  checksum: 0x%016x (%s)\n", p.Meta.Checksum, checksum)
+}
 
-	bish.ForEach(func(k []byte, v []byte) error {
-		if v == nil {
-			fmt.Printf("%s%#x/\n", indentStr, k)
-			printBucketTreeNode(bish.Bucket(k), atDepth+1, maxDepth)
+// compactInto compacts src into a new database at path.
+func compactInto(src *bolt.DB, path string, mode os.FileMode) error {
+	dst, err := bolt.Open(path, mode, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := src.CompactTo(dst, 65536, nil); err != nil {
+		dst.Close()
+		return err
+	}
+	return dst.Close()
+}
+
+// createViaTempFile calls fn with the path of an empty temporary file
+// alongside destPath, and only moves it into place once fn succeeds, so that
+// an interrupted write never leaves a partial file at destPath.
+func createViaTempFile(destPath string, mode os.FileMode, fn func(tmpPath string) error) error {
+	tmpFile, err := ioutil.TempFile(filepath.Dir(destPath), filepath.Base(destPath)+".tmp")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmpFile.Name()
+	tmpFile.Close()
+	defer os.Remove(tmpPath)
+
+	if err := os.Chmod(tmpPath, mode); err != nil {
+		return err
+	}
+
+	if err := fn(tmpPath); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, destPath)
+}
+
+func getKey(env *commandEnvironment) error {
+	if len(env.args) != 1 {
+		return ErrUsage
+	}
+
+	return resolveBoltURI(env, env.args[0], false, func(loc *bolt.Location) error {
+		if loc.IsScalar() {
+			fmt.Printf("%s\n", env.formatBytes(loc.GetHere()))
+			return nil
+		} else if loc.IsBucket() {
+			return ErrKeyIsBucket
 		} else {
-			fmt.Printf("%s%#x\n", indentStr, k)
+			return ErrKeyNotFound
 		}
-		return nil
 	})
 }
 
-func diskUsage(env *commandEnvironment) error {
+func checkExists(env *commandEnvironment) error {
+	verbose := false
+	keyOnly := false
+	for len(env.args) >= 1 && strings.HasPrefix(env.args[0], "-") {
+		switch env.args[0] {
+		case "-v", "--verbose":
+			verbose = true
+		case "--key-only":
+			keyOnly = true
+		default:
+			return ErrUsage
+		}
+		env.args = env.args[1:]
+	}
+
 	if len(env.args) != 1 {
 		return ErrUsage
 	}
 
-	return resolveBoltURI(env, env.args[0], false, func(loc *bolt.Location) error {
-		something := loc.ResolveHere()
-		var bish bolt.Bucketish
+	kind := ""
+	err := resolveBoltURI(env, env.args[0], false, func(loc *bolt.Location) error {
+		if loc.Key() == nil {
+			kind = "root bucket"
+		} else if loc.Parent().Has(loc.Key()) {
+			kind = "key"
+		} else if loc.Parent().Bucket(loc.Key()) != nil {
+			kind = "bucket"
+		}
+		return nil
+	})
+	if err == ErrBucketNotFound {
+		err = nil
+	} else if err != nil {
+		return err
+	}
 
-		if b, ok := something.(*bolt.Bucket); ok && b != nil {
-			bish = b
-		} else if rb, ok := something.(*bolt.Tx); ok && rb != nil {
-			bish = rb
+	if kind != "" && (kind == "key" || !keyOnly) {
+		if verbose {
+			fmt.Fprintf(env.outIO, "%s: %s\n", env.args[0], kind)
+		}
+		return nil
+	}
+
+	if verbose {
+		if kind != "" {
+			fmt.Fprintf(env.outIO, "%s: %s, not a key\n", env.args[0], kind)
 		} else {
-			return ErrBucketNotFound
+			fmt.Fprintf(env.outIO, "%s: not found\n", env.args[0])
 		}
+	}
+	return ErrNotExist
+}
 
-		printDiskUsageOfNode(bish, 0, -1)
+func putKeyValue(env *commandEnvironment) error {
+	fromStdin := false
+	if len(env.args) >= 1 && env.args[0] == "--stdin" {
+		fromStdin = true
+		env.args = env.args[1:]
+	} else if len(env.args) == 2 && env.args[1] == "-" {
+		fromStdin = true
+		env.args = env.args[:1]
+	}
 
-		return nil
+	if fromStdin && len(env.args) != 1 {
+		return ErrUsage
+	} else if !fromStdin && len(env.args) != 2 {
+		return ErrUsage
+	}
+
+	var value []byte
+	if fromStdin {
+		var buf bytes.Buffer
+		if _, err := buf.ReadFrom(env.inIO); err != nil {
+			return err
+		}
+		// An empty stdin still writes an empty, but present, value.
+		value = append([]byte{}, buf.Bytes()...)
+	} else {
+		value = []byte(env.args[1])
+	}
+
+	return resolveBoltURI(env, env.args[0], true, func(loc *bolt.Location) error {
+		env.reportWrite("put %s", env.args[0])
+		return putAt(loc, value)
 	})
 }
 
-func printDiskUsageOfNode(bish bolt.Bucketish, atDepth int64, maxDepth int64) {
-	if atDepth == maxDepth {
-		return
+// appendValue appends a chunk, read with --encoding, to the value of a key,
+// within a single write transaction. A missing key starts out empty.
+func appendValue(env *commandEnvironment) error {
+	if len(env.args) != 2 {
+		return ErrUsage
 	}
 
-	indentStr := strings.Repeat(" ", int(atDepth*2))
+	chunk, err := env.parseBytes(env.args[1])
+	if err != nil {
+		return err
+	}
 
-	bish.ForEach(func(k []byte, v []byte) error {
-		if v == nil {
-			sb := bish.Bucket(k)
-			fmt.Printf("%s%#x = %s\n", indentStr, k, formatByteSize(sb.StandaloneSize()))
-			printDiskUsageOfNode(sb, atDepth+1, maxDepth)
+	return resolveBoltURI(env, env.args[0], true, func(loc *bolt.Location) error {
+		if loc.Key() == nil {
+			return ErrKeyRequired
+		}
+		b, ok := loc.Parent().(*bolt.Bucket)
+		if !ok {
+			return ErrScalarAtRoot
+		}
+
+		err := b.Modify(loc.Key(), func(old []byte) ([]byte, error) {
+			return append(append(make([]byte, 0, len(old)+len(chunk)), old...), chunk...), nil
+		})
+		if err == bolt.ErrIncompatibleValue {
+			return ErrKeyIsBucket
+		} else if err != nil {
+			return err
 		}
+
+		env.reportWrite("put %s", env.args[0])
 		return nil
 	})
 }
 
-func formatByteSize(size uint64) string {
-	switch getExp(size) {
-	case 0:
-		return "0"
-	case 1:
-		return fmt.Sprintf("%db", size)
-	case 2:
-		return fmt.Sprintf("%.1fK", float64(size)/1024)
-	case 3:
-		return fmt.Sprintf("%.1fM", float64(size)/(1024*1024))
-	case 4:
-		return fmt.Sprintf("%.1fG", float64(size)/(1024*1024*1024))
-	default:
-		return fmt.Sprintf("%.1fT", float64(size)/(1024*1024*1024*1024))
+// putAt writes a scalar value at loc, which must lie inside a bucket.
+func putAt(loc *bolt.Location, value []byte) error {
+	if _, ok := loc.Parent().(*bolt.Tx); ok {
+		return ErrScalarAtRoot
 	}
+	return loc.PutHere(value)
 }
 
-func getExp(size uint64) (exp int) {
-	for size > 0 {
-		exp += 1
-		size /= 1024
+func makeBucket(env *commandEnvironment) error {
+	if len(env.args) != 1 {
+		return ErrUsage
 	}
-	return
+
+	return resolveBoltURI(env, env.args[0], true, func(loc *bolt.Location) error {
+		_, err := loc.CreateBucketHereIfNotExists()
+		return err
+	})
+}
+
+// defaultConfirmThreshold is the number of keys above which rm -r asks for
+// confirmation before deleting a bucket.
+const defaultConfirmThreshold = 1000
+
+func removeKey(env *commandEnvironment) (err error) {
+	recurse := false
+	force := false
+	var prefix []byte
+	confirmThreshold := uint64(defaultConfirmThreshold)
+	for len(env.args) >= 1 && strings.HasPrefix(env.args[0], "-") {
+		switch {
+		case env.args[0] == "-r" || env.args[0] == "--recurse":
+			recurse = true
+			env.args = env.args[1:]
+		case len(env.args) >= 2 && env.args[0] == "--prefix":
+			prefix, err = env.parseBytes(env.args[1])
+			if err != nil {
+				return err
+			}
+			env.args = env.args[2:]
+		case env.args[0] == "-f" || env.args[0] == "--force":
+			force = true
+			env.args = env.args[1:]
+		case len(env.args) >= 2 && env.args[0] == "--confirm-threshold":
+			confirmThreshold, err = strconv.ParseUint(env.args[1], 10, 64)
+			if err != nil {
+				return err
+			}
+			env.args = env.args[2:]
+		default:
+			return ErrUsage
+		}
+	}
+
+	if len(env.args) != 1 {
+		return ErrUsage
+	}
+
+	if prefix != nil {
+		return removeKeysWithPrefix(env, prefix, force, confirmThreshold)
+	}
+
+	return resolveBoltURI(env, env.args[0], true, func(loc *bolt.Location) error {
+		something := loc.ResolveHere()
+
+		if rb, ok := something.(*bolt.Tx); ok && rb != nil {
+			return ErrBucketIsRoot
+		} else if b, ok := something.(*bolt.Bucket); ok && b != nil {
+			if !(bucketIsEmpty(b) || recurse) {
+				return ErrBucketNotEmpty
+			}
+			if !force {
+				n, err := countKeysOfNode(b, true, false)
+				if err != nil {
+					return err
+				}
+				if n > confirmThreshold {
+					prompt := fmt.Sprintf("delete %s and its %d keys?", env.args[0], n)
+					if err := confirm(env, prompt); err != nil {
+						return err
+					}
+				}
+			}
+			if env.dryRun {
+				walkSubtreeURIs(b, env.args[0], func(uri string, isBucket bool) {
+					env.reportWrite("delete %s", uri)
+				})
+				env.reportWrite("delete %s", env.args[0])
+			}
+			return loc.DeleteBucketHere()
+		} else if v, ok := something.([]byte); ok && v != nil {
+			env.reportWrite("delete %s", env.args[0])
+			return loc.DeleteHere()
+		} else {
+			return ErrKeyNotFound
+		}
+	})
+}
+
+// removeKeysWithPrefix deletes the scalar keys starting with prefix from the
+// bucket at env.args[0], and prints how many were removed.
+func removeKeysWithPrefix(env *commandEnvironment, prefix []byte, force bool, confirmThreshold uint64) error {
+	return resolveBoltURI(env, env.args[0], true, func(loc *bolt.Location) error {
+		bish, err := bucketishAt(loc)
+		if err != nil {
+			return err
+		}
+
+		var n uint64
+		if err := bish.ForEachPrefix(prefix, func(k []byte, v []byte) error {
+			if v != nil {
+				n++
+				env.reportWrite("delete %s", joinURI(env.args[0], k))
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+		if !force && n > confirmThreshold {
+			prompt := fmt.Sprintf("delete %d keys under %s with prefix %s?", n, env.args[0], env.formatBytes(prefix))
+			if err := confirm(env, prompt); err != nil {
+				return err
+			}
+		}
+
+		// The keys that would be deleted have already been reported.
+		if env.dryRun {
+			return nil
+		}
+		deleted, err := bish.DeletePrefix(prefix)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(env.outIO, "%d keys removed\n", deleted)
+		return nil
+	})
+}
+
+func clearBucket(env *commandEnvironment) error {
+	force := false
+	if len(env.args) >= 1 && (env.args[0] == "-f" || env.args[0] == "--force") {
+		force = true
+		env.args = env.args[1:]
+	}
+
+	if len(env.args) != 1 {
+		return ErrUsage
+	}
+
+	return resolveBoltURI(env, env.args[0], true, func(loc *bolt.Location) error {
+		something := loc.ResolveHere()
+		var bish bolt.Bucketish
+
+		if rb, ok := something.(*bolt.Tx); ok && rb != nil {
+			bish = rb
+		} else if b, ok := something.(*bolt.Bucket); ok && b != nil {
+			bish = b
+		} else if v, ok := something.([]byte); ok && v != nil {
+			return ErrKeyIsNotBucket
+		} else {
+			return ErrKeyNotFound
+		}
+
+		if !force {
+			n, err := countKeysOfNode(bish, true, false)
+			if err != nil {
+				return err
+			}
+			prompt := fmt.Sprintf("delete all %d keys in %s?", n, env.args[0])
+			if err := confirm(env, prompt); err != nil {
+				return err
+			}
+		}
+		if env.dryRun {
+			walkSubtreeURIs(bish, env.args[0], func(uri string, isBucket bool) {
+				env.reportWrite("delete %s", uri)
+			})
+		}
+		return bish.Clear()
+	})
+}
+
+// confirm asks the user a yes/no question on the terminal, returning nil only
+// if they answer yes. It refuses to prompt when stdin is not a terminal.
+func confirm(env *commandEnvironment, prompt string) error {
+	if !isTerminal(env.inIO) {
+		return ErrConfirmationRequired
+	}
+
+	fmt.Fprintf(env.errIO, "%s [y/N] ", prompt)
+
+	answer, err := bufio.NewReader(env.inIO).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return err
+	}
+
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return nil
+	default:
+		return ErrAborted
+	}
+}
+
+// isTerminal reports whether r is a terminal.
+func isTerminal(r io.Reader) bool {
+	f, ok := r.(*os.File)
+	if !ok {
+		return false
+	}
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+func bucketIsEmpty(b *bolt.Bucket) bool {
+	err := b.ForEach(func(k, v []byte) error {
+		return ErrKeyFound
+	})
+
+	return err == nil
+}
+
+func copyKeyWithFile(env *commandEnvironment) error {
+	recurse := false
+	noClobber := false
+	for len(env.args) >= 1 && strings.HasPrefix(env.args[0], "-") {
+		switch env.args[0] {
+		case "-r", "--recurse":
+			recurse = true
+		case "-n", "--no-clobber":
+			noClobber = true
+		default:
+			return ErrUsage
+		}
+		env.args = env.args[1:]
+	}
+
+	if len(env.args) != 2 {
+		return ErrUsage
+	}
+
+	srcIsBolt := isBoltURI(env.args[0])
+	destIsBolt := isBoltURI(env.args[1])
+
+	if srcIsBolt && destIsBolt {
+		srcAlias, srcPath, err := env.parseBoltURI(env.args[0])
+		if err != nil {
+			return err
+		}
+		destAlias, destPath, err := env.parseBoltURI(env.args[1])
+		if err != nil {
+			return err
+		}
+
+		sameDB := srcAlias == destAlias
+		if sameDB && keyPathHasPrefix(destPath, srcPath) {
+			if len(destPath) == len(srcPath) {
+				return nil
+			}
+			if recurse {
+				return ErrDestIsDescendant
+			}
+		}
+
+		// When both URIs refer to the same mounted database, the source must be
+		// resolved within the writable transaction used for the destination.
+		return resolveBoltURI(env, env.args[0], sameDB, func(srcLoc *bolt.Location) error {
+			return resolveBoltURI(env, env.args[1], true, func(destLoc *bolt.Location) error {
+				something := srcLoc.ResolveHere()
+
+				if rb, ok := something.(*bolt.Tx); ok && rb != nil && recurse {
+					return copyBucketContentsTo(env, rb, destLoc, env.args[1], noClobber)
+				} else if b, ok := something.(*bolt.Bucket); ok && b != nil && recurse {
+					return copyBucketContentsTo(env, b, destLoc, env.args[1], noClobber)
+				} else if v, ok := something.([]byte); ok && v != nil {
+					if noClobber && destLoc.ResolveHere() != nil {
+						return nil
+					}
+					env.reportWrite("put %s", env.args[1])
+					return putAt(destLoc, v)
+				} else if something != nil {
+					return ErrKeyIsBucket
+				} else {
+					return ErrKeyNotFound
+				}
+			})
+		})
+	} else if !destIsBolt {
+		return resolveBoltURI(env, env.args[0], false, func(loc *bolt.Location) error {
+			v := loc.GetHere()
+			if v == nil {
+				return ErrKeyNotFound
+			}
+
+			if env.dryRun {
+				env.reportWrite("write %s", env.args[1])
+				return nil
+			}
+			return ioutil.WriteFile(env.args[1], v, 0644)
+		})
+	} else if !srcIsBolt {
+		return resolveBoltURI(env, env.args[1], true, func(loc *bolt.Location) error {
+			if noClobber && loc.ResolveHere() != nil {
+				return nil
+			}
+
+			v, err := ioutil.ReadFile(env.args[0])
+			if err != nil {
+				return err
+			}
+
+			env.reportWrite("put %s", env.args[1])
+			return putAt(loc, v)
+		})
+	} else {
+		return errors.New("at least one of src and dest must be a <bolt://...> URI")
+	}
+}
+
+func moveKey(env *commandEnvironment) error {
+	recurse := false
+	if len(env.args) >= 1 && (env.args[0] == "-r" || env.args[0] == "--recurse") {
+		recurse = true
+		env.args = env.args[1:]
+	}
+
+	if len(env.args) != 2 {
+		return ErrUsage
+	}
+
+	srcAlias, srcPath, err := env.parseBoltURI(env.args[0])
+	if err != nil {
+		return err
+	}
+	destAlias, destPath, err := env.parseBoltURI(env.args[1])
+	if err != nil {
+		return err
+	}
+
+	if len(srcPath) == 0 {
+		return ErrBucketIsRoot
+	}
+
+	if srcAlias == destAlias && keyPathHasPrefix(destPath, srcPath) {
+		if len(destPath) == len(srcPath) {
+			return nil
+		}
+		return ErrDestIsDescendant
+	}
+
+	// Both URIs are resolved for writing, so that when they refer to the same
+	// mounted database, the move happens within a single transaction.
+	return resolveBoltURI(env, env.args[0], true, func(srcLoc *bolt.Location) error {
+		return resolveBoltURI(env, env.args[1], true, func(destLoc *bolt.Location) error {
+			something := srcLoc.ResolveHere()
+
+			if rb, ok := something.(*bolt.Tx); ok && rb != nil {
+				return ErrBucketIsRoot
+			} else if b, ok := something.(*bolt.Bucket); ok && b != nil {
+				if !(bucketIsEmpty(b) || recurse) {
+					return ErrBucketNotEmpty
+				}
+				if env.dryRun {
+					env.reportWrite("move %s -> %s", env.args[0], env.args[1])
+					walkSubtreeURIs(b, "", func(relURI string, isBucket bool) {
+						env.reportWrite("move %s -> %s", strings.TrimSuffix(env.args[0], "/")+relURI, strings.TrimSuffix(env.args[1], "/")+relURI)
+					})
+				}
+				return srcLoc.MoveTo(destLoc)
+			} else if v, ok := something.([]byte); ok && v != nil {
+				env.reportWrite("move %s -> %s", env.args[0], env.args[1])
+				return srcLoc.MoveTo(destLoc)
+			} else {
+				return ErrKeyNotFound
+			}
+		})
+	})
+}
+
+// renameBucket renames the bucket at a URI within its parent, without copying
+// its contents.
+func renameBucket(env *commandEnvironment) error {
+	if len(env.args) != 2 {
+		return ErrUsage
+	}
+
+	newName, err := env.parseBytes(env.args[1])
+	if err != nil {
+		return err
+	} else if len(newName) == 0 {
+		return ErrKeyRequired
+	}
+
+	return resolveBoltURI(env, env.args[0], true, func(loc *bolt.Location) error {
+		if loc.Key() == nil {
+			return ErrBucketIsRoot
+		} else if loc.IsScalar() {
+			return ErrKeyIsNotBucket
+		} else if !loc.Exists() {
+			return ErrBucketNotFound
+		}
+
+		parent := loc.Parent()
+		if parent.Has(newName) || parent.Bucket(newName) != nil {
+			return ErrDestExists
+		}
+
+		env.reportWrite("rename %s -> %s", env.args[0], env.formatBytes(newName))
+		return parent.RenameBucket(loc.Key(), newName)
+	})
+}
+
+func keyPathHasPrefix(keyPath []string, prefix []string) bool {
+	if len(keyPath) < len(prefix) {
+		return false
+	}
+
+	for i := range prefix {
+		if keyPath[i] != prefix[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func copyBucketContentsTo(env *commandEnvironment, src bolt.Bucketish, destLoc *bolt.Location, destURI string, noClobber bool) error {
+	var dest bolt.Bucketish
+	if rb := destLoc.RootBucketHere(); rb != nil {
+		dest = rb
+	} else {
+		if destLoc.BucketHere() == nil {
+			env.reportWrite("mkdir %s", destURI)
+		}
+		b, err := destLoc.CreateBucketHereIfNotExists()
+		if err != nil {
+			return err
+		}
+		dest = b
+	}
+
+	return copyBucketContents(env, src, dest, destURI, noClobber)
+}
+
+func copyBucketContents(env *commandEnvironment, src bolt.Bucketish, dest bolt.Bucketish, destURI string, noClobber bool) error {
+	if srcBucket, ok := src.(*bolt.Bucket); ok {
+		destBucket, ok := dest.(*bolt.Bucket)
+		if !ok {
+			return bolt.ErrIncompatibleValue
+		}
+		if !noClobber || destBucket.Sequence() == 0 {
+			if err := destBucket.SetSequence(srcBucket.Sequence()); err != nil {
+				return err
+			}
+		}
+	}
+
+	return src.ForEach(func(k []byte, v []byte) error {
+		if v == nil {
+			destChild, err := dest.CreateBucketIfNotExists(k)
+			if err != nil {
+				if noClobber && err == bolt.ErrIncompatibleValue {
+					return nil
+				}
+				return err
+			}
+			env.reportWrite("mkdir %s", joinURI(destURI, k))
+			return copyBucketContents(env, src.Bucket(k), destChild, joinURI(destURI, k), noClobber)
+		}
+
+		destBucket, ok := dest.(*bolt.Bucket)
+		if !ok {
+			return bolt.ErrIncompatibleValue
+		}
+		if noClobber && (destBucket.Get(k) != nil || destBucket.Bucket(k) != nil) {
+			return nil
+		}
+		env.reportWrite("put %s", joinURI(destURI, k))
+		return destBucket.Put(k, v)
+	})
+}
+
+// reportWrite describes a write that a --dry-run invocation would perform.
+func (env *commandEnvironment) reportWrite(format string, args ...interface{}) {
+	if env.dryRun {
+		fmt.Fprintf(env.outIO, "would "+format+"\n", args...)
+	}
+}
+
+func joinURI(baseURI string, k []byte) string {
+	return strings.TrimSuffix(baseURI, "/") + "/" + url.PathEscape(string(k))
+}
+
+// walkSubtreeURIs calls fn with the URI of every key and bucket under bish,
+// built by appending their keys to baseURI.
+func walkSubtreeURIs(bish bolt.Bucketish, baseURI string, fn func(uri string, isBucket bool)) {
+	bish.ForEach(func(k []byte, v []byte) error {
+		uri := joinURI(baseURI, k)
+		if v == nil {
+			walkSubtreeURIs(bish.Bucket(k), uri, fn)
+		}
+		fn(uri, v == nil)
+		return nil
+	})
+}
+
+func listKeys(env *commandEnvironment) error {
+	var glob string
+	var prefix []byte
+	summary := false
+	var positional []string
+	for i := 0; i < len(env.args); i++ {
+		switch {
+		case env.args[i] == "--summary":
+			summary = true
+		case env.args[i] == "--glob" && i+1 < len(env.args):
+			glob = env.args[i+1]
+			if _, err := path.Match(glob, ""); err != nil {
+				return err
+			}
+			i++
+		case env.args[i] == "--prefix" && i+1 < len(env.args):
+			var err error
+			prefix, err = env.parseBytes(env.args[i+1])
+			if err != nil {
+				return err
+			}
+			i++
+		case strings.HasPrefix(env.args[i], "-"):
+			return ErrUsage
+		default:
+			positional = append(positional, env.args[i])
+		}
+	}
+	env.args = env.orCurrentBucket(positional)
+
+	if len(env.args) != 1 {
+		return ErrUsage
+	}
+
+	return resolveBoltURI(env, env.args[0], false, func(loc *bolt.Location) error {
+		something := loc.ResolveHere()
+		var listKeysOf bolt.Bucketish
+
+		if b, ok := something.(*bolt.Bucket); ok && b != nil {
+			env.printRecord("[is a bucket]")
+			listKeysOf = b
+		} else if rb, ok := something.(*bolt.Tx); ok && rb != nil {
+			env.printRecord("[is a root bucket]")
+			listKeysOf = rb
+		} else if v, ok := something.([]byte); ok && v != nil {
+			env.printRecord("[is a scalar value]")
+			return nil
+		} else {
+			return ErrKeyNotFound
+		}
+
+		var keyN, bucketN, valueBytes uint64
+		err := listKeysOf.ForEachPrefix(prefix, func(k []byte, v []byte) error {
+			if glob != "" && !keyMatchesGlob(k, glob) {
+				return nil
+			}
+
+			if v == nil {
+				bucketN++
+			} else {
+				keyN++
+				valueBytes += uint64(len(v))
+			}
+			printListEntry(env, k, v)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		if summary {
+			env.printRecord("total = %d keys, %d buckets, %s", keyN, bucketN, formatByteSize(valueBytes))
+		}
+		return nil
+	})
+}
+
+// listKeyNames prints the keys of a bucket, nested buckets included, one per
+// line and without values, for use in scripts. -0 is a shorthand for the
+// global --print0 flag.
+func listKeyNames(env *commandEnvironment) error {
+	var glob string
+	var prefix []byte
+	var positional []string
+	for i := 0; i < len(env.args); i++ {
+		switch {
+		case env.args[i] == "-0":
+			env.print0 = true
+		case env.args[i] == "--glob" && i+1 < len(env.args):
+			glob = env.args[i+1]
+			if _, err := path.Match(glob, ""); err != nil {
+				return err
+			}
+			i++
+		case env.args[i] == "--prefix" && i+1 < len(env.args):
+			var err error
+			prefix, err = env.parseBytes(env.args[i+1])
+			if err != nil {
+				return err
+			}
+			i++
+		case strings.HasPrefix(env.args[i], "-"):
+			return ErrUsage
+		default:
+			positional = append(positional, env.args[i])
+		}
+	}
+	env.args = env.orCurrentBucket(positional)
+
+	if len(env.args) != 1 {
+		return ErrUsage
+	}
+
+	return resolveBoltURI(env, env.args[0], false, func(loc *bolt.Location) error {
+		bish, err := bucketishAt(loc)
+		if err != nil {
+			return err
+		}
+
+		return bish.ForEachPrefix(prefix, func(k []byte, _ []byte) error {
+			if glob == "" || keyMatchesGlob(k, glob) {
+				env.printRecord("%s", env.formatField(k))
+			}
+			return nil
+		})
+	})
+}
+
+// printListEntry prints a key and its value, or marks it as a bucket, the
+// way ls lists them. Long values are replaced by their size.
+func printListEntry(env *commandEnvironment, k []byte, v []byte) {
+	if v == nil {
+		env.printRecord("%s (bucket)", env.formatField(k))
+	} else if len(v) < 50 {
+		env.printRecord("%s = %s", env.formatField(k), env.formatField(v))
+	} else {
+		env.printRecord("%s = <%d bytes>", env.formatField(k), len(v))
+	}
+}
+
+// errRangeLimit stops a range scan once --limit entries have been printed.
+var errRangeLimit = errors.New("range limit reached")
+
+// listKeyRange prints the keys of a bucket within [start, end), seeking to
+// the bounds with a cursor rather than scanning the whole bucket. An empty
+// bound leaves that end of the range open.
+func listKeyRange(env *commandEnvironment) error {
+	reverse := false
+	limit := -1
+	var positional []string
+	for i := 0; i < len(env.args); i++ {
+		switch {
+		case env.args[i] == "--reverse":
+			reverse = true
+		case env.args[i] == "--limit" && i+1 < len(env.args):
+			n, err := strconv.Atoi(env.args[i+1])
+			if err != nil || n < 0 {
+				return fmt.Errorf("invalid limit: %q", env.args[i+1])
+			}
+			limit = n
+			i++
+		case strings.HasPrefix(env.args[i], "-"):
+			return ErrUsage
+		default:
+			positional = append(positional, env.args[i])
+		}
+	}
+	if len(positional) != 3 {
+		return ErrUsage
+	}
+
+	var bounds [2][]byte
+	for i, arg := range positional[1:] {
+		if arg == "" {
+			continue
+		}
+		b, err := env.parseBytes(arg)
+		if err != nil {
+			return err
+		}
+		bounds[i] = b
+	}
+	start, end := bounds[0], bounds[1]
+
+	return resolveBoltURI(env, positional[0], false, func(loc *bolt.Location) error {
+		bish, err := bucketishAt(loc)
+		if err != nil {
+			return err
+		}
+
+		n := 0
+		emit := func(k []byte, v []byte) error {
+			if n == limit {
+				return errRangeLimit
+			}
+			printListEntry(env, k, v)
+			n++
+			return nil
+		}
+
+		if !reverse {
+			err = bish.ForEachRange(start, end, emit)
+		} else {
+			err = forEachRangeReverse(bish.Cursor(), start, end, emit)
+		}
+		if err == errRangeLimit {
+			err = nil
+		}
+		return err
+	})
+}
+
+// forEachRangeReverse calls fn for each key within [start, end) in descending
+// order. A nil start or end leaves that end of the range open.
+func forEachRangeReverse(c *bolt.Cursor, start, end []byte, fn func(k, v []byte) error) error {
+	var k, v []byte
+	if end == nil {
+		k, v = c.Last()
+	} else if k, v = c.Seek(end); k == nil {
+		k, v = c.Last()
+	} else {
+		k, v = c.Prev()
+	}
+
+	for ; k != nil && (start == nil || bytes.Compare(k, start) >= 0); k, v = c.Prev() {
+		if err := fn(k, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// listKeyEnd prints the first N keys of a bucket, or with fromEnd the last
+// N in descending order, the way ls lists them. N defaults to 10.
+func listKeyEnd(env *commandEnvironment, fromEnd bool) error {
+	limit := 10
+	var positional []string
+	for i := 0; i < len(env.args); i++ {
+		switch {
+		case env.args[i] == "-n" && i+1 < len(env.args):
+			n, err := strconv.Atoi(env.args[i+1])
+			if err != nil || n < 0 {
+				return fmt.Errorf("invalid count: %q", env.args[i+1])
+			}
+			limit = n
+			i++
+		case strings.HasPrefix(env.args[i], "-"):
+			return ErrUsage
+		default:
+			positional = append(positional, env.args[i])
+		}
+	}
+	env.args = env.orCurrentBucket(positional)
+
+	if len(env.args) != 1 {
+		return ErrUsage
+	}
+
+	return resolveBoltURI(env, env.args[0], false, func(loc *bolt.Location) error {
+		bish, err := bucketishAt(loc)
+		if err != nil {
+			return err
+		}
+
+		c := bish.Cursor()
+		first, next := c.First, c.Next
+		if fromEnd {
+			first, next = c.Last, c.Prev
+		}
+		n := 0
+		for k, v := first(); k != nil && n < limit; k, v = next() {
+			printListEntry(env, k, v)
+			n++
+		}
+		return nil
+	})
+}
+
+// keyMatchesGlob reports whether the UTF-8 interpretation of k matches the
+// shell-style pattern glob. Keys that are not valid UTF-8 never match.
+func keyMatchesGlob(k []byte, glob string) bool {
+	if !utf8.Valid(k) {
+		return false
+	}
+
+	matched, _ := path.Match(glob, string(k))
+	return matched
+}
+
+// grepSubtree prints the URI of every key in a bucket whose value, or key
+// with --keys, matches a regular expression.
+func grepSubtree(env *commandEnvironment) error {
+	recurse := false
+	matchKeys := false
+	countOnly := false
+	var positional []string
+	for _, arg := range env.args {
+		switch arg {
+		case "-r", "--recurse":
+			recurse = true
+		case "--keys":
+			matchKeys = true
+		case "-c", "--count":
+			countOnly = true
+		default:
+			if strings.HasPrefix(arg, "-") {
+				return ErrUsage
+			}
+			positional = append(positional, arg)
+		}
+	}
+	if len(positional) == 1 && env.cwd != "" {
+		positional = append(positional, ".")
+	}
+	if len(positional) != 2 {
+		return ErrUsage
+	}
+
+	re, err := regexp.Compile(positional[0])
+	if err != nil {
+		return err
+	}
+
+	return resolveBoltURI(env, positional[1], false, func(loc *bolt.Location) error {
+		bish, err := bucketishAt(loc)
+		if err != nil {
+			return err
+		}
+
+		var n uint64
+		var grep func(bish bolt.Bucketish, baseURI string) error
+		grep = func(bish bolt.Bucketish, baseURI string) error {
+			return bish.ForEach(func(k []byte, v []byte) error {
+				uri := joinURI(baseURI, k)
+				if v == nil {
+					if recurse {
+						return grep(bish.Bucket(k), uri)
+					}
+					return nil
+				}
+
+				subject := v
+				if matchKeys {
+					subject = k
+				}
+				if !re.MatchString(env.formatBytes(subject)) {
+					return nil
+				}
+
+				n++
+				if !countOnly {
+					env.printRecord("%s", env.quoteField(uri))
+				}
+				return nil
+			})
+		}
+		if err := grep(bish, positional[1]); err != nil {
+			return err
+		}
+
+		if countOnly {
+			env.printRecord("%d", n)
+		}
+		return nil
+	})
+}
+
+// findKey prints the URI of every scalar key anywhere in a database that is
+// named key, optionally only those whose value matches --value. With
+// --buckets it looks for nested buckets named key instead.
+func findKey(env *commandEnvironment) error {
+	var re *regexp.Regexp
+	findBuckets := false
+	var positional []string
+	for i := 0; i < len(env.args); i++ {
+		switch {
+		case env.args[i] == "--buckets":
+			findBuckets = true
+		case env.args[i] == "--value" && i+1 < len(env.args):
+			var err error
+			if re, err = regexp.Compile(env.args[i+1]); err != nil {
+				return err
+			}
+			i++
+		case strings.HasPrefix(env.args[i], "-"):
+			return ErrUsage
+		default:
+			positional = append(positional, env.args[i])
+		}
+	}
+	if len(positional) != 2 || (findBuckets && re != nil) {
+		return ErrUsage
+	}
+
+	key, err := env.parseBytes(positional[1])
+	if err != nil {
+		return err
+	}
+
+	baseURI := "bolt://" + positional[0]
+	return resolveBoltURI(env, baseURI, false, func(loc *bolt.Location) error {
+		bish, err := bucketishAt(loc)
+		if err != nil {
+			return err
+		}
+
+		var find func(bish bolt.Bucketish, baseURI string) error
+		find = func(bish bolt.Bucketish, baseURI string) error {
+			return bish.ForEach(func(k []byte, v []byte) error {
+				uri := joinURI(baseURI, k)
+				if v == nil {
+					if findBuckets && bytes.Equal(k, key) {
+						env.printRecord("%s", env.quoteField(uri))
+					}
+					return find(bish.Bucket(k), uri)
+				}
+
+				if !findBuckets && bytes.Equal(k, key) && (re == nil || re.MatchString(env.formatBytes(v))) {
+					env.printRecord("%s", env.quoteField(uri))
+				}
+				return nil
+			})
+		}
+		return find(bish, baseURI)
+	})
+}
+
+func diffBuckets(env *commandEnvironment) error {
+	recurse := false
+	if len(env.args) >= 1 && (env.args[0] == "-r" || env.args[0] == "--recurse") {
+		recurse = true
+		env.args = env.args[1:]
+	}
+
+	if len(env.args) != 2 {
+		return ErrUsage
+	}
+
+	aAlias, aPath, err := env.parseBoltURI(env.args[0])
+	if err != nil {
+		return err
+	}
+	bAlias, bPath, err := env.parseBoltURI(env.args[1])
+	if err != nil {
+		return err
+	}
+	if aAlias == bAlias && strings.Join(aPath, "/") == strings.Join(bPath, "/") {
+		return nil
+	}
+
+	return resolveBoltURI(env, env.args[0], false, func(aLoc *bolt.Location) error {
+		return resolveBoltURI(env, env.args[1], false, func(bLoc *bolt.Location) error {
+			a, err := bucketishAt(aLoc)
+			if err != nil {
+				return err
+			}
+			b, err := bucketishAt(bLoc)
+			if err != nil {
+				return err
+			}
+
+			return printBucketDiff(env, a, b, "", recurse)
+		})
+	})
+}
+
+// bucketishAt returns the bucket or root bucket at loc.
+func bucketishAt(loc *bolt.Location) (bolt.Bucketish, error) {
+	if rb := loc.RootBucketHere(); rb != nil {
+		return rb, nil
+	} else if b := loc.BucketHere(); b != nil {
+		return b, nil
+	}
+	return nil, ErrBucketNotFound
+}
+
+// printBucketDiff prints a line for every key that differs between a and b,
+// prefixed with '-' for the side of a and '+' for the side of b. Nested
+// buckets present on both sides are only compared when recurse is set.
+func printBucketDiff(env *commandEnvironment, a bolt.Bucketish, b bolt.Bucketish, prefix string, recurse bool) error {
+	return bolt.DiffBuckets(a, b, func(k []byte, change bolt.ChangeKind, av []byte, bv []byte) error {
+		name := prefix + env.formatBytes(k)
+
+		if change == bolt.ChangeBucketBoth {
+			if !recurse {
+				return nil
+			}
+			return printBucketDiff(env, a.Bucket(k), b.Bucket(k), name+"/", recurse)
+		}
+
+		if change == bolt.ChangeBucketRemoved {
+			fmt.Fprintf(env.outIO, "- %s/\n", name)
+		} else if av != nil {
+			fmt.Fprintf(env.outIO, "- %s = %s\n", name, env.formatBytes(av))
+		}
+		if change == bolt.ChangeBucketAdded {
+			fmt.Fprintf(env.outIO, "+ %s/\n", name)
+		} else if bv != nil {
+			fmt.Fprintf(env.outIO, "+ %s = %s\n", name, env.formatBytes(bv))
+		}
+		return nil
+	})
+}
+
+// watchSnapshotBucket is the bucket that holds the previous poll's copy of a
+// watched bucket.
+var watchSnapshotBucket = []byte("snapshot")
+
+func watchBucket(env *commandEnvironment) error {
+	recurse := false
+	interval := time.Second
+	var positional []string
+	for i := 0; i < len(env.args); i++ {
+		switch {
+		case env.args[i] == "-r" || env.args[i] == "--recurse":
+			recurse = true
+		case env.args[i] == "--interval" && i+1 < len(env.args):
+			var err error
+			interval, err = time.ParseDuration(env.args[i+1])
+			if err != nil {
+				return err
+			} else if interval <= 0 {
+				return ErrUsage
+			}
+			i++
+		case strings.HasPrefix(env.args[i], "-"):
+			return ErrUsage
+		default:
+			positional = append(positional, env.args[i])
+		}
+	}
+	env.args = env.orCurrentBucket(positional)
+
+	if len(env.args) != 1 {
+		return ErrUsage
+	}
+
+	mountAlias, keyPath, err := env.parseBoltURI(env.args[0])
+	if err != nil {
+		return err
+	}
+	path, ok := env.mounts[mountAlias]
+	if !ok {
+		return ErrAliasNotFound
+	}
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return ErrFileNotFound
+	}
+
+	// Each poll is compared against a copy of the previous one, kept in a
+	// scratch database so that it need not fit in memory.
+	tmpFile, err := ioutil.TempFile("", "boltutil-watch")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmpFile.Name()
+	tmpFile.Close()
+	defer os.Remove(tmpPath)
+
+	snapshot, err := bolt.Open(tmpPath, 0600, nil)
+	if err != nil {
+		return err
+	}
+	defer snapshot.Close()
+	snapshot.NoSync = true
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	defer signal.Stop(interrupt)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for first := true; ; first = false {
+		if err := pollWatchedBucket(env, path, keyPath, snapshot, interval, first, recurse); err != nil {
+			return err
+		}
+
+		select {
+		case <-interrupt:
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// pollWatchedBucket prints the differences between the bucket at keyPath and
+// its copy in snapshot, then replaces the copy. Nothing is printed on the
+// first poll. A poll is skipped if another process holds the database's lock
+// for longer than interval.
+func pollWatchedBucket(env *commandEnvironment, path string, keyPath []string, snapshot *bolt.DB, interval time.Duration, first bool, recurse bool) error {
+	db, closeDB, err := env.openDB(path, 0444, &bolt.Options{ReadOnly: true, Timeout: interval})
+	if err == ErrDatabaseLocked {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer closeDB()
+
+	return db.View(func(tx *bolt.Tx) error {
+		loc, err := navigateToLocation(tx, keyPath)
+		if err != nil {
+			return err
+		}
+		current, err := bucketishAt(loc)
+		if err != nil {
+			return err
+		}
+
+		return snapshot.Update(func(stx *bolt.Tx) error {
+			if !first {
+				previous := stx.Bucket(watchSnapshotBucket)
+				if err := printBucketDiff(env, previous, current, "", recurse); err != nil {
+					return err
+				}
+				if err := stx.DeleteBucket(watchSnapshotBucket); err != nil {
+					return err
+				}
+			}
+
+			if _, err := stx.CreateBucket(watchSnapshotBucket); err != nil {
+				return err
+			}
+			return loc.CopyTo(bolt.NewLocation(stx, watchSnapshotBucket))
+		})
+	})
+}
+
+func printBucketTree(env *commandEnvironment) (err error) {
+	maxDepth := int64(-1)
+	format := "plain"
+	var positional []string
+	for i := 0; i < len(env.args); i++ {
+		switch {
+		case (env.args[i] == "-d" || env.args[i] == "--max-depth") && i+1 < len(env.args):
+			maxDepth, err = strconv.ParseInt(env.args[i+1], 10, 64)
+			if err != nil {
+				return err
+			}
+			i++
+		case env.args[i] == "--format" && i+1 < len(env.args):
+			format = env.args[i+1]
+			i++
+		case strings.HasPrefix(env.args[i], "-"):
+			return ErrUsage
+		default:
+			positional = append(positional, env.args[i])
+		}
+	}
+	env.args = env.orCurrentBucket(positional)
+
+	if len(env.args) != 1 || (format != "plain" && format != "json" && format != "yaml") {
+		return ErrUsage
+	}
+
+	return resolveBoltURI(env, env.args[0], false, func(loc *bolt.Location) error {
+		something := loc.ResolveHere()
+		var bish bolt.Bucketish
+
+		if b, ok := something.(*bolt.Bucket); ok && b != nil {
+			bish = b
+		} else if rb, ok := something.(*bolt.Tx); ok && rb != nil {
+			bish = rb
+		} else {
+			return ErrBucketNotFound
+		}
+
+		switch format {
+		case "json":
+			enc := json.NewEncoder(env.outIO)
+			enc.SetIndent("", "  ")
+			return enc.Encode(exportBucketTreeNode(bish, 0, maxDepth, env.formatBytes))
+		case "yaml":
+			printYAMLNode(env.outIO, exportBucketTreeNode(bish, 0, maxDepth, env.formatBytes), 0)
+		default:
+			printBucketTreeNode(env, bish, 0, maxDepth)
+		}
+
+		return nil
+	})
+}
+
+// printYAMLNode writes a tree built by exportBucketTreeNode as a YAML
+// mapping, with keys in the same order encoding/json would use.
+func printYAMLNode(w io.Writer, node map[string]interface{}, atDepth int) {
+	indentStr := strings.Repeat(" ", atDepth*2)
+	if len(node) == 0 && atDepth == 0 {
+		fmt.Fprintln(w, "{}")
+		return
+	}
+
+	keys := make([]string, 0, len(node))
+	for k := range node {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		switch v := node[k].(type) {
+		case map[string]interface{}:
+			if len(v) == 0 {
+				fmt.Fprintf(w, "%s%s: {}\n", indentStr, strconv.Quote(k))
+				continue
+			}
+			fmt.Fprintf(w, "%s%s:\n", indentStr, strconv.Quote(k))
+			printYAMLNode(w, v, atDepth+1)
+		case string:
+			fmt.Fprintf(w, "%s%s: %s\n", indentStr, strconv.Quote(k), strconv.Quote(v))
+		}
+	}
+}
+
+func printBucketTreeNode(env *commandEnvironment, bish bolt.Bucketish, atDepth int64, maxDepth int64) {
+	if atDepth == maxDepth {
+		return
+	}
+
+	indentStr := strings.Repeat(" ", int(atDepth*2))
+
+	bish.ForEach(func(k []byte, v []byte) error {
+		if v == nil {
+			fmt.Fprintf(env.outIO, "%s%s/\n", indentStr, env.formatBytes(k))
+			printBucketTreeNode(env, bish.Bucket(k), atDepth+1, maxDepth)
+		} else {
+			fmt.Fprintf(env.outIO, "%s%s\n", indentStr, env.formatBytes(k))
+		}
+		return nil
+	})
+}
+
+func diskUsage(env *commandEnvironment) (err error) {
+	maxDepth := int64(-1)
+	breakdown := false
+	sortBySize := false
+	for len(env.args) >= 1 && strings.HasPrefix(env.args[0], "-") {
+		switch {
+		case env.args[0] == "--breakdown":
+			breakdown = true
+			env.args = env.args[1:]
+		case len(env.args) >= 2 && env.args[0] == "--sort":
+			if env.args[1] != "size" {
+				return ErrUsage
+			}
+			sortBySize = true
+			env.args = env.args[2:]
+		case len(env.args) >= 2 && (env.args[0] == "-d" || env.args[0] == "--max-depth"):
+			maxDepth, err = strconv.ParseInt(env.args[1], 10, 64)
+			if err != nil {
+				return err
+			}
+			env.args = env.args[2:]
+		default:
+			return ErrUsage
+		}
+	}
+
+	env.args = env.orCurrentBucket(env.args)
+	if len(env.args) != 1 {
+		return ErrUsage
+	}
+
+	return resolveBoltURI(env, env.args[0], false, func(loc *bolt.Location) error {
+		something := loc.ResolveHere()
+		var bish bolt.Bucketish
+
+		if b, ok := something.(*bolt.Bucket); ok && b != nil {
+			bish = b
+		} else if rb, ok := something.(*bolt.Tx); ok && rb != nil {
+			bish = rb
+		} else {
+			return ErrBucketNotFound
+		}
+
+		if sortBySize {
+			return printDiskUsageBySize(env, bish, breakdown)
+		}
+
+		printDiskUsageOfNode(env, bish, 0, maxDepth, breakdown)
+
+		return nil
+	})
+}
+
+// printDiskUsageBySize prints the direct sub-buckets of bish largest-first,
+// followed by their total.
+func printDiskUsageBySize(env *commandEnvironment, bish bolt.Bucketish, breakdown bool) error {
+	type bucketSize struct {
+		key  []byte
+		b    *bolt.Bucket
+		size uint64
+	}
+
+	var sizes []bucketSize
+	if err := bish.ForEach(func(k []byte, v []byte) error {
+		if v == nil {
+			sb := bish.Bucket(k)
+			sizes = append(sizes, bucketSize{key: k, b: sb, size: sb.StandaloneSize()})
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	sort.SliceStable(sizes, func(i, j int) bool {
+		return sizes[i].size > sizes[j].size
+	})
+
+	var total uint64
+	for _, sz := range sizes {
+		fmt.Fprintln(env.outIO, formatDiskUsageLine(env, "", sz.key, sz.b, breakdown))
+		total += sz.size
+	}
+	fmt.Fprintf(env.outIO, "total = %s\n", formatByteSize(total))
+
+	return nil
+}
+
+func printDiskUsageOfNode(env *commandEnvironment, bish bolt.Bucketish, atDepth int64, maxDepth int64, breakdown bool) {
+	if atDepth == maxDepth {
+		return
+	}
+
+	indentStr := strings.Repeat(" ", int(atDepth*2))
+
+	bish.ForEach(func(k []byte, v []byte) error {
+		if v == nil {
+			sb := bish.Bucket(k)
+			fmt.Fprintln(env.outIO, formatDiskUsageLine(env, indentStr, k, sb, breakdown))
+			printDiskUsageOfNode(env, sb, atDepth+1, maxDepth, breakdown)
+		}
+		return nil
+	})
+}
+
+func formatDiskUsageLine(env *commandEnvironment, indentStr string, k []byte, b *bolt.Bucket, breakdown bool) string {
+	if !breakdown {
+		return fmt.Sprintf("%s%s = %s", indentStr, env.formatBytes(k), formatByteSize(b.StandaloneSize()))
+	}
+
+	sz := b.SizeBreakdown()
+	return fmt.Sprintf("%s%s = %s (leaf %s, branch %s, inline %s, data %s)", indentStr, env.formatBytes(k),
+		formatByteSize(b.StandaloneSize()), formatByteSize(sz.LeafBytes), formatByteSize(sz.BranchBytes),
+		formatByteSize(sz.InlineBucketBytes), formatByteSize(sz.KeyValueBytes))
+}
+
+// formatField renders b like formatBytes, shell-quoted if --quote is set.
+func (env *commandEnvironment) formatField(b []byte) string {
+	return env.quoteField(env.formatBytes(b))
+}
+
+// quoteField shell-quotes s if --quote is set.
+func (env *commandEnvironment) quoteField(s string) string {
+	if !env.quote {
+		return s
+	}
+	return shellQuote(s)
+}
+
+// printRecord prints a line of output, or a NUL-terminated record if
+// --print0 is set.
+func (env *commandEnvironment) printRecord(format string, args ...interface{}) {
+	fmt.Fprintf(env.outIO, format, args...)
+	if env.print0 {
+		fmt.Fprint(env.outIO, "\x00")
+	} else {
+		fmt.Fprintln(env.outIO)
+	}
+}
+
+// shellQuote quotes s for a POSIX shell. s is returned as is if it only holds
+// characters that need no quoting, and wrapped in single quotes if it is
+// printable UTF-8. Anything else is written as a $'...' string, escaping
+// non-printable runes and invalid UTF-8 byte by byte.
+func shellQuote(s string) string {
+	if s != "" && strings.IndexFunc(s, func(r rune) bool {
+		return !(r < utf8.RuneSelf && (unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("@%+=:,./_-", r)))
+	}) < 0 {
+		return s
+	}
+
+	if utf8.ValidString(s) && strings.IndexFunc(s, func(r rune) bool { return !unicode.IsPrint(r) }) < 0 {
+		return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+	}
+
+	var b strings.Builder
+	b.WriteString("$'")
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		switch {
+		case r == '\'' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case (r != utf8.RuneError || size > 1) && unicode.IsPrint(r):
+			b.WriteString(s[i : i+size])
+		default:
+			for j := i; j < i+size; j++ {
+				fmt.Fprintf(&b, `\x%02x`, s[j])
+			}
+		}
+		i += size
+	}
+	b.WriteString("'")
+	return b.String()
+}
+
+// formatBytes renders a key or value according to the --encoding flag.
+func (env *commandEnvironment) formatBytes(b []byte) string {
+	switch env.encoding {
+	case "utf8":
+		return string(b)
+	case "base64":
+		return base64.StdEncoding.EncodeToString(b)
+	case "auto":
+		if utf8.Valid(b) {
+			return string(b)
+		}
+		return fmt.Sprintf("%#x", b)
+	default:
+		return fmt.Sprintf("%#x", b)
+	}
+}
+
+// parseBytes interprets a key or value given on the command line according
+// to the --encoding flag. Hex input may carry a leading "0x"; with 'auto',
+// "0x"-prefixed input is read as hex and anything else as UTF-8.
+func (env *commandEnvironment) parseBytes(s string) ([]byte, error) {
+	switch env.encoding {
+	case "utf8":
+		return []byte(s), nil
+	case "base64":
+		return base64.StdEncoding.DecodeString(s)
+	case "auto":
+		if strings.HasPrefix(s, "0x") {
+			return hex.DecodeString(s[2:])
+		}
+		return []byte(s), nil
+	default:
+		return hex.DecodeString(strings.TrimPrefix(s, "0x"))
+	}
+}
+
+func formatByteSize(size uint64) string {
+	switch getExp(size) {
+	case 0:
+		return "0"
+	case 1:
+		return fmt.Sprintf("%db", size)
+	case 2:
+		return fmt.Sprintf("%.1fK", float64(size)/1024)
+	case 3:
+		return fmt.Sprintf("%.1fM", float64(size)/(1024*1024))
+	case 4:
+		return fmt.Sprintf("%.1fG", float64(size)/(1024*1024*1024))
+	default:
+		return fmt.Sprintf("%.1fT", float64(size)/(1024*1024*1024*1024))
+	}
+}
+
+func getExp(size uint64) (exp int) {
+	for size > 0 {
+		exp += 1
+		size /= 1024
+	}
+	return
+}
+
+func countKeys(env *commandEnvironment) error {
+	recurse := false
+	countBuckets := false
+	for len(env.args) >= 1 && strings.HasPrefix(env.args[0], "-") {
+		switch env.args[0] {
+		case "-r", "--recurse":
+			recurse = true
+		case "--buckets":
+			countBuckets = true
+		default:
+			return ErrUsage
+		}
+		env.args = env.args[1:]
+	}
+
+	env.args = env.orCurrentBucket(env.args)
+	if len(env.args) != 1 {
+		return ErrUsage
+	}
+
+	return resolveBoltURI(env, env.args[0], false, func(loc *bolt.Location) error {
+		something := loc.ResolveHere()
+		var bish bolt.Bucketish
+
+		if b, ok := something.(*bolt.Bucket); ok && b != nil {
+			bish = b
+		} else if rb, ok := something.(*bolt.Tx); ok && rb != nil {
+			bish = rb
+		} else {
+			return ErrBucketNotFound
+		}
+
+		n, err := countKeysOfNode(bish, recurse, countBuckets)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(env.outIO, "%d\n", n)
+		return nil
+	})
+}
+
+func countKeysOfNode(bish bolt.Bucketish, recurse bool, countBuckets bool) (n uint64, err error) {
+	err = bish.ForEach(func(k []byte, v []byte) error {
+		if (v == nil) == countBuckets {
+			n++
+		}
+		if v == nil && recurse {
+			subN, err := countKeysOfNode(bish.Bucket(k), recurse, countBuckets)
+			if err != nil {
+				return err
+			}
+			n += subN
+		}
+		return nil
+	})
+	return
+}
+
+func printStats(env *commandEnvironment) error {
+	env.args = env.orCurrentBucket(env.args)
+
+	if len(env.args) != 1 {
+		return ErrUsage
+	}
+
+	return resolveBoltURI(env, env.args[0], false, func(loc *bolt.Location) error {
+		bish, err := bucketishAt(loc)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(env.outIO, 0, 8, 2, ' ', 0)
+
+		// The root reports the totals of its top-level buckets.
+		s := bish.BucketStats()
+		fmt.Fprintf(w, "Branch pages:\t%d\n", s.BranchPageN)
+		fmt.Fprintf(w, "Branch overflow pages:\t%d\n", s.BranchOverflowN)
+		fmt.Fprintf(w, "Leaf pages:\t%d\n", s.LeafPageN)
+		fmt.Fprintf(w, "Leaf overflow pages:\t%d\n", s.LeafOverflowN)
+		fmt.Fprintf(w, "Keys:\t%d\n", s.KeyN)
+		fmt.Fprintf(w, "Depth:\t%d\n", s.Depth)
+		fmt.Fprintf(w, "Branch bytes allocated/in use:\t%d/%d\n", s.BranchAlloc, s.BranchInuse)
+		fmt.Fprintf(w, "Leaf bytes allocated/in use:\t%d/%d\n", s.LeafAlloc, s.LeafInuse)
+		fmt.Fprintf(w, "Buckets:\t%d\n", s.BucketN)
+		fmt.Fprintf(w, "Inlined buckets:\t%d\n", s.InlineBucketN)
+		fmt.Fprintf(w, "Inlined bucket bytes in use:\t%d\n", s.InlineBucketInuse)
+
+		if rb, ok := bish.(*bolt.Tx); ok {
+			ts := rb.Stats()
+			fmt.Fprintf(w, "Page allocations:\t%d\n", ts.PageCount)
+			fmt.Fprintf(w, "Page bytes allocated:\t%d\n", ts.PageAlloc)
+			fmt.Fprintf(w, "Cursors created:\t%d\n", ts.CursorCount)
+			fmt.Fprintf(w, "Node allocations:\t%d\n", ts.NodeCount)
+			fmt.Fprintf(w, "Node dereferences:\t%d\n", ts.NodeDeref)
+			fmt.Fprintf(w, "Rebalances:\t%d (%s)\n", ts.Rebalance, ts.RebalanceTime)
+			fmt.Fprintf(w, "Splits:\t%d\n", ts.Split)
+			fmt.Fprintf(w, "Spills:\t%d (%s)\n", ts.Spill, ts.SpillTime)
+			fmt.Fprintf(w, "Writes:\t%d (%s)\n", ts.Write, ts.WriteTime)
+
+			freeN, pendingN, reclaimable := rb.DB().FreelistStats()
+			fmt.Fprintf(w, "Free pages:\t%d\n", freeN)
+			fmt.Fprintf(w, "Pending pages:\t%d\n", pendingN)
+			fmt.Fprintf(w, "Reclaimable by compaction:\t%s\n", formatByteSize(reclaimable))
+		}
+
+		return w.Flush()
+	})
+}
+
+func bucketSequence(env *commandEnvironment) error {
+	if len(env.args) < 2 {
+		return ErrUsage
+	}
+
+	var seq uint64
+	wantWritableTx := false
+	switch {
+	case env.args[0] == "get" && len(env.args) == 2:
+	case env.args[0] == "set" && len(env.args) == 3:
+		var err error
+		seq, err = strconv.ParseUint(env.args[2], 10, 64)
+		if err != nil {
+			return err
+		}
+		wantWritableTx = true
+	default:
+		return ErrUsage
+	}
+
+	return resolveBoltURI(env, env.args[1], wantWritableTx, func(loc *bolt.Location) error {
+		something := loc.ResolveHere()
+
+		if rb, ok := something.(*bolt.Tx); ok && rb != nil {
+			return ErrBucketRequired
+		} else if v, ok := something.([]byte); ok && v != nil {
+			return ErrKeyIsNotBucket
+		} else if something == nil {
+			return ErrKeyNotFound
+		}
+		b := something.(*bolt.Bucket)
+
+		if !wantWritableTx {
+			fmt.Fprintf(env.outIO, "%d\n", b.Sequence())
+			return nil
+		}
+
+		env.reportWrite("set sequence of %s to %d", env.args[1], seq)
+		return b.SetSequence(seq)
+	})
+}
+
+func incrementCounter(env *commandEnvironment) error {
+	delta := int64(1)
+	switch len(env.args) {
+	case 1:
+	case 2:
+		var err error
+		delta, err = strconv.ParseInt(env.args[1], 10, 64)
+		if err != nil {
+			return err
+		}
+	default:
+		return ErrUsage
+	}
+
+	return resolveBoltURI(env, env.args[0], true, func(loc *bolt.Location) error {
+		if loc.Key() == nil {
+			return ErrKeyRequired
+		}
+		b, ok := loc.Parent().(*bolt.Bucket)
+		if !ok {
+			return ErrScalarAtRoot
+		}
+
+		n, err := b.IncrementUint64(loc.Key(), delta)
+		if err == bolt.ErrIncompatibleValue {
+			return ErrKeyIsBucket
+		} else if err == bolt.ErrInvalidCounter {
+			return fmt.Errorf("%s: value is %d bytes, expected an 8-byte big-endian counter", env.args[0], len(b.Get(loc.Key())))
+		} else if err != nil {
+			return err
+		}
+
+		env.reportWrite("put %s", env.args[0])
+		fmt.Fprintf(env.outIO, "%d\n", n)
+		return nil
+	})
+}
+
+func exportBucketTree(env *commandEnvironment) (err error) {
+	maxDepth := int64(-1)
+	useHex := false
+	for len(env.args) >= 1 && strings.HasPrefix(env.args[0], "-") {
+		switch {
+		case env.args[0] == "--hex":
+			useHex = true
+			env.args = env.args[1:]
+		case len(env.args) >= 2 && (env.args[0] == "-d" || env.args[0] == "--max-depth"):
+			maxDepth, err = strconv.ParseInt(env.args[1], 10, 64)
+			if err != nil {
+				return err
+			}
+			env.args = env.args[2:]
+		default:
+			return ErrUsage
+		}
+	}
+
+	if len(env.args) != 1 {
+		return ErrUsage
+	}
+
+	encodeBytes := base64.StdEncoding.EncodeToString
+	if useHex {
+		encodeBytes = hex.EncodeToString
+	}
+
+	return resolveBoltURI(env, env.args[0], false, func(loc *bolt.Location) error {
+		something := loc.ResolveHere()
+		var bish bolt.Bucketish
+
+		if b, ok := something.(*bolt.Bucket); ok && b != nil {
+			bish = b
+		} else if rb, ok := something.(*bolt.Tx); ok && rb != nil {
+			bish = rb
+		} else {
+			return ErrBucketNotFound
+		}
+
+		tree := exportBucketTreeNode(bish, 0, maxDepth, encodeBytes)
+
+		enc := json.NewEncoder(env.outIO)
+		enc.SetIndent("", "  ")
+		return enc.Encode(tree)
+	})
+}
+
+func exportBucketTreeNode(bish bolt.Bucketish, atDepth int64, maxDepth int64, encodeBytes func([]byte) string) map[string]interface{} {
+	node := make(map[string]interface{})
+	if atDepth == maxDepth {
+		return node
+	}
+
+	bish.ForEach(func(k []byte, v []byte) error {
+		if v == nil {
+			node[encodeBytes(k)] = exportBucketTreeNode(bish.Bucket(k), atDepth+1, maxDepth, encodeBytes)
+		} else {
+			node[encodeBytes(k)] = encodeBytes(v)
+		}
+		return nil
+	})
+
+	return node
+}
+
+func importBucketTree(env *commandEnvironment) error {
+	useHex := false
+	merge := false
+	for len(env.args) >= 1 && strings.HasPrefix(env.args[0], "-") {
+		switch env.args[0] {
+		case "--hex":
+			useHex = true
+		case "--merge":
+			merge = true
+		default:
+			return ErrUsage
+		}
+		env.args = env.args[1:]
+	}
+
+	if len(env.args) != 1 {
+		return ErrUsage
+	}
+
+	decodeBytes := base64.StdEncoding.DecodeString
+	if useHex {
+		decodeBytes = hex.DecodeString
+	}
+
+	var tree map[string]interface{}
+	if err := json.NewDecoder(env.inIO).Decode(&tree); err != nil {
+		return err
+	}
+
+	return resolveBoltURI(env, env.args[0], true, func(loc *bolt.Location) error {
+		var dest bolt.Bucketish
+		if rb := loc.RootBucketHere(); rb != nil {
+			dest = rb
+		} else {
+			b, err := loc.CreateBucketHereIfNotExists()
+			if err != nil {
+				return err
+			}
+			dest = b
+		}
+
+		return importBucketTreeNode(dest, tree, decodeBytes, merge)
+	})
+}
+
+// importBucketTreeNode writes the decoded document node into dest. Any error
+// aborts the import; since it runs inside a single writable transaction, the
+// database is left untouched.
+func importBucketTreeNode(dest bolt.Bucketish, node map[string]interface{}, decodeBytes func(string) ([]byte, error), merge bool) error {
+	for encodedKey, child := range node {
+		k, err := decodeBytes(encodedKey)
+		if err != nil {
+			return err
+		}
+
+		switch child := child.(type) {
+		case map[string]interface{}:
+			destChild, err := dest.CreateBucketIfNotExists(k)
+			if err != nil {
+				return err
+			}
+			if err := importBucketTreeNode(destChild, child, decodeBytes, merge); err != nil {
+				return err
+			}
+		case string:
+			v, err := decodeBytes(child)
+			if err != nil {
+				return err
+			}
+
+			destBucket, ok := dest.(*bolt.Bucket)
+			if !ok {
+				return bolt.ErrIncompatibleValue
+			}
+			if merge && destBucket.Get(k) != nil {
+				continue
+			}
+			if err := destBucket.Put(k, v); err != nil {
+				return err
+			}
+		default:
+			return ErrMalformedDocument
+		}
+	}
+
+	return nil
+}
+
+// loadBatchSize is the number of rows load commits per transaction.
+const loadBatchSize = 5000
+
+func loadKeyValues(env *commandEnvironment) error {
+	format := "tsv"
+	useBase64 := false
+	var positional []string
+	for i := 0; i < len(env.args); i++ {
+		switch {
+		case env.args[i] == "--format" && i+1 < len(env.args):
+			format = env.args[i+1]
+			i++
+		case env.args[i] == "--base64":
+			useBase64 = true
+		case strings.HasPrefix(env.args[i], "-"):
+			return ErrUsage
+		default:
+			positional = append(positional, env.args[i])
+		}
+	}
+	env.args = positional
+
+	if len(env.args) != 1 || (format != "tsv" && format != "csv") {
+		return ErrUsage
+	}
+
+	mountAlias, keyPath, err := env.parseBoltURI(env.args[0])
+	if err != nil {
+		return err
+	}
+	if len(keyPath) == 0 {
+		return ErrBucketRequired
+	}
+
+	dbPath, ok := env.mounts[mountAlias]
+	if !ok {
+		return ErrAliasNotFound
+	}
+
+	bucketPath := make([][]byte, len(keyPath))
+	for i, childKey := range keyPath {
+		bucketPath[i] = []byte(childKey)
+	}
+
+	decodeField := func(field string) ([]byte, error) {
+		return []byte(field), nil
+	}
+	if useBase64 {
+		decodeField = base64.StdEncoding.DecodeString
+	}
+
+	var readRow func() ([]string, error)
+	if format == "csv" {
+		r := csv.NewReader(env.inIO)
+		r.FieldsPerRecord = 2
+		readRow = r.Read
+	} else {
+		r := bufio.NewReader(env.inIO)
+		readRow = func() ([]string, error) {
+			line, err := r.ReadString('\n')
+			if err == io.EOF && line == "" {
+				return nil, io.EOF
+			} else if err != nil && err != io.EOF {
+				return nil, err
+			}
+			return strings.SplitN(strings.TrimSuffix(line, "\n"), "\t", 2), nil
+		}
+	}
+
+	var loader *bolt.BatchLoader
+	if !env.dryRun {
+		db, closeDB, err := env.openDB(dbPath, 0666, nil)
+		if err != nil {
+			return err
+		}
+		defer closeDB()
+
+		loader = db.NewBatchLoader(bucketPath, loadBatchSize)
+	}
+
+	var n uint64
+	for {
+		row, err := readRow()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		if len(row) != 2 {
+			return ErrMalformedRow
+		}
+
+		k, err := decodeField(row[0])
+		if err != nil {
+			return err
+		}
+		v, err := decodeField(row[1])
+		if err != nil {
+			return err
+		}
+
+		n++
+		if env.dryRun {
+			env.reportWrite("put %s", joinURI(env.args[0], k))
+			continue
+		}
+		if err := loader.Put(k, v); err != nil {
+			return err
+		}
+	}
+
+	if env.dryRun {
+		return nil
+	}
+	if err := loader.Close(); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(env.outIO, "loaded %d keys\n", n)
+	return nil
+}
+
+// syncBatchSize is the number of writes sync commits per transaction.
+const syncBatchSize = 5000
+
+func syncSubtree(env *commandEnvironment) error {
+	if len(env.args) != 3 {
+		return ErrUsage
+	}
+
+	srcAlias, destAlias := env.args[0], env.args[1]
+	keyPath, err := unescapeKeyPath(env.args[2])
+	if err != nil {
+		return err
+	}
+
+	srcPath, ok := env.mounts[srcAlias]
+	if !ok {
+		return ErrAliasNotFound
+	}
+	destPath, ok := env.mounts[destAlias]
+	if !ok {
+		return ErrAliasNotFound
+	}
+	if _, err := os.Stat(srcPath); os.IsNotExist(err) {
+		return ErrFileNotFound
+	}
+	// Opening a missing destination would create it, which a dry run must
+	// not do.
+	if _, err := os.Stat(destPath); os.IsNotExist(err) && env.dryRun {
+		return ErrFileNotFound
+	}
+
+	src, closeSrc, err := env.openDB(srcPath, 0444, &bolt.Options{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer closeSrc()
+
+	dest, closeDest, err := env.openDB(destPath, 0666, nil)
+	if err != nil {
+		return err
+	}
+	defer closeDest()
+
+	// The source is read within a single transaction, while the destination
+	// is written in batches, so the subtree is never held in memory at once.
+	s := &subtreeSyncer{
+		env:     env,
+		dest:    dest,
+		destURI: "bolt://" + destAlias,
+	}
+	defer s.rollback()
+
+	if err := src.View(func(srcTx *bolt.Tx) error {
+		loc, err := navigateToLocation(srcTx, keyPath)
+		if err != nil {
+			return err
+		}
+
+		var root bolt.Bucketish
+		if rb := loc.RootBucketHere(); rb != nil {
+			root = rb
+		} else if b := loc.BucketHere(); b != nil {
+			root = b
+		} else {
+			return ErrBucketNotFound
+		}
+
+		path := make([][]byte, len(keyPath))
+		for i, childKey := range keyPath {
+			path[i] = []byte(childKey)
+		}
+		if b, ok := root.(*bolt.Bucket); ok {
+			if err := s.syncBucketHeader(path, b); err != nil {
+				return err
+			}
+		}
+		return s.syncBucket(path, root)
+	}); err != nil {
+		return err
+	}
+
+	if err := s.flush(); err != nil {
+		return err
+	}
+
+	if env.dryRun {
+		fmt.Fprintf(env.outIO, "would copy %d keys, skip %d keys\n", s.copied, s.skipped)
+	} else {
+		fmt.Fprintf(env.outIO, "copied %d keys, skipped %d keys\n", s.copied, s.skipped)
+	}
+	return nil
+}
+
+// subtreeSyncer writes keys read from a source subtree into a destination
+// database, committing a fresh transaction every syncBatchSize writes. A dry
+// run instead keeps every write in a single transaction, so that later writes
+// see the buckets created by earlier ones, and rolls it back at the end.
+type subtreeSyncer struct {
+	env     *commandEnvironment
+	dest    *bolt.DB
+	destURI string
+
+	tx      *bolt.Tx
+	pending int
+
+	copied  uint64
+	skipped uint64
+}
+
+// syncBucket copies every key under src, which lives at path, recursing into
+// nested buckets. Keys whose destination value is already identical are
+// skipped.
+func (s *subtreeSyncer) syncBucket(path [][]byte, src bolt.Bucketish) error {
+	return src.ForEach(func(k []byte, v []byte) error {
+		childPath := append(path[:len(path):len(path)], k)
+
+		if v == nil {
+			child := src.Bucket(k)
+			if err := s.syncBucketHeader(childPath, child); err != nil {
+				return err
+			}
+			return s.syncBucket(childPath, child)
+		}
+
+		if err := s.begin(); err != nil {
+			return err
+		}
+		dest, err := s.destBucket(path)
+		if err != nil {
+			return err
+		}
+		if bytes.Equal(dest.Get(k), v) {
+			s.skipped++
+			return nil
+		}
+
+		s.env.reportWrite("put %s", s.uriOf(childPath))
+		if err := dest.Put(k, v); err != nil {
+			return err
+		}
+		s.copied++
+		return s.wrote()
+	})
+}
+
+// syncBucketHeader creates the destination bucket at path, if necessary, and
+// gives it the sequence number of src.
+func (s *subtreeSyncer) syncBucketHeader(path [][]byte, src *bolt.Bucket) error {
+	if err := s.begin(); err != nil {
+		return err
+	}
+
+	if s.env.dryRun {
+		if loc, err := bolt.Navigate(s.tx, path); err != nil || loc.BucketHere() == nil {
+			s.env.reportWrite("mkdir %s", s.uriOf(path))
+		}
+	}
+
+	dest, err := s.destBucket(path)
+	if err != nil {
+		return err
+	}
+	if dest.Sequence() == src.Sequence() {
+		return nil
+	}
+	if err := dest.SetSequence(src.Sequence()); err != nil {
+		return err
+	}
+	return s.wrote()
+}
+
+// destBucket returns the bucket at path in the current destination
+// transaction, creating any buckets missing along the way.
+func (s *subtreeSyncer) destBucket(path [][]byte) (*bolt.Bucket, error) {
+	if len(path) == 0 {
+		return nil, bolt.ErrIncompatibleValue
+	}
+
+	var bish bolt.Bucketish = s.tx
+	var b *bolt.Bucket
+	for _, childKey := range path {
+		var err error
+		if b, err = bish.CreateBucketIfNotExists(childKey); err != nil {
+			return nil, err
+		}
+		bish = b
+	}
+	return b, nil
+}
+
+func (s *subtreeSyncer) uriOf(path [][]byte) string {
+	uri := s.destURI
+	for _, childKey := range path {
+		uri = joinURI(uri, childKey)
+	}
+	return uri
+}
+
+func (s *subtreeSyncer) begin() error {
+	if s.tx != nil {
+		return nil
+	}
+
+	tx, err := s.dest.Begin(true)
+	if err != nil {
+		return err
+	}
+	s.tx = tx
+	return nil
+}
+
+// wrote records a write in the current transaction, flushing it once the
+// batch is full. A dry run is never flushed before the end.
+func (s *subtreeSyncer) wrote() error {
+	s.pending++
+	if s.pending < syncBatchSize || s.env.dryRun {
+		return nil
+	}
+	return s.flush()
+}
+
+// flush commits the current transaction, or rolls it back on a dry run.
+func (s *subtreeSyncer) flush() error {
+	if s.tx == nil {
+		return nil
+	}
+
+	tx := s.tx
+	s.tx, s.pending = nil, 0
+	if s.env.dryRun {
+		return tx.Rollback()
+	}
+	return tx.Commit()
+}
+
+func (s *subtreeSyncer) rollback() {
+	if s.tx != nil {
+		_ = s.tx.Rollback()
+		s.tx = nil
+	}
+}
+
+// shellHistoryLimit is the number of commands shell mode keeps in its
+// history file.
+const shellHistoryLimit = 1000
+
+func runShell(env *commandEnvironment) error {
+	if len(env.args) != 0 {
+		return ErrUsage
+	} else if env.dbs != nil {
+		return ErrShellActive
+	}
+
+	// Keep every mounted database open for the life of the shell.
+	env.dbs = make(map[string]*bolt.DB)
+	for alias, path := range env.mounts {
+		if _, ok := env.dbs[path]; !ok {
+			db, closeDB, err := env.openDB(path, 0666, nil)
+			if err != nil {
+				return err
+			}
+			defer closeDB()
+			env.dbs[path] = db
+		}
+		if len(env.mounts) == 1 {
+			env.cwd = "bolt://" + alias + "/"
+		}
+	}
+
+	histPath := ""
+	if home, err := os.UserHomeDir(); err == nil {
+		histPath = filepath.Join(home, ".boltutil_history")
+	}
+	history := readShellHistory(histPath)
+
+	interactive := isTerminal(env.inIO)
+	r := bufio.NewReader(env.inIO)
+	for {
+		if interactive {
+			fmt.Fprintf(env.errIO, "%s> ", env.cwd)
+		}
+
+		line, err := r.ReadString('\n')
+		if err == io.EOF && line == "" {
+			if interactive {
+				fmt.Fprintln(env.errIO)
+			}
+			return writeShellHistory(histPath, history)
+		} else if err != nil && err != io.EOF {
+			return err
+		}
+
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "!") {
+			if line, err = expandShellHistory(line, history); err != nil {
+				fmt.Fprintln(env.errIO, err)
+				continue
+			}
+			fmt.Fprintln(env.errIO, line)
+		}
+
+		words, err := splitShellWords(line)
+		if err != nil {
+			fmt.Fprintln(env.errIO, err)
+			continue
+		} else if len(words) == 0 {
+			continue
+		}
+		history = append(history, line)
+
+		if err := runShellCommand(env, words, history); err == errExitShell {
+			return writeShellHistory(histPath, history)
+		} else if err == ErrUsage && words[0] == "help" {
+			fmt.Fprintln(env.errIO, Usage())
+		} else if err == ErrUsage {
+			fmt.Fprintf(env.errIO, "usage: %s: invalid arguments (see help)\n", words[0])
+		} else if err != nil && err != ErrNotExist {
+			fmt.Fprintln(env.errIO, err)
+		}
+	}
+}
+
+// errExitShell is returned by runShellCommand when the user leaves the shell.
+var errExitShell = errors.New("exit")
+
+func runShellCommand(env *commandEnvironment, words []string, history []string) error {
+	args := words[1:]
+
+	switch words[0] {
+	case "exit", "quit":
+		return errExitShell
+	case "pwd":
+		if len(args) != 0 {
+			return ErrUsage
+		}
+		fmt.Fprintln(env.outIO, env.cwd)
+		return nil
+	case "cd":
+		if len(args) == 0 {
+			args = []string{"/"}
+		} else if len(args) != 1 {
+			return ErrUsage
+		}
+		return changeBucket(env, args[0])
+	case "history":
+		for i, line := range history {
+			fmt.Fprintf(env.outIO, "%5d  %s\n", i+1, line)
+		}
+		return nil
+	}
+
+	cmdEnv := *env
+	cmdEnv.args = args
+	return runCommand(&cmdEnv, words[0])
+}
+
+// changeBucket sets the shell's current bucket to the bucket named by rawURI.
+func changeBucket(env *commandEnvironment, rawURI string) error {
+	mountAlias, keyPath, err := env.parseBoltURI(rawURI)
+	if err != nil {
+		return err
+	}
+
+	if err := resolveBoltURI(env, rawURI, false, func(loc *bolt.Location) error {
+		switch loc.ResolveHere().(type) {
+		case *bolt.Tx, *bolt.Bucket:
+			return nil
+		case []byte:
+			return ErrKeyIsNotBucket
+		default:
+			return ErrBucketNotFound
+		}
+	}); err != nil {
+		return err
+	}
+
+	env.cwd = "bolt://" + mountAlias + "/"
+	for _, key := range keyPath {
+		env.cwd = joinURI(env.cwd, []byte(key))
+	}
+	return nil
+}
+
+// expandShellHistory replaces a '!!' or '!N' line with the command it refers
+// to.
+func expandShellHistory(line string, history []string) (string, error) {
+	i := len(history)
+	if line != "!!" {
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return "", fmt.Errorf("%s: event not found", line)
+		}
+		i = n
+	}
+	if i < 1 || i > len(history) {
+		return "", fmt.Errorf("%s: event not found", line)
+	}
+	return history[i-1], nil
+}
+
+func readShellHistory(path string) []string {
+	if path == "" {
+		return nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	return strings.FieldsFunc(string(data), func(c rune) bool { return c == '\n' })
+}
+
+func writeShellHistory(path string, history []string) error {
+	if path == "" || len(history) == 0 {
+		return nil
+	}
+	if len(history) > shellHistoryLimit {
+		history = history[len(history)-shellHistoryLimit:]
+	}
+	return ioutil.WriteFile(path, []byte(strings.Join(history, "\n")+"\n"), 0600)
+}
+
+// splitShellWords splits a shell mode command line into words. Words are
+// separated by whitespace, and may be quoted with single quotes, which are
+// taken literally, or double quotes, within which a backslash escapes the
+// next character.
+func splitShellWords(line string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	var quote rune
+
+	runes := []rune(line)
+	for i := 0; i < len(runes); i++ {
+		c := runes[i]
+		switch {
+		case quote == '\'':
+			if c == '\'' {
+				quote = 0
+			} else {
+				word.WriteRune(c)
+			}
+		case c == '\\' && quote != '\'':
+			if i+1 == len(runes) {
+				return nil, ErrUnterminatedQuote
+			}
+			i++
+			word.WriteRune(runes[i])
+			inWord = true
+		case quote == '"':
+			if c == '"' {
+				quote = 0
+			} else {
+				word.WriteRune(c)
+			}
+		case c == '\'' || c == '"':
+			quote = c
+			inWord = true
+		case c == ' ' || c == '\t':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(c)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, ErrUnterminatedQuote
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
 }
@@ -0,0 +1,520 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	bolt "github.com/covalenthq/bbolt"
+)
+
+// mustCreateDB creates a database in a temporary file, populates it with fn
+// and returns its path. The caller is responsible for removing the file.
+func mustCreateDB(t *testing.T, fn func(tx *bolt.Tx) error) string {
+	t.Helper()
+	f, err := ioutil.TempFile("", "boltutil-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := f.Name()
+	f.Close()
+	os.Remove(path)
+
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := db.Update(fn); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// newTestEnv returns a command environment with the database at path
+// mounted as "db", writing its output to out.
+func newTestEnv(path string, out *bytes.Buffer, args ...string) *commandEnvironment {
+	return &commandEnvironment{
+		mounts:      map[string]string{"db": path},
+		txHandles:   make(map[string]*bolt.Tx),
+		args:        args,
+		inIO:        strings.NewReader(""),
+		outIO:       out,
+		errIO:       ioutil.Discard,
+		encoding:    "utf8",
+		openTimeout: defaultOpenTimeout,
+	}
+}
+
+// Ensure that each segment of a URI's key path is percent-decoded on its own.
+func TestParseBoltURI_Unescape(t *testing.T) {
+	for _, tt := range []struct {
+		uri     string
+		keyPath []string
+	}{
+		{"bolt://db/a%2Fb", []string{"a/b"}},
+		{"bolt://db/a%2Fb/c", []string{"a/b", "c"}},
+		{"bolt://db/foo%20bar", []string{"foo bar"}},
+		{"bolt://db/foo bar", []string{"foo bar"}},
+		{"bolt://db/%25", []string{"%"}},
+	} {
+		alias, keyPath, err := parseBoltURI(tt.uri)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.uri, err)
+		} else if alias != "db" {
+			t.Fatalf("%s: unexpected alias: %q", tt.uri, alias)
+		} else if !reflect.DeepEqual(keyPath, tt.keyPath) {
+			t.Fatalf("%s: unexpected key path: %q", tt.uri, keyPath)
+		}
+	}
+
+	for _, uri := range []string{"bolt://db/%zz", "bolt://db/a/%2"} {
+		if _, _, err := parseBoltURI(uri); err == nil {
+			t.Fatalf("%s: expected error", uri)
+		}
+	}
+}
+
+// Ensure that shell-relative paths are percent-decoded like URIs.
+func TestCommandEnvironment_ParseBoltURI_Unescape(t *testing.T) {
+	env := newTestEnv("", &bytes.Buffer{})
+	env.cwd = joinURI("bolt://db", []byte("a/b"))
+	if env.cwd != "bolt://db/a%2Fb" {
+		t.Fatalf("unexpected cwd: %q", env.cwd)
+	}
+
+	alias, keyPath, err := env.parseBoltURI("c%2Fd/../e%20f")
+	if err != nil {
+		t.Fatal(err)
+	} else if alias != "db" || !reflect.DeepEqual(keyPath, []string{"a/b", "e f"}) {
+		t.Fatalf("unexpected path: %q %q", alias, keyPath)
+	}
+	if _, _, err := env.parseBoltURI("%zz"); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+// Ensure that leading and trailing slashes are ignored when splitting a key
+// path, but that empty segments are rejected.
+func TestSplitKeyPath(t *testing.T) {
+	for _, tt := range []struct {
+		path     string
+		segments []string
+		err      error
+	}{
+		{"", nil, nil},
+		{"/", nil, nil},
+		{"a", []string{"a"}, nil},
+		{"/a/", []string{"a"}, nil},
+		{"a/b", []string{"a", "b"}, nil},
+		{"a//b", nil, ErrEmptyKeySegment},
+		{"/a/b//", nil, ErrEmptyKeySegment},
+	} {
+		segments, err := splitKeyPath(tt.path)
+		if err != tt.err {
+			t.Fatalf("%q: unexpected error: %v", tt.path, err)
+		} else if !reflect.DeepEqual(segments, tt.segments) {
+			t.Fatalf("%q: unexpected segments: %q", tt.path, segments)
+		}
+	}
+}
+
+// Ensure that URIs resolve to the root, a bucket or a value as documented,
+// and that a trailing slash never resolves to a value.
+func TestResolveBoltURI(t *testing.T) {
+	path := mustCreateDB(t, func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("a"))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte("k"), []byte("v"))
+	})
+	defer os.Remove(path)
+
+	for _, tt := range []struct {
+		uri  string
+		kind string
+		err  error
+	}{
+		{"bolt://db", "root", nil},
+		{"bolt://db/", "root", nil},
+		{"bolt://db/a", "bucket", nil},
+		{"bolt://db/a/", "bucket", nil},
+		{"bolt://db/a/k", "scalar", nil},
+		{"bolt://db/a/k/", "", ErrKeyIsNotBucket},
+		{"bolt://db/a/x", "missing", nil},
+		{"bolt://db/a//k", "", ErrEmptyKeySegment},
+		{"bolt://db//a", "", ErrEmptyKeySegment},
+	} {
+		var kind string
+		err := resolveBoltURI(newTestEnv(path, &bytes.Buffer{}), tt.uri, false, func(loc *bolt.Location) error {
+			switch {
+			case loc.RootBucketHere() != nil:
+				kind = "root"
+			case loc.IsBucket():
+				kind = "bucket"
+			case loc.IsScalar():
+				kind = "scalar"
+			default:
+				kind = "missing"
+			}
+			return nil
+		})
+		if err != tt.err {
+			t.Fatalf("%s: unexpected error: %v", tt.uri, err)
+		} else if kind != tt.kind {
+			t.Fatalf("%s: unexpected kind: %q", tt.uri, kind)
+		}
+	}
+
+	// Values cannot be written directly to the root.
+	if err := runCommand(newTestEnv(path, &bytes.Buffer{}, "bolt://db/k", "v"), "put"); err != ErrScalarAtRoot {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure that page reports overflow pages and fails on pages that cannot be
+// decoded instead of crashing.
+func TestInspectPage(t *testing.T) {
+	pageSize := os.Getpagesize()
+	path := mustCreateDB(t, func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 20; i++ {
+			if err := b.Put([]byte{byte('a' + i)}, bytes.Repeat([]byte("x"), 100)); err != nil {
+				return err
+			}
+		}
+		return b.Put([]byte("large"), bytes.Repeat([]byte{0x02}, 4*pageSize))
+	})
+	defer os.Remove(path)
+
+	// Find an overflow page and a leaf page with several elements.
+	var overflow, leaf int
+	db, err := bolt.Open(path, 0600, &bolt.Options{ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.View(func(tx *bolt.Tx) error {
+		for id := 2; ; id++ {
+			p, err := tx.Page(id)
+			if err != nil {
+				return err
+			} else if p == nil {
+				return nil
+			}
+			if p.Type == "overflow" {
+				overflow = id
+			} else if p.Type == "leaf" && p.Count > 1 && p.OverflowCount == 0 {
+				leaf = id
+			}
+		}
+	}); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	if overflow == 0 || leaf == 0 {
+		t.Fatalf("expected overflow and leaf pages: %d, %d", overflow, leaf)
+	}
+
+	var out bytes.Buffer
+	if err := runCommand(newTestEnv(path, &out, "db", fmt.Sprint(overflow)), "page"); err != nil {
+		t.Fatal(err)
+	} else if !strings.Contains(out.String(), "type: overflow\n") {
+		t.Fatalf("unexpected output: %q", out.String())
+	}
+
+	// Claim more elements than the leaf page can hold.
+	f, err := os.OpenFile(path, os.O_RDWR, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteAt([]byte{0xff, 0xff}, int64(leaf*pageSize+10)); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	out.Reset()
+	err = runCommand(newTestEnv(path, &out, "db", fmt.Sprint(leaf)), "page")
+	if exp := fmt.Sprintf("cannot decode page %d: element count out of bounds: 65535", leaf); err == nil || err.Error() != exp {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure that the prefix given to sync is split and unescaped the same way
+// as the key path of a URI.
+func TestSyncSubtree_Prefix(t *testing.T) {
+	src := mustCreateDB(t, func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("a/b"))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte("foo"), []byte("bar"))
+	})
+	defer os.Remove(src)
+	dest := mustCreateDB(t, func(tx *bolt.Tx) error { return nil })
+	defer os.Remove(dest)
+
+	newSyncEnv := func(prefix string) *commandEnvironment {
+		env := newTestEnv(src, &bytes.Buffer{}, "src", "dest", prefix)
+		env.mounts = map[string]string{"src": src, "dest": dest}
+		return env
+	}
+
+	if err := runCommand(newSyncEnv("a//b"), "sync"); err != ErrEmptyKeySegment {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := runCommand(newSyncEnv("a%2Fb"), "sync"); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := bolt.Open(dest, 0600, &bolt.Options{ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("a/b"))
+		if b == nil {
+			t.Fatal("expected bucket")
+		} else if v := b.Get([]byte("foo")); string(v) != "bar" {
+			t.Fatalf("unexpected value: %q", v)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that check --summary counts leaked pages from the errors reported
+// by the consistency check.
+func TestCheckDatabaseFile_Summary(t *testing.T) {
+	path := mustCreateDB(t, func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucket([]byte("widgets"))
+		return err
+	})
+	defer os.Remove(path)
+
+	// Rewrite the bucket so that the first version of its pages is freed,
+	// and find the freelist page of the active meta page.
+	var freelist *bolt.PageInfo
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("widgets")).Put([]byte("foo"), []byte("bar"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.View(func(tx *bolt.Tx) error {
+		for id := 0; id < 2; id++ {
+			m, err := tx.Page(id)
+			if err != nil {
+				return err
+			}
+			if m.Meta.TxID == tx.ID() {
+				if freelist, err = tx.Page(m.Meta.Freelist); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	pageSize := db.Info().PageSize
+	db.Close()
+	if freelist == nil || freelist.Count == 0 {
+		t.Fatalf("expected free pages: %+v", freelist)
+	}
+
+	var out bytes.Buffer
+	if err := runCommand(newTestEnv(path, &out, "--summary", "db"), "check"); err != nil {
+		t.Fatal(err)
+	} else if !strings.Contains(out.String(), "leaked pages: 0\n") {
+		t.Fatalf("unexpected output: %q", out.String())
+	}
+
+	// Drop the last id from the freelist, which leaks that page.
+	f, err := os.OpenFile(path, os.O_RDWR, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	count := []byte{byte(freelist.Count - 1), byte((freelist.Count - 1) >> 8)}
+	if _, err := f.WriteAt(count, int64(freelist.ID*pageSize+10)); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	out.Reset()
+	if err := runCommand(newTestEnv(path, &out, "--summary", "db"), "check"); err != ErrCheckFailed {
+		t.Fatalf("unexpected error: %v", err)
+	} else if !strings.Contains(out.String(), "leaked pages: 1\nproblems: 1\n") {
+		t.Fatalf("unexpected output: %q", out.String())
+	}
+}
+
+// Ensure that rm --prefix neither deletes nor claims to delete keys on a dry
+// run.
+func TestRemoveKeysWithPrefix_DryRun(t *testing.T) {
+	path := mustCreateDB(t, func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("a"))
+		if err != nil {
+			return err
+		}
+		if err := b.Put([]byte("pk1"), []byte("x")); err != nil {
+			return err
+		}
+		return b.Put([]byte("pk2"), []byte("y"))
+	})
+	defer os.Remove(path)
+
+	var out bytes.Buffer
+	env := newTestEnv(path, &out, "--prefix", "pk", "bolt://db/a")
+	env.dryRun = true
+	if err := runCommand(env, "rm"); err != nil {
+		t.Fatal(err)
+	} else if exp := "would delete bolt://db/a/pk1\nwould delete bolt://db/a/pk2\n"; out.String() != exp {
+		t.Fatalf("unexpected output: %q", out.String())
+	}
+
+	out.Reset()
+	if err := runCommand(newTestEnv(path, &out, "bolt://db/a"), "keys"); err != nil {
+		t.Fatal(err)
+	} else if out.String() != "pk1\npk2\n" {
+		t.Fatalf("unexpected keys: %q", out.String())
+	}
+}
+
+// Ensure that a dry run of sync neither creates nor writes to the
+// destination, and reports the writes of a subtree spanning several batches
+// as if they were made in one transaction.
+func TestSyncSubtree_DryRun(t *testing.T) {
+	src := mustCreateDB(t, func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("a"))
+		if err != nil {
+			return err
+		}
+		for i := 0; i < syncBatchSize+1; i++ {
+			if err := b.Put([]byte(fmt.Sprintf("k%05d", i)), []byte("v")); err != nil {
+				return err
+			}
+		}
+		child, err := b.CreateBucket([]byte("z"))
+		if err != nil {
+			return err
+		}
+		return child.Put([]byte("foo"), []byte("bar"))
+	})
+	defer os.Remove(src)
+	dest := src + ".dest"
+	defer os.Remove(dest)
+
+	var out bytes.Buffer
+	newSyncEnv := func() *commandEnvironment {
+		out.Reset()
+		env := newTestEnv(src, &out, "src", "dest", "a")
+		env.mounts = map[string]string{"src": src, "dest": dest}
+		env.dryRun = true
+		return env
+	}
+
+	if err := runCommand(newSyncEnv(), "sync"); err != ErrFileNotFound {
+		t.Fatalf("unexpected error: %v", err)
+	} else if _, err := os.Stat(dest); !os.IsNotExist(err) {
+		t.Fatalf("expected no destination file: %v", err)
+	}
+
+	if db, err := bolt.Open(dest, 0600, nil); err != nil {
+		t.Fatal(err)
+	} else if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := runCommand(newSyncEnv(), "sync"); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	var mkdirs []string
+	for _, line := range lines {
+		if strings.HasPrefix(line, "would mkdir ") {
+			mkdirs = append(mkdirs, line)
+		}
+	}
+	if !reflect.DeepEqual(mkdirs, []string{"would mkdir bolt://dest/a", "would mkdir bolt://dest/a/z"}) {
+		t.Fatalf("unexpected mkdirs: %q", mkdirs)
+	} else if exp := fmt.Sprintf("would copy %d keys, skip 0 keys", syncBatchSize+2); lines[len(lines)-1] != exp {
+		t.Fatalf("unexpected summary: %q", lines[len(lines)-1])
+	}
+
+	db, err := bolt.Open(dest, 0600, &bolt.Options{ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := db.View(func(tx *bolt.Tx) error {
+		if k, _ := tx.First(); k != nil {
+			t.Fatalf("unexpected bucket: %q", k)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that du writes both of its output formats to the command
+// environment.
+func TestPrintDiskUsage(t *testing.T) {
+	path := mustCreateDB(t, func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("a"))
+		if err != nil {
+			return err
+		}
+		_, err = b.CreateBucket([]byte("b"))
+		return err
+	})
+	defer os.Remove(path)
+
+	for _, args := range [][]string{
+		{"bolt://db/"},
+		{"--sort", "size", "bolt://db/"},
+	} {
+		var out bytes.Buffer
+		if err := runCommand(newTestEnv(path, &out, args...), "du"); err != nil {
+			t.Fatal(err)
+		} else if !strings.HasPrefix(out.String(), "a = ") {
+			t.Fatalf("%q: unexpected output: %q", args, out.String())
+		}
+	}
+}
+
+// Ensure that tree writes every format to the command environment.
+func TestPrintBucketTree(t *testing.T) {
+	path := mustCreateDB(t, func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("a"))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte("k"), []byte("v"))
+	})
+	defer os.Remove(path)
+
+	for _, format := range []string{"plain", "json", "yaml"} {
+		var out bytes.Buffer
+		if err := runCommand(newTestEnv(path, &out, "--format", format, "bolt://db/"), "tree"); err != nil {
+			t.Fatal(err)
+		} else if !strings.Contains(out.String(), "a") || !strings.Contains(out.String(), "k") {
+			t.Fatalf("%s: unexpected output: %q", format, out.String())
+		}
+	}
+}
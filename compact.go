@@ -0,0 +1,138 @@
+package bbolt
+
+// compactWalkFunc is the type of the function called for keys (buckets and
+// "normal" values) discovered by walkForCompact. keys is the list of keys to
+// descend to the bucket owning the discovered key/value pair k/v.
+type compactWalkFunc func(keys [][]byte, k, v []byte, seq uint64) error
+
+// CompactTo copies every bucket and key of the database into dst, which is
+// expected to be empty, preserving nested bucket structure and sequence
+// numbers. Writes to dst are committed in transactions bounded by txMaxSize
+// bytes of key and value data; a txMaxSize of 0 copies everything in a single
+// transaction.
+//
+// If progress is not nil, it is called after each committed transaction with
+// the number of keys and buckets copied so far and the total to be copied.
+//
+// The source is read within a single read-only transaction, so CompactTo can
+// be run while the database remains in use.
+func (db *DB) CompactTo(dst *DB, txMaxSize int64, progress func(copied, total int)) error {
+	return db.View(func(srcTx *Tx) error {
+		var total int
+		if progress != nil {
+			if err := walkForCompact(srcTx, func(keys [][]byte, k, v []byte, seq uint64) error {
+				total++
+				return nil
+			}); err != nil {
+				return err
+			}
+		}
+
+		// Commit regularly, or we'll run out of memory for large datasets if
+		// using one transaction.
+		var size int64
+		var copied int
+		tx, err := dst.Begin(true)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = tx.Rollback() }()
+
+		if err := walkForCompact(srcTx, func(keys [][]byte, k, v []byte, seq uint64) error {
+			// On each key/value, check if we have exceeded tx size.
+			sz := int64(len(k) + len(v))
+			if size+sz > txMaxSize && txMaxSize != 0 {
+				// Commit previous transaction.
+				if err := tx.Commit(); err != nil {
+					return err
+				}
+				if progress != nil {
+					progress(copied, total)
+				}
+
+				// Start new transaction.
+				tx, err = dst.Begin(true)
+				if err != nil {
+					return err
+				}
+				size = 0
+			}
+			size += sz
+			copied++
+
+			// Create bucket on the root transaction if this is the first level.
+			nk := len(keys)
+			if nk == 0 {
+				bkt, err := tx.CreateBucket(k)
+				if err != nil {
+					return err
+				}
+				return bkt.SetSequence(seq)
+			}
+
+			// Create buckets on subsequent levels, if necessary.
+			b := tx.Bucket(keys[0])
+			if nk > 1 {
+				for _, k := range keys[1:] {
+					b = b.Bucket(k)
+				}
+			}
+
+			// Fill the entire page for best compaction.
+			b.FillPercent = 1.0
+
+			// If there is no value then this is a bucket call.
+			if v == nil {
+				bkt, err := b.CreateBucket(k)
+				if err != nil {
+					return err
+				}
+				return bkt.SetSequence(seq)
+			}
+
+			// Otherwise treat it as a key/value pair.
+			return b.Put(k, v)
+		}); err != nil {
+			return err
+		}
+
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		if progress != nil {
+			progress(copied, total)
+		}
+
+		return nil
+	})
+}
+
+// walkForCompact walks recursively the buckets of tx, calling fn for each key
+// it finds.
+func walkForCompact(tx *Tx, fn compactWalkFunc) error {
+	return tx.ForEachBucket(func(name []byte, b *Bucket) error {
+		return walkBucketForCompact(b, nil, name, nil, b.Sequence(), fn)
+	})
+}
+
+func walkBucketForCompact(b *Bucket, keypath [][]byte, k, v []byte, seq uint64, fn compactWalkFunc) error {
+	// Execute callback.
+	if err := fn(keypath, k, v, seq); err != nil {
+		return err
+	}
+
+	// If this is not a bucket then stop.
+	if v != nil {
+		return nil
+	}
+
+	// Iterate over each child key/value.
+	keypath = append(keypath, k)
+	return b.ForEach(func(k, v []byte) error {
+		if v == nil {
+			bkt := b.Bucket(k)
+			return walkBucketForCompact(bkt, keypath, k, nil, bkt.Sequence(), fn)
+		}
+		return walkBucketForCompact(b, keypath, k, v, b.Sequence(), fn)
+	})
+}
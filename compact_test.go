@@ -0,0 +1,69 @@
+package bbolt_test
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	bolt "github.com/covalenthq/bbolt"
+)
+
+// Ensure that compaction copies nested buckets, keys and sequences and
+// reports its progress.
+func TestDB_CompactTo(t *testing.T) {
+	src := MustOpenDB()
+	defer src.MustClose()
+	dst := MustOpenDB()
+	defer dst.MustClose()
+
+	if err := src.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := b.SetSequence(7); err != nil {
+			t.Fatal(err)
+		}
+		child, err := b.CreateBucket([]byte("child"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 100; i++ {
+			if err := child.Put([]byte(fmt.Sprintf("%03d", i)), []byte("value")); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	var calls, lastCopied, lastTotal int
+	if err := src.CompactTo(dst.DB, 256, func(copied, total int) {
+		calls++
+		lastCopied, lastTotal = copied, total
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if calls < 2 {
+		t.Fatalf("expected several progress calls, got %d", calls)
+	} else if lastCopied != 102 || lastTotal != 102 {
+		t.Fatalf("unexpected progress: %d/%d", lastCopied, lastTotal)
+	}
+
+	if err := dst.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		if seq := b.Sequence(); seq != 7 {
+			t.Fatalf("unexpected sequence: %d", seq)
+		}
+		child := b.Bucket([]byte("child"))
+		for i := 0; i < 100; i++ {
+			if v := child.Get([]byte(fmt.Sprintf("%03d", i))); !bytes.Equal(v, []byte("value")) {
+				t.Fatalf("unexpected value: %v", v)
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,104 @@
+package bbolt
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+)
+
+// compressionMagic is the first byte of every value written by PutCompressed.
+const compressionMagic = 0xcf
+
+// Compressor compresses and decompresses values written by PutCompressed.
+type Compressor interface {
+	// Compress returns the compressed form of value.
+	Compress(value []byte) ([]byte, error)
+
+	// Decompress returns the value that was compressed into data. size is the
+	// length of the original value.
+	Decompress(data []byte, size int) ([]byte, error)
+}
+
+// ValueCompressor is the Compressor used by PutCompressed and GetCompressed.
+// It defaults to DEFLATE, and may be replaced before any values are written,
+// e.g. with a snappy or zstd implementation. Values must be read with the
+// same Compressor they were written with.
+var ValueCompressor Compressor = flateCompressor{}
+
+// PutCompressed compresses a value with ValueCompressor and sets it for a key
+// in the bucket as Put does. The stored value is framed by a magic byte and
+// the uncompressed length, so compressed and raw values can live side by side
+// and compression can be adopted per key.
+func (b *Bucket) PutCompressed(key []byte, value []byte) error {
+	data, err := ValueCompressor.Compress(value)
+	if err != nil {
+		return err
+	}
+
+	v := make([]byte, 1+binary.MaxVarintLen64+len(data))
+	v[0] = compressionMagic
+	n := 1 + binary.PutUvarint(v[1:], uint64(len(value)))
+	n += copy(v[n:], data)
+
+	return b.Put(key, v[:n])
+}
+
+// GetCompressed retrieves and decompresses a value written by PutCompressed.
+// Values that were not written by PutCompressed are recognized by their
+// first byte and returned as Get would return them, so that a raw value
+// whose first byte happens to be the compression magic byte must itself be
+// written with PutCompressed to be read back correctly.
+// Returns ErrKeyNotFound if the key does not exist or is a nested bucket, and
+// ErrCorruptCompressedValue if a compressed value cannot be decoded.
+func (b *Bucket) GetCompressed(key []byte) ([]byte, error) {
+	v := b.Get(key)
+	if v == nil {
+		return nil, ErrKeyNotFound
+	} else if len(v) == 0 || v[0] != compressionMagic {
+		return v, nil
+	}
+
+	size, n := binary.Uvarint(v[1:])
+	if n <= 0 || size > uint64(MaxValueSize) {
+		return nil, ErrCorruptCompressedValue
+	}
+
+	value, err := ValueCompressor.Decompress(v[1+n:], int(size))
+	if err != nil || len(value) != int(size) {
+		return nil, ErrCorruptCompressedValue
+	}
+	return value, nil
+}
+
+// flateCompressor is the default Compressor, using DEFLATE.
+type flateCompressor struct{}
+
+func (flateCompressor) Compress(value []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := flate.NewWriter(&buf, flate.DefaultCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(value); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (flateCompressor) Decompress(data []byte, size int) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(data))
+	defer r.Close()
+
+	// Read at most one byte more than expected, so that a corrupt value can't
+	// decompress to an arbitrary size.
+	value, err := ioutil.ReadAll(io.LimitReader(r, int64(size)+1))
+	if err != nil {
+		return nil, err
+	}
+	return value, nil
+}
@@ -0,0 +1,98 @@
+package bbolt_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	bolt "github.com/covalenthq/bbolt"
+)
+
+// Ensure that compressed values round-trip and that raw values are returned
+// as is.
+func TestBucket_PutCompressed(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	value := []byte(strings.Repeat(`{"name":"widget","count":1}`, 100))
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := b.PutCompressed([]byte("foo"), value); err != nil {
+			t.Fatal(err)
+		}
+		if n := len(b.Get([]byte("foo"))); n >= len(value)/5 {
+			t.Fatalf("expected value to be compressed: %d bytes", n)
+		}
+		if v, err := b.GetCompressed([]byte("foo")); err != nil {
+			t.Fatal(err)
+		} else if !bytes.Equal(v, value) {
+			t.Fatalf("unexpected value: %q", v)
+		}
+
+		if err := b.Put([]byte("raw"), []byte("bar")); err != nil {
+			t.Fatal(err)
+		}
+		if v, err := b.GetCompressed([]byte("raw")); err != nil {
+			t.Fatal(err)
+		} else if !bytes.Equal(v, []byte("bar")) {
+			t.Fatalf("unexpected value: %q", v)
+		}
+
+		corrupt := append([]byte(nil), b.Get([]byte("foo"))...)
+		corrupt = corrupt[:len(corrupt)/2]
+		if err := b.Put([]byte("foo"), corrupt); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := b.GetCompressed([]byte("foo")); err != bolt.ErrCorruptCompressedValue {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, err := b.GetCompressed([]byte("missing")); err != bolt.ErrKeyNotFound {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// identityCompressor stores values uncompressed.
+type identityCompressor struct{}
+
+func (identityCompressor) Compress(value []byte) ([]byte, error) { return value, nil }
+
+func (identityCompressor) Decompress(data []byte, size int) ([]byte, error) { return data, nil }
+
+// Ensure that the compressor used by PutCompressed can be replaced.
+func TestBucket_PutCompressed_Compressor(t *testing.T) {
+	defer func(c bolt.Compressor) { bolt.ValueCompressor = c }(bolt.ValueCompressor)
+	bolt.ValueCompressor = identityCompressor{}
+
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := b.PutCompressed([]byte("foo"), []byte("bar")); err != nil {
+			t.Fatal(err)
+		}
+		if v := b.Get([]byte("foo")); !bytes.HasSuffix(v, []byte("bar")) || len(v) != 5 {
+			t.Fatalf("unexpected stored value: %q", v)
+		}
+		if v, err := b.GetCompressed([]byte("foo")); err != nil {
+			t.Fatal(err)
+		} else if !bytes.Equal(v, []byte("bar")) {
+			t.Fatalf("unexpected value: %q", v)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -202,6 +202,20 @@ func (c *Cursor) Seek(seek []byte) (key []byte, value []byte) {
 	return k, v
 }
 
+// SeekReverse moves the cursor to the last key less than or equal to seek and
+// returns it. If the key does not exist then the previous key is used. If no
+// keys precede it, a nil key is returned.
+// The returned key and value are only valid for the life of the transaction.
+func (c *Cursor) SeekReverse(seek []byte) (key []byte, value []byte) {
+	k, v := c.Seek(seek)
+	if k == nil {
+		return c.Last()
+	} else if !bytes.Equal(k, seek) {
+		return c.Prev()
+	}
+	return k, v
+}
+
 func (c *Cursor) SeekBucket(seek []byte) (key []byte, bucket *Bucket) {
 	k, v, flags := c.seek(seek)
 
@@ -259,12 +273,13 @@ func (c *Cursor) Delete() error {
 		return ErrTxNotWritable
 	}
 
-	key, _, flags := c.keyValue()
+	key, v, flags := c.keyValue()
 	// Return an error if current value is a bucket.
 	if (flags & bucketLeafFlag) != 0 {
 		return ErrIncompatibleValue
 	}
 	c.node().del(key)
+	c.bucket.notifyDelete(key, v)
 
 	return nil
 }
@@ -587,6 +602,27 @@ func (c *Cursor) keyValue() ([]byte, []byte, uint32) {
 	return append(ref.page.keyPrefix(), elem.key()...), elem.value(), elem.flags
 }
 
+// keyEquals reports whether the key the cursor is positioned on equals key,
+// along with that element's flags. Unlike keyValue, it doesn't allocate to
+// rebuild prefix-compressed keys or reference the value.
+func (c *Cursor) keyEquals(key []byte) (bool, uint32) {
+	ref := &c.stack[len(c.stack)-1]
+
+	// If the cursor is pointing to the end of page/node then there is no key.
+	if ref.count() == 0 || ref.index >= ref.count() {
+		return false, 0
+	}
+
+	if ref.node != nil {
+		inode := &ref.node.inodes[ref.index]
+		return bytes.Equal(inode.key, key), inode.flags
+	}
+
+	elem := ref.page.leafPageElement(uint16(ref.index))
+	prefix := ref.page.keyPrefix()
+	return bytes.HasPrefix(key, prefix) && bytes.Equal(key[len(prefix):], elem.key()), elem.flags
+}
+
 // node returns the node that the cursor is currently positioned on.
 func (c *Cursor) node() *node {
 	_assert(len(c.stack) > 0, "accessing a node with a zero-length cursor stack")
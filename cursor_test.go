@@ -103,6 +103,78 @@ func TestCursor_Seek(t *testing.T) {
 	}
 }
 
+// Ensure that a cursor can seek to the last key at or before a target.
+func TestCursor_SeekReverse(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	const count = 1000
+
+	// Insert every other key between 2 and $count, spanning several pages.
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 2; i <= count; i += 2 {
+			k := make([]byte, 8)
+			binary.BigEndian.PutUint64(k, uint64(i))
+			if err := b.Put(k, k); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket([]byte("widgets")).Cursor()
+		for i := 0; i <= count+1; i++ {
+			seek := make([]byte, 8)
+			binary.BigEndian.PutUint64(seek, uint64(i))
+
+			k, v := c.SeekReverse(seek)
+
+			// Seeking before the first key should return nil.
+			if i < 2 {
+				if k != nil {
+					t.Fatalf("%d: expected nil key: %v", i, k)
+				}
+				continue
+			}
+
+			// Otherwise we should seek to the exact key or the previous key.
+			exp := uint64(i - i%2)
+			if i > count {
+				exp = count
+			}
+			if num := binary.BigEndian.Uint64(k); num != exp {
+				t.Fatalf("%d: unexpected num: %d", i, num)
+			} else if !bytes.Equal(k, v) {
+				t.Fatalf("%d: unexpected value: %v", i, v)
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// An empty bucket has nothing to seek to.
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("empty"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if k, _ := b.Cursor().SeekReverse([]byte("foo")); k != nil {
+			t.Fatalf("expected nil key: %v", k)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
 func TestCursor_Delete(t *testing.T) {
 	db := MustOpenDB()
 	defer db.MustClose()
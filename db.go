@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"hash/fnv"
+	"io"
 	"log"
 	"os"
 	"runtime"
@@ -32,14 +33,20 @@ const IgnoreNoSync = runtime.GOOS == "openbsd"
 
 // Default values if not set in a DB instance.
 const (
-	DefaultMaxBatchSize  int = 1000
-	DefaultMaxBatchDelay     = 10 * time.Millisecond
-	DefaultAllocSize         = 16 * 1024 * 1024
+	DefaultMaxBatchSize   int = 1000
+	DefaultMaxBatchDelay      = 10 * time.Millisecond
+	DefaultAllocSize          = 16 * 1024 * 1024
+	DefaultMaxPooledTxAge     = 100 * time.Millisecond
 )
 
 // default page size for db is set to the OS page size.
 var defaultPageSize = os.Getpagesize()
 
+// minPageSize is the smallest page size accepted by Open. The file of a new
+// database holds four pages, and must be at least as large as the first
+// 4KB read back from it to find the page size when it is reopened.
+const minPageSize = 1024
+
 // The time elapsed between consecutive file locking attempts.
 const flockRetryTimeout = 50 * time.Millisecond
 
@@ -97,6 +104,11 @@ type DB struct {
 
 	// If you want to read the entire database fast, you can set MmapFlag to
 	// syscall.MAP_POPULATE on Linux 2.6.23+ for sequential read-ahead.
+	//
+	// On Unix platforms the flags are combined with MAP_SHARED in every call
+	// to mmap, including the remaps made as the database grows. Windows maps
+	// files with CreateFileMapping and ignores them. The map is read-only and
+	// pages are written with pwrite, so the flags only affect reads.
 	MmapFlags int
 
 	// MaxBatchSize is the maximum size of a batch. Default value is
@@ -115,6 +127,13 @@ type DB struct {
 	// Do not change concurrently with calls to Batch.
 	MaxBatchDelay time.Duration
 
+	// MaxPooledTxAge is the maximum time a read-only transaction is kept
+	// open for reuse by ViewPooled. Default value is copied from
+	// DefaultMaxPooledTxAge in Open.
+	//
+	// If <=0, disables pooling.
+	MaxPooledTxAge time.Duration
+
 	// AllocSize is the amount of space allocated when the database
 	// needs to create new pages. This is done to amortize the cost
 	// of truncate() and fsync() when growing the data file.
@@ -143,6 +162,8 @@ type DB struct {
 	batchMu sync.Mutex
 	batch   *batch
 
+	readPool readPool
+
 	rwlock   sync.Mutex   // Allows only one writer at a time.
 	metalock sync.Mutex   // Protects meta page access.
 	mmaplock sync.RWMutex // Protects mmap access during remapping.
@@ -159,6 +180,12 @@ type DB struct {
 	// Memory only mode
 	// When true, never writes anything to disk
 	memOnly bool
+
+	// The amount by which the mmap grows once it has reached that size.
+	mmapGrowStep int
+
+	// The largest value that may be written, in bytes.
+	maxValueSize int
 }
 
 // Path returns the path to currently open database file.
@@ -193,10 +220,21 @@ func Open(path string, mode os.FileMode, options *Options) (*DB, error) {
 	db.NoFreelistSync = options.NoFreelistSync
 	db.FreelistType = options.FreelistType
 	db.memOnly = options.MemOnly
+	db.mmapGrowStep = options.MmapGrowStep
+	if db.maxValueSize = options.MaxValueSize; db.maxValueSize <= 0 || db.maxValueSize > MaxValueSize {
+		db.maxValueSize = MaxValueSize
+	}
+
+	// Page sizes that are powers of two are either a multiple or a fraction
+	// of the OS page size, so pages never straddle OS pages unevenly.
+	if options.PageSize != 0 && (options.PageSize < minPageSize || options.PageSize&(options.PageSize-1) != 0) {
+		return nil, ErrInvalidPageSize
+	}
 
 	// Set default values for later DB operations.
 	db.MaxBatchSize = DefaultMaxBatchSize
 	db.MaxBatchDelay = DefaultMaxBatchDelay
+	db.MaxPooledTxAge = DefaultMaxPooledTxAge
 	db.AllocSize = DefaultAllocSize
 
 	flag := os.O_RDWR
@@ -217,8 +255,8 @@ func Open(path string, mode os.FileMode, options *Options) (*DB, error) {
 			_ = db.close()
 			return nil, err
 		}
+		db.path = db.file.Name()
 	}
-	db.path = db.file.Name()
 
 	// Lock file so that other processes using Bolt in read-write mode cannot
 	// use the database  at the same time. This would cause corruption since
@@ -277,6 +315,9 @@ func Open(path string, mode os.FileMode, options *Options) (*DB, error) {
 		// TODO: scan for next page
 		if bw, err := db.file.ReadAt(buf[:], 0); err == nil && bw == len(buf) {
 			if m := db.pageInBuffer(buf[:], 0).meta(); m.validate() == nil {
+				if options.PageSize != 0 && options.PageSize != int(m.pageSize) {
+					log.Printf("bolt.Open(): ignoring page size %d, database uses %d", options.PageSize, m.pageSize)
+				}
 				db.pageSize = int(m.pageSize)
 			}
 		} else {
@@ -321,6 +362,23 @@ func Open(path string, mode os.FileMode, options *Options) (*DB, error) {
 	return db, nil
 }
 
+// OpenInMemory creates a database that is held entirely in memory instead of
+// in a file. It supports the same transactions and buckets as a file-backed
+// database and honors the same options, except that ReadOnly is ignored and
+// nothing is synced or locked. Its contents are lost when it is closed, so it
+// is intended for tests and ephemeral caches. Passing in nil options will
+// cause Bolt to use the default options.
+func OpenInMemory(options *Options) (*DB, error) {
+	o := *DefaultOptions
+	if options != nil {
+		o = *options
+	}
+	o.MemOnly = true
+	o.ReadOnly = false
+
+	return Open("", 0600, &o)
+}
+
 // loadFreelist reads the freelist if it is synced, or reconstructs it
 // by scanning the DB if it is not synced. It assumes there are no
 // concurrent accesses being made to the freelist.
@@ -350,11 +408,22 @@ func (db *DB) mmap(minsz int) error {
 
 	if db.memOnly {
 		if minsz > len(db.dataref) {
-			newmem := make([]byte, minsz)
+			// Grow the arena in the same steps as a file-backed mmap.
+			size, err := db.mmapSize(minsz)
+			if err != nil {
+				return err
+			}
+
+			// Dereference all references to the old arena before replacing it.
+			if db.rwtx != nil {
+				db.rwtx.root.dereference()
+			}
+
+			newmem := make([]byte, size)
 			copy(newmem, db.dataref)
 			db.dataref = newmem
 			db.data = (*[maxMapSize]byte)(unsafe.Pointer(&db.dataref[0]))
-			db.datasz = minsz
+			db.datasz = size
 		}
 	} else {
 		info, err := db.file.Stat()
@@ -408,6 +477,12 @@ func (db *DB) mmap(minsz int) error {
 
 // munmap unmaps the data file from memory.
 func (db *DB) munmap() error {
+	if db.memOnly {
+		db.dataref = nil
+		db.data = nil
+		db.datasz = 0
+		return nil
+	}
 	if err := munmap(db); err != nil {
 		return fmt.Errorf("unmap error: " + err.Error())
 	}
@@ -415,13 +490,22 @@ func (db *DB) munmap() error {
 }
 
 // mmapSize determines the appropriate size for the mmap given the current size
-// of the database. The minimum size is 32KB and doubles until it reaches 1GB.
-// Returns an error if the new mmap size is greater than the max allowed.
+// of the database. The minimum size is 32KB and doubles until it reaches the
+// grow step, 1GB unless set by Options.MmapGrowStep, and then grows by the
+// step. Returns an error if the new mmap size is greater than the max allowed.
 func (db *DB) mmapSize(size int) (int, error) {
-	// Double the size from 32KB until 1GB.
+	step := maxMmapStep
+	if db.mmapGrowStep > 0 {
+		step = db.mmapGrowStep
+	}
+
+	// Double the size from 32KB until the grow step.
 	for i := uint(15); i <= 30; i++ {
 		if size <= 1<<i {
-			return 1 << i, nil
+			if 1<<i <= step {
+				return 1 << i, nil
+			}
+			break
 		}
 	}
 
@@ -430,14 +514,14 @@ func (db *DB) mmapSize(size int) (int, error) {
 		return 0, fmt.Errorf("mmap too large")
 	}
 
-	// If larger than 1GB then grow by 1GB at a time.
+	// If larger than the grow step then grow by the step at a time.
 	sz := int64(size)
-	if remainder := sz % int64(maxMmapStep); remainder > 0 {
-		sz += int64(maxMmapStep) - remainder
+	if remainder := sz % int64(step); remainder > 0 {
+		sz += int64(step) - remainder
 	}
 
 	// Ensure that the mmap size is a multiple of the page size.
-	// This should always be true since we're incrementing in MBs.
+	// This is true for the default step since we're incrementing in MBs.
 	pageSize := int64(db.pageSize)
 	if (sz % pageSize) != 0 {
 		sz = ((sz / pageSize) + 1) * pageSize
@@ -487,6 +571,7 @@ func (db *DB) init() error {
 	if db.memOnly {
 		db.dataref = make([]byte, len(buf))
 		db.data = (*[maxMapSize]byte)(unsafe.Pointer(&db.dataref[0]))
+		db.datasz = len(buf)
 	}
 	// Write the buffer to our data file.
 	if _, err := db.ops.writeAt(buf, 0); err != nil {
@@ -505,6 +590,8 @@ func (db *DB) init() error {
 // It will block waiting for any open transactions to finish
 // before closing the database and returning.
 func (db *DB) Close() error {
+	db.flushReadPool()
+
 	db.rwlock.Lock()
 	defer db.rwlock.Unlock()
 
@@ -767,6 +854,142 @@ func (db *DB) View(fn func(*Tx) error) error {
 	return t.Rollback()
 }
 
+// ViewPooled executes a function within the context of a read-only
+// transaction, like View, but reuses an already open transaction when one is
+// idle in the database's read pool instead of beginning a new one.
+//
+// Pooled transactions are recycled once they are older than
+// DB.MaxPooledTxAge, so a function passed to ViewPooled may observe a snapshot
+// that is up to that old and miss the most recent commits. Use View when a
+// read must see every write committed before it started.
+//
+// A writer that needs to grow the database rolls back idle pooled
+// transactions so the data file can be remapped; transactions in use at that
+// time are rolled back when their function returns. The function must not
+// retain the Tx or anything obtained from it after it returns.
+//
+// If DB.MaxPooledTxAge is <=0, ViewPooled behaves like View.
+func (db *DB) ViewPooled(fn func(*Tx) error) error {
+	if db.MaxPooledTxAge <= 0 {
+		return db.View(fn)
+	}
+
+	ptx, err := db.acquirePooledTx()
+	if err != nil {
+		return err
+	}
+
+	// Make sure the transaction rolls back in the event of a panic.
+	released := false
+	defer func() {
+		if !released {
+			ptx.tx.managed = false
+			db.discardPooledTx(ptx)
+		}
+	}()
+
+	// Mark as a managed tx so that the inner function cannot manually rollback.
+	ptx.tx.managed = true
+	err = fn(ptx.tx)
+	ptx.tx.managed = false
+
+	released = true
+	db.releasePooledTx(ptx)
+	return err
+}
+
+// pooledTx is a read-only transaction kept open for reuse by ViewPooled.
+type pooledTx struct {
+	tx      *Tx
+	timer   *time.Timer
+	gen     uint64
+	expired bool // protected by readPool.mu
+}
+
+// readPool holds idle pooled transactions.
+type readPool struct {
+	mu   sync.Mutex
+	idle []*pooledTx
+	gen  uint64 // incremented by flushReadPool
+}
+
+// acquirePooledTx returns an idle pooled transaction or begins a new one.
+func (db *DB) acquirePooledTx() (*pooledTx, error) {
+	db.readPool.mu.Lock()
+	if n := len(db.readPool.idle); n > 0 {
+		ptx := db.readPool.idle[n-1]
+		db.readPool.idle[n-1] = nil
+		db.readPool.idle = db.readPool.idle[:n-1]
+		db.readPool.mu.Unlock()
+		return ptx, nil
+	}
+	gen := db.readPool.gen
+	db.readPool.mu.Unlock()
+
+	tx, err := db.Begin(false)
+	if err != nil {
+		return nil, err
+	}
+	ptx := &pooledTx{tx: tx, gen: gen}
+	ptx.timer = time.AfterFunc(db.MaxPooledTxAge, func() { db.expirePooledTx(ptx) })
+	return ptx, nil
+}
+
+// releasePooledTx returns a transaction to the pool, or rolls it back if it
+// has expired or the pool has been flushed since it began.
+func (db *DB) releasePooledTx(ptx *pooledTx) {
+	db.readPool.mu.Lock()
+	if ptx.expired || ptx.gen != db.readPool.gen {
+		db.readPool.mu.Unlock()
+		db.discardPooledTx(ptx)
+		return
+	}
+	db.readPool.idle = append(db.readPool.idle, ptx)
+	db.readPool.mu.Unlock()
+}
+
+// expirePooledTx marks a transaction as expired and rolls it back if it is
+// idle. A transaction that is in use is rolled back when it is released.
+func (db *DB) expirePooledTx(ptx *pooledTx) {
+	db.readPool.mu.Lock()
+	ptx.expired = true
+	for i, p := range db.readPool.idle {
+		if p == ptx {
+			db.readPool.idle = append(db.readPool.idle[:i], db.readPool.idle[i+1:]...)
+			db.readPool.mu.Unlock()
+			_ = ptx.tx.Rollback()
+			return
+		}
+	}
+	db.readPool.mu.Unlock()
+}
+
+// discardPooledTx stops a transaction's expiry timer and rolls it back.
+func (db *DB) discardPooledTx(ptx *pooledTx) {
+	ptx.timer.Stop()
+	db.readPool.mu.Lock()
+	ptx.expired = true
+	db.readPool.mu.Unlock()
+	if ptx.tx.db != nil {
+		_ = ptx.tx.Rollback()
+	}
+}
+
+// flushReadPool rolls back all idle pooled transactions and prevents those
+// currently in use from being pooled again, so that the caller can acquire
+// the mmap lock.
+func (db *DB) flushReadPool() {
+	db.readPool.mu.Lock()
+	idle := db.readPool.idle
+	db.readPool.idle = nil
+	db.readPool.gen++
+	db.readPool.mu.Unlock()
+
+	for _, ptx := range idle {
+		db.discardPooledTx(ptx)
+	}
+}
+
 // Batch calls fn as part of a batch. It behaves similar to Update,
 // except:
 //
@@ -907,6 +1130,57 @@ func (db *DB) Sync() error {
 	}
 }
 
+// Backup writes a consistent copy of the database to w, returning the number
+// of bytes written. The copy is made within a single read-only transaction,
+// so it is safe to continue using the database while a backup is in progress,
+// e.g. when serving a live backup over HTTP.
+//
+// The read transaction is held for the duration of the backup, which prevents
+// pages freed by concurrent writers from being reclaimed until it completes.
+// Long-running backups of a busy database can therefore grow the file.
+func (db *DB) Backup(w io.Writer) (n int64, err error) {
+	err = db.View(func(tx *Tx) error {
+		n, err = tx.WriteTo(w)
+		return err
+	})
+	return n, err
+}
+
+// Check runs the consistency checks of Tx.Check within a read-only
+// transaction, returning every problem found rather than stopping at the
+// first. Each error describes the page it concerns. An empty result means
+// the database is consistent.
+func (db *DB) Check() []error {
+	var errs []error
+	if err := db.View(func(tx *Tx) error {
+		for err := range tx.Check() {
+			errs = append(errs, err)
+		}
+		return nil
+	}); err != nil {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+// FreelistStats reports the number of free pages and of pages pending release
+// on the freelist, along with an estimate of the bytes a compaction would
+// reclaim: the size of those pages. It only inspects the freelist, so it is
+// cheap to call. Figures are as of the last closed transaction.
+//
+// A read-only database loads its freelist on first use, which requires a
+// scan of the database if the freelist was not synced to disk.
+func (db *DB) FreelistStats() (freePages, pendingPages int, reclaimableBytes uint64) {
+	if db.readOnly {
+		db.loadFreelist()
+	}
+
+	db.statlock.RLock()
+	defer db.statlock.RUnlock()
+	freePages, pendingPages = db.stats.FreePageN, db.stats.PendingPageN
+	return freePages, pendingPages, uint64(freePages+pendingPages) * uint64(db.pageSize)
+}
+
 // Stats retrieves ongoing performance stats for the database.
 // This is only updated when a transaction closes.
 func (db *DB) Stats() Stats {
@@ -977,6 +1251,7 @@ func (db *DB) allocate(txid txid, count int) (*page, error) {
 	p.id = db.rwtx.meta.pgid
 	var minsz = int((p.id+pgid(count))+1) * db.pageSize
 	if minsz >= db.datasz {
+		db.flushReadPool()
 		if err := db.mmap(minsz); err != nil {
 			return nil, fmt.Errorf("mmap allocate error: %s", err)
 		}
@@ -1086,6 +1361,7 @@ type Options struct {
 	ReadOnly bool
 
 	// Sets the DB.MmapFlags flag before memory mapping the file.
+	// See DB.MmapFlags for platform differences.
 	MmapFlags int
 
 	// InitialMmapSize is the initial mmap size of the database
@@ -1098,7 +1374,20 @@ type Options struct {
 	// it takes no effect.
 	InitialMmapSize int
 
-	// PageSize overrides the default OS page size.
+	// MmapGrowStep caps how much the mmap grows at once. The mmap doubles
+	// in size from 32KB until it reaches MmapGrowStep bytes, and from then
+	// on grows by MmapGrowStep at a time, rounded up to the page size.
+	// Smaller steps reserve less address space but remap more often, and
+	// each remap waits for all open read transactions. InitialMmapSize is
+	// rounded up by the same rule.
+	//
+	// If <=0, the step is 1GB.
+	MmapGrowStep int
+
+	// PageSize sets the page size of new databases, overriding the default OS
+	// page size. It must be a power of two of at least 1KB. Existing databases
+	// keep the page size they were created with, and a PageSize that differs
+	// from it is ignored with a logged warning.
 	PageSize int
 
 	// NoSync sets the initial value of DB.NoSync. Normally this can just be
@@ -1112,6 +1401,13 @@ type Options struct {
 
 	// Open database in memory-only mode.
 	MemOnly bool
+
+	// MaxValueSize limits the size of values written to the database, in
+	// bytes. Writes of larger values fail with a *ValueTooLargeError.
+	//
+	// If <=0, or larger than the MaxValueSize constant, the limit is the
+	// MaxValueSize constant.
+	MaxValueSize int
 }
 
 // DefaultOptions represent the options used if nil options are passed into Open().
@@ -0,0 +1,28 @@
+package bbolt
+
+import "testing"
+
+// Ensure that the mmap doubles until the grow step and then grows by it.
+func TestDB_mmapSize(t *testing.T) {
+	for _, tt := range []struct {
+		step, size, expected int
+	}{
+		{0, 0, 1 << 15},
+		{0, 1<<15 + 1, 1 << 16},
+		{0, 1<<30 - 1, 1 << 30},
+		{0, 1<<30 + 1, 2 << 30},
+		{1 << 26, 1<<26 - 1, 1 << 26},
+		{1 << 26, 1<<26 + 1, 2 << 26},
+		{1 << 26, 100 << 26, 100 << 26},
+		{1 << 26, 100<<26 + 1, 101 << 26},
+		{10000, 1000, 12288},
+		{10000, 20000, 20480},
+	} {
+		db := &DB{pageSize: 4096, mmapGrowStep: tt.step}
+		if sz, err := db.mmapSize(tt.size); err != nil {
+			t.Fatal(err)
+		} else if sz != tt.expected {
+			t.Errorf("mmapSize(%d) with step %d: expected %d, got %d", tt.size, tt.step, tt.expected, sz)
+		}
+	}
+}
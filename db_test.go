@@ -13,6 +13,7 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -487,6 +488,13 @@ func TestDB_Open_ReadOnly(t *testing.T) {
 		if !bytes.Equal(value, []byte("bar")) {
 			t.Fatal("expect value 'bar', got", value)
 		}
+
+		// Pages can be inspected without a loaded freelist.
+		if p, err := tx.Page(0); err != nil {
+			t.Fatal(err)
+		} else if p.Type != "meta" {
+			t.Fatalf("unexpected page type: %s", p.Type)
+		}
 		return nil
 	}); err != nil {
 		t.Fatal(err)
@@ -496,12 +504,53 @@ func TestDB_Open_ReadOnly(t *testing.T) {
 	if _, err := readOnlyDB.Begin(true); err != bolt.ErrDatabaseReadOnly {
 		t.Fatalf("unexpected error: %s", err)
 	}
+	if err := readOnlyDB.Update(func(tx *bolt.Tx) error {
+		t.Fatal("expected Update not to run")
+		return nil
+	}); err != bolt.ErrDatabaseReadOnly {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// Read-only handles share the file lock, so another can open alongside.
+	otherDB, err := bolt.Open(f, 0666, &bolt.Options{ReadOnly: true, Timeout: 100 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := otherDB.View(func(tx *bolt.Tx) error {
+		if value := tx.Bucket([]byte("widgets")).Get([]byte("foo")); !bytes.Equal(value, []byte("bar")) {
+			t.Fatal("expect value 'bar', got", value)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := otherDB.Close(); err != nil {
+		t.Fatal(err)
+	}
 
 	if err := readOnlyDB.Close(); err != nil {
 		t.Fatal(err)
 	}
 }
 
+// Ensure that Open gives up with ErrTimeout while another handle holds the lock.
+func TestOpen_Timeout(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	const timeout = 100 * time.Millisecond
+	for _, readOnly := range []bool{false, true} {
+		start := time.Now()
+		_, err := bolt.Open(db.f, 0666, &bolt.Options{ReadOnly: readOnly, Timeout: timeout})
+		if err != bolt.ErrTimeout {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if elapsed := time.Since(start); elapsed > 10*timeout {
+			t.Fatalf("unexpected wait: %s", elapsed)
+		}
+	}
+}
+
 // TestOpen_BigPage checks the database uses bigger pages when
 // changing PageSize.
 func TestOpen_BigPage(t *testing.T) {
@@ -518,6 +567,72 @@ func TestOpen_BigPage(t *testing.T) {
 	}
 }
 
+// Ensure that a database created with a custom page size keeps it when
+// reopened, and that invalid page sizes are rejected.
+func TestOpen_PageSize(t *testing.T) {
+	const pageSize = 16 * 1024
+	db := MustOpenWithOption(&bolt.Options{PageSize: pageSize})
+	defer db.MustClose()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 1000; i++ {
+			if err := b.Put(u64tob(uint64(i)), make([]byte, 100)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.DB.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if sz := fileSize(db.f); sz%pageSize != 0 {
+		t.Fatalf("unexpected file size: %d", sz)
+	}
+
+	// A conflicting page size is ignored with a warning.
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	for _, o := range []*bolt.Options{nil, {PageSize: 4096}} {
+		reopened, err := bolt.Open(db.f, 0666, o)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sz := reopened.Info().PageSize; sz != pageSize {
+			t.Fatalf("unexpected page size: %d", sz)
+		}
+		if err := reopened.View(func(tx *bolt.Tx) error {
+			if n := tx.Bucket([]byte("widgets")).Stats().KeyN; n != 1000 {
+				t.Fatalf("unexpected key count: %d", n)
+			}
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if err := reopened.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !strings.Contains(buf.String(), "ignoring page size 4096") {
+		t.Fatalf("expected warning, got %q", buf.String())
+	}
+
+	for _, sz := range []int{512, 3000, 4095} {
+		if _, err := bolt.Open(tempfile(), 0666, &bolt.Options{PageSize: sz}); err != bolt.ErrInvalidPageSize {
+			t.Fatalf("page size %d: unexpected error: %v", sz, err)
+		}
+	}
+
+	db.MustReopen()
+}
+
 // TestOpen_RecoverFreeList tests opening the DB with free-list
 // write-out after no free list sync will recover the free list
 // and write it out.
@@ -586,6 +701,132 @@ func TestOpen_RecoverFreeList(t *testing.T) {
 	}
 }
 
+// Ensure that reopening a database written with NoFreelistSync rebuilds the
+// freelist from the pages that are unreachable.
+func TestOpen_NoFreelistSync_Reconstruct(t *testing.T) {
+	db := MustOpenWithOption(&bolt.Options{NoFreelistSync: true})
+	defer db.MustClose()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 1000; i++ {
+			if err := b.Put(u64tob(uint64(i)), make([]byte, 500)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		for i := 0; i < 1000; i += 2 {
+			if err := b.Delete(u64tob(uint64(i))); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	free, pending, _ := db.FreelistStats()
+	if free+pending == 0 {
+		t.Fatal("expected free pages")
+	}
+	if err := db.DB.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Pages pending at close are unreachable, so they are free on reopen.
+	db.MustReopen()
+	if f, p, _ := db.FreelistStats(); f != free+pending || p != 0 {
+		t.Fatalf("closed with %d free and %d pending pages, opened with %d free and %d pending", free, pending, f, p)
+	}
+	if errs := db.Check(); len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	// The rebuilt freelist is used for new writes instead of growing the file.
+	sz := fileSize(db.f)
+	if err := db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("widgets")).Put([]byte("foo"), make([]byte, 4000))
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if newSz := fileSize(db.f); newSz != sz {
+		t.Fatalf("file grew from %d to %d", sz, newSz)
+	}
+}
+
+// Ensure that an in-memory database supports reads and writes and can be
+// backed up to a file-backed database.
+func TestOpenInMemory(t *testing.T) {
+	pageSize := os.Getpagesize() * 2
+	db, err := bolt.OpenInMemory(&bolt.Options{PageSize: pageSize})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if db.Path() != "" {
+		t.Fatalf("unexpected path: %q", db.Path())
+	} else if sz := db.Info().PageSize; sz != pageSize {
+		t.Fatalf("unexpected page size: %d", sz)
+	}
+
+	// Write enough data to grow the arena several times.
+	value := make([]byte, 1000)
+	for i := 0; i < 10; i++ {
+		if err := db.Update(func(tx *bolt.Tx) error {
+			b, err := tx.CreateBucketIfNotExists([]byte("widgets"))
+			if err != nil {
+				return err
+			}
+			for j := 0; j < 1000; j++ {
+				if err := b.Put(u64tob(uint64(i*1000+j)), value); err != nil {
+					return err
+				}
+			}
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if errs := db.Check(); len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	var buf bytes.Buffer
+	if _, err := db.Backup(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	path := tempfile()
+	defer os.Remove(path)
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+	copied, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer copied.Close()
+
+	if err := copied.View(func(tx *bolt.Tx) error {
+		if n := tx.Bucket([]byte("widgets")).Stats().KeyN; n != 10000 {
+			t.Fatalf("unexpected key count: %d", n)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
 // Ensure that a database cannot open a transaction when it's not open.
 func TestDB_Begin_ErrDatabaseNotOpen(t *testing.T) {
 	var db bolt.DB
@@ -964,25 +1205,334 @@ func TestDB_View_Panic(t *testing.T) {
 			}
 		}()
 
-		if err := db.View(func(tx *bolt.Tx) error {
-			if tx.Bucket([]byte("widgets")) == nil {
-				t.Fatal("expected bucket")
+		if err := db.View(func(tx *bolt.Tx) error {
+			if tx.Bucket([]byte("widgets")) == nil {
+				t.Fatal("expected bucket")
+			}
+			panic("omg")
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	// Verify that we can still use read transactions.
+	if err := db.View(func(tx *bolt.Tx) error {
+		if tx.Bucket([]byte("widgets")) == nil {
+			t.Fatal("expected bucket")
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that ViewPooled reuses idle transactions until they expire.
+func TestDB_ViewPooled(t *testing.T) {
+	db := MustOpenWithOption(&bolt.Options{InitialMmapSize: 1 << 20})
+	defer db.MustClose()
+	db.MaxPooledTxAge = 500 * time.Millisecond
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b.Put([]byte("foo"), []byte("bar"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	var first *bolt.Tx
+	if err := db.ViewPooled(func(tx *bolt.Tx) error {
+		first = tx
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Commit a change; the pooled snapshot does not observe it.
+	if err := db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("widgets")).Put([]byte("foo"), []byte("baz"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.ViewPooled(func(tx *bolt.Tx) error {
+		if tx != first {
+			t.Fatal("expected pooled transaction to be reused")
+		}
+		if v := tx.Bucket([]byte("widgets")).Get([]byte("foo")); !bytes.Equal(v, []byte("bar")) {
+			t.Fatalf("unexpected value: %q", v)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Once expired, a new transaction sees the latest commit.
+	time.Sleep(time.Second)
+	if err := db.ViewPooled(func(tx *bolt.Tx) error {
+		if v := tx.Bucket([]byte("widgets")).Get([]byte("foo")); !bytes.Equal(v, []byte("baz")) {
+			t.Fatalf("unexpected value: %q", v)
+		}
+		first = tx
+		return errors.New("xxx")
+	}); err == nil || err.Error() != "xxx" {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// A writer that grows the database flushes idle transactions.
+	if err := db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("widgets")).Put([]byte("large"), make([]byte, 2<<20))
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.ViewPooled(func(tx *bolt.Tx) error {
+		if tx == first {
+			t.Fatal("expected a new transaction")
+		}
+		if v := tx.Bucket([]byte("widgets")).Get([]byte("large")); len(v) != 2<<20 {
+			t.Fatalf("unexpected value length: %d", len(v))
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure a pooled read transaction that panics does not hold open locks.
+func TestDB_ViewPooled_Panic(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	func() {
+		defer func() {
+			if r := recover(); r != nil {
+				t.Log("recover: view", r)
+			}
+		}()
+
+		if err := db.ViewPooled(func(tx *bolt.Tx) error {
+			panic("omg")
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	// Verify that the transaction was rolled back rather than pooled.
+	if n := db.Stats().OpenTxN; n != 0 {
+		t.Fatalf("unexpected open transactions: %d", n)
+	}
+}
+
+// Ensure that a backup written to a writer can be opened as a database.
+func TestDB_Backup(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b.Put([]byte("foo"), []byte("bar"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	n, err := db.Backup(&buf)
+	if err != nil {
+		t.Fatal(err)
+	} else if n != int64(buf.Len()) {
+		t.Fatalf("unexpected size: %d != %d", n, buf.Len())
+	}
+
+	path := tempfile()
+	defer os.Remove(path)
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	db2, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db2.Close()
+
+	if err := db2.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket([]byte("widgets")).Get([]byte("foo")); !bytes.Equal(v, []byte("bar")) {
+			t.Fatalf("unexpected value: %v", v)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that Check reports every inconsistent page.
+func TestDB_Check(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 1000; i++ {
+			if err := b.Put([]byte(fmt.Sprintf("%04d", i)), make([]byte, 100)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if errs := db.Check(); len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	// Find the leaf pages of the bucket, which unlike the root's hold more
+	// than one key.
+	var leaves []int
+	if err := db.View(func(tx *bolt.Tx) error {
+		for id := 2; ; id++ {
+			p, err := tx.Page(id)
+			if err != nil {
+				t.Fatal(err)
+			} else if p == nil {
+				break
+			}
+			if p.Type == "leaf" && p.Count > 1 {
+				leaves = append(leaves, id)
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(leaves) < 2 {
+		t.Fatalf("expected multiple leaf pages: %v", leaves)
+	}
+
+	// Clear the flags of two leaf pages in a copy of the database.
+	path := tempfile()
+	defer os.Remove(path)
+	if err := db.View(func(tx *bolt.Tx) error {
+		return tx.CopyFile(path, 0600)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.OpenFile(path, os.O_RDWR, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range leaves[:2] {
+		if _, err := f.WriteAt([]byte{0, 0}, int64(id*pageSize+8)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	corrupt, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer corrupt.Close()
+
+	errs := corrupt.Check()
+	if len(errs) != 2 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	for i, err := range errs {
+		if exp := fmt.Sprintf("page %d: invalid type: unknown<00>", leaves[i]); err.Error() != exp {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+}
+
+// Ensure that Check reports a page that is neither reachable nor free as an
+// UnreachablePageError.
+func TestDB_Check_Unreachable(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+	for i := 0; i < 2; i++ {
+		if err := db.Update(func(tx *bolt.Tx) error {
+			b, err := tx.CreateBucketIfNotExists([]byte("widgets"))
+			if err != nil {
+				t.Fatal(err)
 			}
-			panic("omg")
+			return b.Put([]byte("foo"), make([]byte, 100))
 		}); err != nil {
 			t.Fatal(err)
 		}
-	}()
+	}
 
-	// Verify that we can still use read transactions.
+	// Find the freelist page of the active meta page.
+	var freelist *bolt.PageInfo
 	if err := db.View(func(tx *bolt.Tx) error {
-		if tx.Bucket([]byte("widgets")) == nil {
-			t.Fatal("expected bucket")
+		for id := 0; id < 2; id++ {
+			m, err := tx.Page(id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if m.Meta.TxID == tx.ID() {
+				if freelist, err = tx.Page(m.Meta.Freelist); err != nil {
+					t.Fatal(err)
+				}
+			}
 		}
 		return nil
 	}); err != nil {
 		t.Fatal(err)
 	}
+	if freelist == nil || freelist.Type != "freelist" || freelist.Count == 0 {
+		t.Fatalf("expected free pages: %+v", freelist)
+	}
+
+	// Drop the last id from the freelist in a copy of the database, which
+	// leaks that page.
+	path := tempfile()
+	defer os.Remove(path)
+	if err := db.View(func(tx *bolt.Tx) error {
+		return tx.CopyFile(path, 0600)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.OpenFile(path, os.O_RDWR, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pageSize := db.Info().PageSize
+	buf := make([]byte, 8)
+	if _, err := f.ReadAt(buf, int64(freelist.ID*pageSize+24+8*(freelist.Count-1))); err != nil {
+		t.Fatal(err)
+	}
+	leaked := int(binary.LittleEndian.Uint64(buf))
+	binary.LittleEndian.PutUint16(buf, uint16(freelist.Count-1))
+	if _, err := f.WriteAt(buf[:2], int64(freelist.ID*pageSize+10)); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	corrupt, err := bolt.Open(path, 0600, &bolt.Options{ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer corrupt.Close()
+
+	errs := corrupt.Check()
+	if len(errs) != 1 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	if err, ok := errs[0].(*bolt.UnreachablePageError); !ok || err.ID != leaked {
+		t.Fatalf("unexpected error: %#v", errs[0])
+	} else if exp := fmt.Sprintf("page %d: unreachable unfreed", leaked); err.Error() != exp {
+		t.Fatalf("unexpected error: %s", err)
+	}
 }
 
 // Ensure that DB stats can be returned.
@@ -1006,6 +1556,42 @@ func TestDB_Stats(t *testing.T) {
 	}
 }
 
+// Ensure that freelist stats reflect pages freed by deletions.
+func TestDB_FreelistStats(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 1000; i++ {
+			if err := b.Put([]byte(fmt.Sprintf("%04d", i)), make([]byte, 100)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		return tx.DeleteBucket([]byte("widgets"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	free, pending, reclaimable := db.FreelistStats()
+	stats := db.Stats()
+	if free != stats.FreePageN || pending != stats.PendingPageN {
+		t.Fatalf("unexpected counts: %d/%d != %d/%d", free, pending, stats.FreePageN, stats.PendingPageN)
+	} else if free+pending < 25 {
+		t.Fatalf("expected deleted pages on the freelist: %d/%d", free, pending)
+	} else if reclaimable != uint64((free+pending)*db.Info().PageSize) {
+		t.Fatalf("unexpected reclaimable bytes: %d", reclaimable)
+	}
+}
+
 // Ensure that database pages are in expected order and type.
 func TestDB_Consistency(t *testing.T) {
 	db := MustOpenDB()
@@ -1074,6 +1660,280 @@ func TestDB_Consistency(t *testing.T) {
 	}
 }
 
+// Ensure that Tx.Page describes the elements of branch and leaf pages.
+func TestTx_Page_Elements(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	// Write enough data that the bucket is not stored inline.
+	value := bytes.Repeat([]byte("x"), 100)
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 20; i++ {
+			if err := b.Put([]byte{byte('a' + i)}, value); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	buf, err := ioutil.ReadFile(db.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+	pageSize := db.Info().PageSize
+
+	if err := db.View(func(tx *bolt.Tx) error {
+		var root, leaf *bolt.PageInfo
+		for id := 2; ; id++ {
+			p, err := tx.Page(id)
+			if err != nil {
+				t.Fatal(err)
+			} else if p == nil {
+				break
+			} else if p.Type != "leaf" {
+				continue
+			}
+			if p.Count == 1 {
+				root = p
+			} else {
+				leaf = p
+			}
+		}
+
+		if root == nil || leaf == nil {
+			t.Fatal("expected root and bucket leaf pages")
+		}
+		if e := root.Elements[0]; string(e.Key) != "widgets" || !e.IsBucket {
+			t.Fatalf("unexpected root element: %+v", e)
+		}
+
+		if len(leaf.Elements) != 20 {
+			t.Fatalf("unexpected element count: %d", len(leaf.Elements))
+		}
+		for i, e := range leaf.Elements {
+			if !bytes.Equal(e.Key, []byte{byte('a' + i)}) {
+				t.Fatalf("unexpected key at %d: %q", i, e.Key)
+			} else if e.ValueSize != len(value) || e.IsBucket {
+				t.Fatalf("unexpected element at %d: %+v", i, e)
+			}
+
+			// The key and value are found at the reported offset.
+			off := leaf.ID*pageSize + e.Offset
+			if !bytes.Equal(buf[off:off+len(e.Key)], e.Key) {
+				t.Fatalf("unexpected key data at %d", i)
+			} else if !bytes.Equal(buf[off+len(e.Key):off+len(e.Key)+e.ValueSize], value) {
+				t.Fatalf("unexpected value data at %d", i)
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that Tx.Page decodes both meta pages.
+func TestTx_Page_Meta(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucket([]byte("widgets"))
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.View(func(tx *bolt.Tx) error {
+		m0, err := tx.Page(0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m1, err := tx.Page(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m0.Meta == nil || m1.Meta == nil {
+			t.Fatal("expected meta info")
+		}
+		if !m0.Meta.Valid || !m1.Meta.Valid {
+			t.Fatal("expected valid meta pages")
+		} else if m0.Meta.PageSize != db.Info().PageSize {
+			t.Fatalf("unexpected page size: %d", m0.Meta.PageSize)
+		} else if m0.Meta.Checksum == 0 {
+			t.Fatal("expected checksum")
+		}
+
+		// The most recent meta page belongs to this transaction.
+		active := m0.Meta
+		if m1.Meta.TxID > active.TxID {
+			active = m1.Meta
+		}
+		if active.TxID != tx.ID() {
+			t.Fatalf("unexpected active txid: %d != %d", active.TxID, tx.ID())
+		} else if active.PageN != int(tx.Size())/db.Info().PageSize {
+			t.Fatalf("unexpected high water mark: %d", active.PageN)
+		}
+
+		if p, _ := tx.Page(2); p.Meta != nil {
+			t.Fatal("unexpected meta info")
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that Tx.Page reports the continuation pages of a large value as
+// overflow rather than decoding them.
+func TestTx_Page_Overflow(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	// Fill the value with the leaf page flag, so that the continuation pages
+	// look like page headers if they are mistaken for them.
+	pageSize := db.Info().PageSize
+	value := bytes.Repeat([]byte{0x02}, 4*pageSize)
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b.Put([]byte("foo"), value)
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.View(func(tx *bolt.Tx) error {
+		var owner *bolt.PageInfo
+		var overflow []int
+		for id := 0; ; id++ {
+			p, err := tx.Page(id)
+			if err != nil {
+				t.Fatal(err)
+			} else if p == nil {
+				break
+			}
+			if p.OverflowCount > 0 {
+				owner = p
+			} else if p.Type == "overflow" {
+				overflow = append(overflow, id)
+				if p.Count != 0 || p.Elements != nil {
+					t.Fatalf("unexpected overflow page %d: %+v", id, p)
+				}
+			}
+		}
+
+		if owner == nil || owner.Type != "leaf" || len(owner.Elements) != 1 {
+			t.Fatalf("unexpected owner page: %+v", owner)
+		} else if owner.Elements[0].ValueSize != len(value) {
+			t.Fatalf("unexpected value size: %d", owner.Elements[0].ValueSize)
+		}
+		if len(overflow) != owner.OverflowCount {
+			t.Fatalf("unexpected overflow pages: %v", overflow)
+		}
+		for i, id := range overflow {
+			if id != owner.ID+1+i {
+				t.Fatalf("unexpected overflow pages: %v", overflow)
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that Tx.Page returns an error for a page whose elements do not fit
+// within it.
+func TestTx_Page_Corrupt(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 20; i++ {
+			if err := b.Put([]byte{byte('a' + i)}, bytes.Repeat([]byte("x"), 100)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	var leaf int
+	if err := db.View(func(tx *bolt.Tx) error {
+		for id := 2; ; id++ {
+			p, err := tx.Page(id)
+			if err != nil {
+				t.Fatal(err)
+			} else if p == nil {
+				break
+			}
+			if p.Type == "leaf" && p.Count > 1 {
+				leaf = id
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if leaf == 0 {
+		t.Fatal("expected a leaf page")
+	}
+	pageSize := db.Info().PageSize
+
+	for _, tt := range []struct {
+		name   string
+		offset int
+		data   []byte
+		exp    string
+	}{
+		{"count", 10, []byte{0xff, 0xff}, "element count out of bounds: 65535"},
+		{"prefix", 16, []byte{0xff, 0xff, 0xff, 0x00}, "key prefix out of bounds"},
+		{"element", 28, []byte{0x00, 0xff, 0xff, 0xff}, "element 0 out of bounds"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			path := tempfile()
+			defer os.Remove(path)
+			if err := db.View(func(tx *bolt.Tx) error {
+				return tx.CopyFile(path, 0600)
+			}); err != nil {
+				t.Fatal(err)
+			}
+			f, err := os.OpenFile(path, os.O_RDWR, 0600)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := f.WriteAt(tt.data, int64(leaf*pageSize+tt.offset)); err != nil {
+				t.Fatal(err)
+			}
+			if err := f.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			corrupt, err := bolt.Open(path, 0600, &bolt.Options{ReadOnly: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer corrupt.Close()
+
+			if err := corrupt.View(func(tx *bolt.Tx) error {
+				_, err := tx.Page(leaf)
+				return err
+			}); err == nil || err.Error() != fmt.Sprintf("page %d: %s", leaf, tt.exp) {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
 // Ensure that DB stats can be subtracted from one another.
 func TestDBStats_Sub(t *testing.T) {
 	var a, b bolt.Stats
@@ -1555,6 +2415,54 @@ func BenchmarkDBBatchManual10x100(b *testing.B) {
 	validateBatchBench(b, db)
 }
 
+func BenchmarkDBView(b *testing.B) {
+	benchmarkDBView(b, func(db *DB) func(func(*bolt.Tx) error) error { return db.View })
+}
+
+func BenchmarkDBViewPooled(b *testing.B) {
+	benchmarkDBView(b, func(db *DB) func(func(*bolt.Tx) error) error { return db.ViewPooled })
+}
+
+func benchmarkDBView(b *testing.B, view func(*DB) func(func(*bolt.Tx) error) error) {
+	db := MustOpenDB()
+	defer db.MustClose()
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("bench"))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte("foo"), []byte("bar"))
+	}); err != nil {
+		b.Fatal(err)
+	}
+	fn := view(db)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		start := make(chan struct{})
+		var wg sync.WaitGroup
+
+		for round := 0; round < 1000; round++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				<-start
+
+				if err := fn(func(tx *bolt.Tx) error {
+					if v := tx.Bucket([]byte("bench")).Get([]byte("foo")); v == nil {
+						return errors.New("missing value")
+					}
+					return nil
+				}); err != nil {
+					b.Error(err)
+				}
+			}()
+		}
+		close(start)
+		wg.Wait()
+	}
+}
+
 func validateBatchBench(b *testing.B, db *DB) {
 	var rollback = errors.New("sentinel error to cause rollback")
 	validate := func(tx *bolt.Tx) error {
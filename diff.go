@@ -0,0 +1,121 @@
+package bbolt
+
+import "bytes"
+
+// ChangeKind describes how a key differs between two buckets compared by
+// DiffBuckets.
+type ChangeKind int
+
+const (
+	// ChangeAdded is reported for a key that exists only in the second bucket.
+	ChangeAdded ChangeKind = iota + 1
+
+	// ChangeRemoved is reported for a key that exists only in the first bucket.
+	ChangeRemoved
+
+	// ChangeModified is reported for a key whose value differs between the
+	// two buckets.
+	ChangeModified
+
+	// ChangeBucketAdded is reported for a key that refers to a nested bucket
+	// only in the second bucket. If the key holds a value in the first
+	// bucket, that value is passed as aVal.
+	ChangeBucketAdded
+
+	// ChangeBucketRemoved is reported for a key that refers to a nested
+	// bucket only in the first bucket. If the key holds a value in the second
+	// bucket, that value is passed as bVal.
+	ChangeBucketRemoved
+
+	// ChangeBucketBoth is reported for a key that refers to a nested bucket
+	// in both buckets. The nested buckets are not compared, so it is reported
+	// even when their contents are identical.
+	ChangeBucketBoth
+)
+
+// String returns a short name for the change.
+func (c ChangeKind) String() string {
+	switch c {
+	case ChangeAdded:
+		return "added"
+	case ChangeRemoved:
+		return "removed"
+	case ChangeModified:
+		return "modified"
+	case ChangeBucketAdded:
+		return "bucket added"
+	case ChangeBucketRemoved:
+		return "bucket removed"
+	case ChangeBucketBoth:
+		return "bucket"
+	default:
+		return "unknown"
+	}
+}
+
+// DiffBuckets compares the keys of a and b in a single sorted pass, calling fn
+// for each key that differs, and for each key that refers to a nested bucket.
+// aVal and bVal hold the value of the key in a and b respectively, and are nil
+// where the key is absent or refers to a nested bucket.
+//
+// Keys referring to nested buckets are reported as ChangeBucketAdded,
+// ChangeBucketRemoved or ChangeBucketBoth, depending on which side holds the
+// bucket, rather than descended into. A pair of nested buckets is reported as
+// ChangeBucketBoth without being compared; callers wanting a recursive
+// comparison can call DiffBuckets again on the nested buckets.
+//
+// The values passed to fn are only valid for the life of the transactions
+// owning a and b. Returning an error from fn stops the comparison and returns
+// that error.
+func DiffBuckets(a, b Bucketish, fn func(key []byte, change ChangeKind, aVal, bVal []byte) error) error {
+	ac, bc := a.Cursor(), b.Cursor()
+	ak, av := ac.First()
+	bk, bv := bc.First()
+
+	for ak != nil || bk != nil {
+		var cmp int
+		switch {
+		case ak == nil:
+			cmp = 1
+		case bk == nil:
+			cmp = -1
+		default:
+			cmp = bytes.Compare(ak, bk)
+		}
+
+		var err error
+		switch {
+		case cmp < 0:
+			if av == nil {
+				err = fn(ak, ChangeBucketRemoved, nil, nil)
+			} else {
+				err = fn(ak, ChangeRemoved, av, nil)
+			}
+			ak, av = ac.Next()
+		case cmp > 0:
+			if bv == nil {
+				err = fn(bk, ChangeBucketAdded, nil, nil)
+			} else {
+				err = fn(bk, ChangeAdded, nil, bv)
+			}
+			bk, bv = bc.Next()
+		default:
+			if av == nil && bv == nil {
+				err = fn(ak, ChangeBucketBoth, nil, nil)
+			} else if av == nil {
+				err = fn(ak, ChangeBucketRemoved, nil, bv)
+			} else if bv == nil {
+				err = fn(ak, ChangeBucketAdded, av, nil)
+			} else if !bytes.Equal(av, bv) {
+				err = fn(ak, ChangeModified, av, bv)
+			}
+			ak, av = ac.Next()
+			bk, bv = bc.Next()
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
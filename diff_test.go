@@ -0,0 +1,107 @@
+package bbolt_test
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	bolt "github.com/covalenthq/bbolt"
+)
+
+// Ensure that added, removed, modified and nested bucket keys are reported in
+// key order.
+func TestDiffBuckets(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		a, err := tx.CreateBucket([]byte("a"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := tx.CreateBucket([]byte("b"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, kv := range [][2]string{{"same", "1"}, {"changed", "1"}, {"removed", "1"}, {"shape", "1"}} {
+			if err := a.Put([]byte(kv[0]), []byte(kv[1])); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, kv := range [][2]string{{"same", "1"}, {"changed", "2"}, {"added", "1"}} {
+			if err := b.Put([]byte(kv[0]), []byte(kv[1])); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := b.CreateBucket([]byte("shape")); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := a.CreateBucket([]byte("zzz")); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := b.CreateBucket([]byte("yyy")); err != nil {
+			t.Fatal(err)
+		}
+		for i, bucket := range []*bolt.Bucket{a, b} {
+			child, err := bucket.CreateBucket([]byte("nested"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := child.Put([]byte("foo"), []byte(fmt.Sprint(i))); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		var changes []string
+		if err := bolt.DiffBuckets(a, b, func(k []byte, change bolt.ChangeKind, av, bv []byte) error {
+			changes = append(changes, fmt.Sprintf("%s %s %q %q", change, k, av, bv))
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		exp := []string{
+			`added added "" "1"`,
+			`modified changed "1" "2"`,
+			`bucket nested "" ""`,
+			`removed removed "1" ""`,
+			`bucket added shape "1" ""`,
+			`bucket added yyy "" ""`,
+			`bucket removed zzz "" ""`,
+		}
+		if !reflect.DeepEqual(changes, exp) {
+			t.Fatalf("unexpected changes: %q", changes)
+		}
+
+		// Reversing the comparison swaps the side of one-sided buckets.
+		changes = nil
+		if err := bolt.DiffBuckets(b, a, func(k []byte, change bolt.ChangeKind, av, bv []byte) error {
+			if change == bolt.ChangeBucketAdded || change == bolt.ChangeBucketRemoved {
+				changes = append(changes, fmt.Sprintf("%s %s", change, k))
+			}
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if exp := []string{"bucket removed shape", "bucket removed yyy", "bucket added zzz"}; !reflect.DeepEqual(changes, exp) {
+			t.Fatalf("unexpected changes: %q", changes)
+		}
+
+		// Identical buckets only report their nested buckets, without
+		// comparing them.
+		changes = nil
+		if err := bolt.DiffBuckets(a, a, func(k []byte, change bolt.ChangeKind, av, bv []byte) error {
+			changes = append(changes, fmt.Sprintf("%s %s", change, k))
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if exp := []string{"bucket nested", "bucket zzz"}; !reflect.DeepEqual(changes, exp) {
+			t.Fatalf("unexpected changes: %q", changes)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
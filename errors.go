@@ -1,6 +1,9 @@
 package bbolt
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
 // These errors can be returned when opening or calling methods on a DB.
 var (
@@ -26,6 +29,10 @@ var (
 	// ErrTimeout is returned when a database cannot obtain an exclusive lock
 	// on the data file after the timeout passed to Open().
 	ErrTimeout = errors.New("timeout")
+
+	// ErrInvalidPageSize is returned when Options.PageSize is not a power of
+	// two of at least 1KB.
+	ErrInvalidPageSize = errors.New("invalid page size")
 )
 
 // These errors can occur when beginning or committing a Tx.
@@ -55,13 +62,18 @@ var (
 	// ErrBucketNameRequired is returned when creating a bucket with a blank name.
 	ErrBucketNameRequired = errors.New("bucket name required")
 
+	// ErrKeyNotFound is returned when an operation requires an existing key
+	// that does not exist.
+	ErrKeyNotFound = errors.New("key not found")
+
 	// ErrKeyRequired is returned when inserting a zero-length key.
 	ErrKeyRequired = errors.New("key required")
 
 	// ErrKeyTooLarge is returned when inserting a key that is larger than MaxKeySize.
 	ErrKeyTooLarge = errors.New("key too large")
 
-	// ErrValueTooLarge is returned when inserting a value that is larger than MaxValueSize.
+	// ErrValueTooLarge is the error wrapped by a ValueTooLargeError, which is
+	// returned when inserting a value that is larger than the value size limit.
 	ErrValueTooLarge = errors.New("value too large")
 
 	// ErrIncompatibleValue is returned when trying create or delete a bucket
@@ -72,4 +84,56 @@ var (
 	ErrInvalidArgNumber = errors.New("invalid number of arguments for MultiPut")
 
 	ErrUnsortedKeys = errors.New("keys passed to MultiPut are not in sorted order")
+
+	// ErrChecksumMismatch is returned when a value read with GetChecksummed
+	// does not match the checksum stored alongside it.
+	ErrChecksumMismatch = errors.New("value checksum mismatch")
+
+	// ErrCorruptCompressedValue is returned when a value read with
+	// GetCompressed cannot be decompressed.
+	ErrCorruptCompressedValue = errors.New("corrupt compressed value")
+
+	// ErrSequenceOverflow is returned when reserving more sequence values
+	// than remain before the bucket's sequence would wrap around.
+	ErrSequenceOverflow = errors.New("sequence overflow")
+
+	// ErrInvalidCounter is returned when incrementing a key whose value is
+	// not an 8-byte big-endian integer.
+	ErrInvalidCounter = errors.New("counter value must be 8 bytes")
+
+	// ErrCounterOverflow is returned when incrementing a counter would move
+	// it outside the range of a uint64.
+	ErrCounterOverflow = errors.New("counter overflow")
+
+	// ErrInvalidLimit is returned when a paginated read is given a limit
+	// that is not positive.
+	ErrInvalidLimit = errors.New("limit must be positive")
 )
+
+// ValueTooLargeError is returned when writing a value that is larger than the
+// limit set by Options.MaxValueSize.
+type ValueTooLargeError struct {
+	Key     []byte
+	Size    int
+	MaxSize int
+}
+
+func (e *ValueTooLargeError) Error() string {
+	return fmt.Sprintf("value too large: key %q is %d bytes, limit is %d", e.Key, e.Size, e.MaxSize)
+}
+
+// Unwrap returns ErrValueTooLarge.
+func (e *ValueTooLargeError) Unwrap() error {
+	return ErrValueTooLarge
+}
+
+// UnreachablePageError is reported by a consistency check for a page below
+// the high water mark that is neither reachable nor free. Such a page is
+// leaked: it takes up space in the file but can never be reused.
+type UnreachablePageError struct {
+	ID int
+}
+
+func (e *UnreachablePageError) Error() string {
+	return fmt.Sprintf("page %d: unreachable unfreed", e.ID)
+}
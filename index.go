@@ -0,0 +1,149 @@
+package bbolt
+
+// Index maintains a secondary index of a source bucket within another bucket.
+//
+// Each value written to the source is passed to an extract function that
+// returns the index keys for it. The index bucket holds a nested bucket for
+// every index key, listing the source keys that produced it. Nested buckets
+// in the source are not indexed.
+//
+// An Index keeps the index bucket in sync through a change handler on the
+// source, so, like the handler, it only applies within the transaction the
+// buckets belong to and must be recreated with NewIndex in every Tx.
+//
+// A change handler cannot fail the write that triggered it, so an error
+// updating the index does not make the source's Put or Delete return an
+// error. Instead the Index stops updating the index bucket, Lookup returns
+// the error, and committing the transaction fails with it, rolling back both
+// the source and the index:
+//
+//	err := db.Update(func(tx *bolt.Tx) error {
+//		idx := bolt.NewIndex(tx.Bucket([]byte("users")), tx.Bucket([]byte("by-email")), extract)
+//		if err := tx.Bucket([]byte("users")).Put(key, value); err != nil {
+//			return err
+//		}
+//		return idx.Err() // optional: fail early rather than at commit
+//	})
+type Index struct {
+	source  *Bucket
+	index   *Bucket
+	extract func(key, value []byte) [][]byte
+	err     error
+}
+
+// NewIndex returns an Index of source stored in index, and registers a change
+// handler on source that updates index whenever a key in source is written or
+// deleted. index must be a different bucket than source.
+//
+// Writes to source made before NewIndex is called are not reflected in the
+// index until Rebuild is called.
+func NewIndex(source, index *Bucket, extract func(key, value []byte) [][]byte) *Index {
+	idx := &Index{source: source, index: index, extract: extract}
+	source.OnChange(idx.update)
+	source.tx.commitChecks = append(source.tx.commitChecks, idx.Err)
+	return idx
+}
+
+// Err returns the first error that occurred while updating the index from a
+// change handler. The index may be out of sync with the source once this is
+// set, so further writes to the source are not indexed and the transaction
+// fails to commit with this error, unless Rebuild succeeds first.
+func (idx *Index) Err() error {
+	return idx.err
+}
+
+// Lookup returns the source keys indexed under indexKey, in key order.
+// The returned keys are only valid for the life of the transaction.
+func (idx *Index) Lookup(indexKey []byte) ([][]byte, error) {
+	if idx.err != nil {
+		return nil, idx.err
+	}
+
+	entries := idx.index.Bucket(indexKey)
+	if entries == nil {
+		return nil, nil
+	}
+
+	var keys [][]byte
+	if err := entries.ForEach(func(k, _ []byte) error {
+		keys = append(keys, k)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// Rebuild discards the contents of the index bucket and repopulates it by
+// scanning every value in the source. It clears the error returned by Err if
+// it succeeds, and replaces it with its own error if it fails.
+func (idx *Index) Rebuild() error {
+	idx.err = nil
+	if err := idx.index.Clear(); err != nil {
+		idx.err = err
+		return err
+	}
+
+	if err := idx.source.ForEach(func(k, v []byte) error {
+		if v == nil {
+			return nil
+		}
+		return idx.add(k, v)
+	}); err != nil {
+		idx.err = err
+		return err
+	}
+	return nil
+}
+
+// update is the change handler installed on the source bucket.
+func (idx *Index) update(key, oldValue, newValue []byte) {
+	if idx.err != nil {
+		return
+	}
+	if oldValue != nil {
+		if err := idx.remove(key, oldValue); err != nil {
+			idx.err = err
+			return
+		}
+	}
+	if newValue != nil {
+		if err := idx.add(key, newValue); err != nil {
+			idx.err = err
+		}
+	}
+}
+
+// add records key under every index key extracted from value.
+func (idx *Index) add(key, value []byte) error {
+	for _, indexKey := range idx.extract(key, value) {
+		entries, err := idx.index.CreateBucketIfNotExists(indexKey)
+		if err != nil {
+			return err
+		}
+		if err := entries.Put(key, []byte{}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// remove drops key from every index key extracted from value, deleting the
+// index keys that no longer refer to any source key.
+func (idx *Index) remove(key, value []byte) error {
+	for _, indexKey := range idx.extract(key, value) {
+		entries := idx.index.Bucket(indexKey)
+		if entries == nil {
+			continue
+		}
+		if err := entries.Delete(key); err != nil {
+			return err
+		}
+		if k, _ := entries.Cursor().First(); k == nil {
+			if err := idx.index.DeleteBucket(indexKey); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,171 @@
+package bbolt_test
+
+import (
+	"bytes"
+	"testing"
+
+	bolt "github.com/covalenthq/bbolt"
+)
+
+// Ensure that an index stays in sync with writes to its source bucket.
+func TestIndex(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	// Index each value by its comma-separated tags.
+	extract := func(key, value []byte) [][]byte {
+		if len(value) == 0 {
+			return nil
+		}
+		return bytes.Split(value, []byte(","))
+	}
+
+	lookup := func(idx *bolt.Index, indexKey string) string {
+		keys, err := idx.Lookup([]byte(indexKey))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(bytes.Join(keys, []byte(" ")))
+	}
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		src, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		tags, err := tx.CreateBucket([]byte("tags"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := src.Put([]byte("early"), []byte("red")); err != nil {
+			t.Fatal(err)
+		}
+
+		idx := bolt.NewIndex(src, tags, extract)
+		if err := src.Put([]byte("foo"), []byte("red,blue")); err != nil {
+			t.Fatal(err)
+		}
+		if err := src.Put([]byte("bar"), []byte("blue")); err != nil {
+			t.Fatal(err)
+		}
+		if s := lookup(idx, "red"); s != "foo" {
+			t.Fatalf("unexpected keys: %q", s)
+		}
+		if s := lookup(idx, "blue"); s != "bar foo" {
+			t.Fatalf("unexpected keys: %q", s)
+		}
+
+		// Updates move keys between index entries, and empty entries go away.
+		if err := src.Put([]byte("foo"), []byte("green")); err != nil {
+			t.Fatal(err)
+		}
+		if err := src.Delete([]byte("bar")); err != nil {
+			t.Fatal(err)
+		}
+		if s := lookup(idx, "green"); s != "foo" {
+			t.Fatalf("unexpected keys: %q", s)
+		}
+		if s := lookup(idx, "blue"); s != "" {
+			t.Fatalf("unexpected keys: %q", s)
+		}
+		if tags.Bucket([]byte("blue")) != nil || tags.Bucket([]byte("red")) != nil {
+			t.Fatal("expected empty index entries to be deleted")
+		}
+
+		// Rebuild picks up values written before the index existed.
+		if err := idx.Rebuild(); err != nil {
+			t.Fatal(err)
+		}
+		if s := lookup(idx, "red"); s != "early" {
+			t.Fatalf("unexpected keys: %q", s)
+		}
+		if s := lookup(idx, "green"); s != "foo" {
+			t.Fatalf("unexpected keys: %q", s)
+		}
+		return idx.Err()
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.View(func(tx *bolt.Tx) error {
+		idx := bolt.NewIndex(tx.Bucket([]byte("widgets")), tx.Bucket([]byte("tags")), extract)
+		if s := lookup(idx, "red"); s != "early" {
+			t.Fatalf("unexpected keys: %q", s)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that an error updating the index is reported by Err and Lookup,
+// and stops the transaction from committing.
+func TestIndex_Err(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	extract := func(key, value []byte) [][]byte {
+		return [][]byte{value}
+	}
+	if err := db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucket([]byte("widgets")); err != nil {
+			t.Fatal(err)
+		}
+		_, err := tx.CreateBucket([]byte("tags"))
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		src := tx.Bucket([]byte("widgets"))
+		idx := bolt.NewIndex(src, tx.Bucket([]byte("tags")), extract)
+
+		// The empty value cannot be used as an index key.
+		if err := src.Put([]byte("foo"), nil); err != nil {
+			t.Fatal(err)
+		}
+		if err := idx.Err(); err != bolt.ErrBucketNameRequired {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, err := idx.Lookup([]byte("bar")); err != bolt.ErrBucketNameRequired {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		// Later writes are no longer indexed.
+		if err := src.Put([]byte("baz"), []byte("bat")); err != nil {
+			t.Fatal(err)
+		}
+		if tx.Bucket([]byte("tags")).Bucket([]byte("bat")) != nil {
+			t.Fatal("unexpected index entry")
+		}
+		return nil
+	}); err != bolt.ErrBucketNameRequired {
+		t.Fatalf("unexpected commit error: %v", err)
+	}
+
+	// Neither the source nor the index were committed.
+	if err := db.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket([]byte("widgets")).Get([]byte("baz")); v != nil {
+			t.Fatalf("unexpected value: %q", v)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Rebuild clears the error, allowing the transaction to commit.
+	if err := db.Update(func(tx *bolt.Tx) error {
+		src := tx.Bucket([]byte("widgets"))
+		idx := bolt.NewIndex(src, tx.Bucket([]byte("tags")), extract)
+		if err := src.Put([]byte("foo"), nil); err != nil {
+			t.Fatal(err)
+		}
+		if err := src.Put([]byte("foo"), []byte("bar")); err != nil {
+			t.Fatal(err)
+		}
+		return idx.Rebuild()
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
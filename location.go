@@ -1,5 +1,7 @@
 package bbolt
 
+import "bytes"
+
 type Location struct {
 	parent   Bucketish
 	childKey []byte
@@ -12,6 +14,49 @@ func NewLocation(parent Bucketish, childKey []byte) *Location {
 	}
 }
 
+// Navigate descends from parent through all but the last segment of path,
+// and returns a Location for the last segment within the bucket reached.
+// An empty path returns a Location pointing at parent itself.
+// Returns ErrBucketNotFound if an intermediate segment is not a bucket.
+func Navigate(parent Bucketish, path [][]byte) (*Location, error) {
+	if len(path) == 0 {
+		return NewLocation(parent, nil), nil
+	}
+
+	bish := parent
+	for _, childKey := range path[:len(path)-1] {
+		b := bish.Bucket(childKey)
+		if b == nil {
+			return nil, ErrBucketNotFound
+		}
+		bish = b
+	}
+
+	return NewLocation(bish, path[len(path)-1]), nil
+}
+
+// UpdateLocations executes fn within a single managed read-write transaction,
+// handing it a resolver that navigates to any location in that transaction,
+// as Navigate does. When the resolver is called with create set, buckets
+// missing along the path are created instead of returning ErrBucketNotFound.
+// All changes made through the resolved locations commit or roll back
+// together, as with Update.
+func (db *DB) UpdateLocations(fn func(resolve func(path [][]byte, create bool) (*Location, error)) error) error {
+	return db.Update(func(tx *Tx) error {
+		return fn(func(path [][]byte, create bool) (*Location, error) {
+			if !create || len(path) <= 1 {
+				return Navigate(tx, path)
+			}
+
+			b, err := createBucketPath(tx, path[:len(path)-1])
+			if err != nil {
+				return nil, err
+			}
+			return NewLocation(b, path[len(path)-1]), nil
+		})
+	})
+}
+
 func (loc *Location) Parent() Bucketish {
 	return loc.parent
 }
@@ -32,6 +77,28 @@ func (loc *Location) ResolveHere() interface{} {
 	}
 }
 
+// Exists returns whether anything resolves at this location.
+func (loc *Location) Exists() bool {
+	return loc.ResolveHere() != nil
+}
+
+// IsBucket returns whether this location resolves to a bucket, including the
+// root bucket of a transaction.
+func (loc *Location) IsBucket() bool {
+	switch loc.ResolveHere().(type) {
+	case *Bucket, *Tx:
+		return true
+	default:
+		return false
+	}
+}
+
+// IsScalar returns whether this location resolves to a scalar value.
+func (loc *Location) IsScalar() bool {
+	_, ok := loc.ResolveHere().([]byte)
+	return ok
+}
+
 func (loc *Location) GetHere() []byte {
 	if loc.childKey == nil {
 		return nil
@@ -140,3 +207,146 @@ func (loc *Location) DeleteBucketHere() error {
 func (loc *Location) Writable() bool {
 	return loc.parent.Writable()
 }
+
+// Siblings calls fn for every key/value pair in the bucket containing this
+// location, in key order, as ForEach does. If skipSelf is set, the key this
+// location points at is left out. Returns ErrIncompatibleValue if the location
+// points at a bucket as a whole rather than at a key within its parent.
+func (loc *Location) Siblings(skipSelf bool, fn func(k, v []byte) error) error {
+	if loc.childKey == nil {
+		return ErrIncompatibleValue
+	}
+
+	return loc.parent.ForEach(func(k, v []byte) error {
+		if skipSelf && bytes.Equal(k, loc.childKey) {
+			return nil
+		}
+		return fn(k, v)
+	})
+}
+
+// MoveTo relocates the key or bucket at this location to dest, then removes
+// it from here. Buckets are moved along with their whole subtree.
+// Returns ErrIncompatibleValue if this location is the root or dest lies
+// within the bucket being moved.
+func (loc *Location) MoveTo(dest *Location) error {
+	if loc.childKey == nil {
+		return ErrIncompatibleValue
+	}
+
+	if dest.parent == loc.parent && bytes.Equal(dest.childKey, loc.childKey) {
+		return nil
+	}
+
+	if v := loc.GetHere(); v != nil {
+		if err := dest.PutHere(v); err != nil {
+			return err
+		}
+		return loc.DeleteHere()
+	}
+
+	b := loc.BucketHere()
+	if b == nil {
+		return ErrKeyNotFound
+	}
+
+	if bucketContains(b, dest.parent) {
+		return ErrIncompatibleValue
+	}
+
+	destBucket, err := dest.CreateBucketHereIfNotExists()
+	if err != nil {
+		return err
+	}
+
+	if err := copyBucket(b, destBucket); err != nil {
+		return err
+	}
+
+	return loc.DeleteBucketHere()
+}
+
+// CopyTo copies whatever resolves at this location to dest. Buckets are
+// copied along with their whole subtree and sequence numbers. The source and
+// destination may belong to different transactions, or different databases.
+// Returns ErrIncompatibleValue if dest lies within the bucket being copied.
+func (loc *Location) CopyTo(dest *Location) error {
+	switch something := loc.ResolveHere().(type) {
+	case []byte:
+		return dest.PutHere(something)
+	case *Bucket:
+		if bucketContains(something, dest.parent) {
+			return ErrIncompatibleValue
+		}
+
+		destBucket, err := dest.CreateBucketHereIfNotExists()
+		if err != nil {
+			return err
+		}
+
+		return copyBucket(something, destBucket)
+	case *Tx:
+		var destBish Bucketish
+		if rb := dest.RootBucketHere(); rb != nil {
+			destBish = rb
+		} else {
+			destBucket, err := dest.CreateBucketHereIfNotExists()
+			if err != nil {
+				return err
+			}
+			destBish = destBucket
+		}
+
+		return something.ForEach(func(k, v []byte) error {
+			b := something.Bucket(k)
+			if bucketContains(b, dest.parent) {
+				return ErrIncompatibleValue
+			}
+
+			destChild, err := destBish.CreateBucketIfNotExists(k)
+			if err != nil {
+				return err
+			}
+
+			return copyBucket(b, destChild)
+		})
+	default:
+		return ErrKeyNotFound
+	}
+}
+
+// bucketContains returns whether target is b or one of its nested buckets.
+func bucketContains(b *Bucket, target Bucketish) bool {
+	if Bucketish(b) == target {
+		return true
+	}
+
+	c := b.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		if v == nil && bucketContains(b.Bucket(k), target) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// copyBucket recursively copies the keys, nested buckets and sequence
+// numbers of src into dest.
+func copyBucket(src *Bucket, dest *Bucket) error {
+	if err := dest.SetSequence(src.Sequence()); err != nil {
+		return err
+	}
+
+	return src.ForEach(func(k, v []byte) error {
+		if v == nil {
+			destChild, err := dest.CreateBucketIfNotExists(k)
+			if err != nil {
+				return err
+			}
+			return copyBucket(src.Bucket(k), destChild)
+		}
+
+		return dest.Put(k, v)
+	})
+}
@@ -0,0 +1,393 @@
+package bbolt_test
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	bolt "github.com/covalenthq/bbolt"
+)
+
+// Ensure that a scalar value can be moved to another location.
+func TestLocation_MoveTo(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := b.Put([]byte("foo"), []byte("bar")); err != nil {
+			t.Fatal(err)
+		}
+
+		src := bolt.NewLocation(b, []byte("foo"))
+		if err := src.MoveTo(src); err != nil {
+			t.Fatal(err)
+		}
+		if v := b.Get([]byte("foo")); !bytes.Equal(v, []byte("bar")) {
+			t.Fatalf("unexpected value: %v", v)
+		}
+
+		if err := src.MoveTo(bolt.NewLocation(b, []byte("baz"))); err != nil {
+			t.Fatal(err)
+		}
+		if v := b.Get([]byte("foo")); v != nil {
+			t.Fatalf("unexpected value: %v", v)
+		}
+		if v := b.Get([]byte("baz")); !bytes.Equal(v, []byte("bar")) {
+			t.Fatalf("unexpected value: %v", v)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that a bucket is moved along with its subtree and sequence numbers.
+func TestLocation_MoveTo_Bucket(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		child, err := b.CreateBucket([]byte("child"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := child.Put([]byte("foo"), []byte("bar")); err != nil {
+			t.Fatal(err)
+		}
+		if err := child.SetSequence(42); err != nil {
+			t.Fatal(err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		src := bolt.NewLocation(tx, []byte("widgets"))
+		if err := src.MoveTo(bolt.NewLocation(tx, []byte("gadgets"))); err != nil {
+			t.Fatal(err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.View(func(tx *bolt.Tx) error {
+		if tx.Bucket([]byte("widgets")) != nil {
+			t.Fatal("expected source bucket to be deleted")
+		}
+		child := tx.Bucket([]byte("gadgets")).Bucket([]byte("child"))
+		if child == nil {
+			t.Fatal("expected nested bucket to be moved")
+		}
+		if v := child.Get([]byte("foo")); !bytes.Equal(v, []byte("bar")) {
+			t.Fatalf("unexpected value: %v", v)
+		}
+		if seq := child.Sequence(); seq != 42 {
+			t.Fatalf("unexpected sequence: %d", seq)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that a bucket cannot be moved into itself or out of the root.
+func TestLocation_MoveTo_IncompatibleValue(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		child, err := b.CreateBucket([]byte("child"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		src := bolt.NewLocation(tx, []byte("widgets"))
+		if err := src.MoveTo(bolt.NewLocation(child, []byte("widgets"))); err != bolt.ErrIncompatibleValue {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if err := src.MoveTo(bolt.NewLocation(b, []byte("widgets"))); err != bolt.ErrIncompatibleValue {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if err := bolt.NewLocation(tx, nil).MoveTo(bolt.NewLocation(b, []byte("root"))); err != bolt.ErrIncompatibleValue {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that locations report what they resolve to.
+func TestLocation_Exists(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := b.Put([]byte("foo"), []byte("bar")); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, tt := range []struct {
+			loc                        *bolt.Location
+			exists, isBucket, isScalar bool
+		}{
+			{bolt.NewLocation(tx, nil), true, true, false},
+			{bolt.NewLocation(tx, []byte("widgets")), true, true, false},
+			{bolt.NewLocation(b, nil), true, true, false},
+			{bolt.NewLocation(b, []byte("foo")), true, false, true},
+			{bolt.NewLocation(b, []byte("missing")), false, false, false},
+			{bolt.NewLocation(tx, []byte("missing")), false, false, false},
+		} {
+			if exists := tt.loc.Exists(); exists != tt.exists {
+				t.Fatalf("%q: unexpected Exists: %v", tt.loc.Key(), exists)
+			}
+			if isBucket := tt.loc.IsBucket(); isBucket != tt.isBucket {
+				t.Fatalf("%q: unexpected IsBucket: %v", tt.loc.Key(), isBucket)
+			}
+			if isScalar := tt.loc.IsScalar(); isScalar != tt.isScalar {
+				t.Fatalf("%q: unexpected IsScalar: %v", tt.loc.Key(), isScalar)
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that the siblings of a location are iterated with or without itself.
+func TestLocation_Siblings(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, k := range []string{"bar", "baz", "foo"} {
+			if err := b.Put([]byte(k), []byte("x")); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := b.CreateBucket([]byte("child")); err != nil {
+			t.Fatal(err)
+		}
+
+		siblings := func(loc *bolt.Location, skipSelf bool) (keys []string) {
+			if err := loc.Siblings(skipSelf, func(k, v []byte) error {
+				keys = append(keys, string(k))
+				return nil
+			}); err != nil {
+				t.Fatal(err)
+			}
+			return keys
+		}
+
+		loc := bolt.NewLocation(b, []byte("baz"))
+		if keys := strings.Join(siblings(loc, false), ","); keys != "bar,baz,child,foo" {
+			t.Fatalf("unexpected keys: %s", keys)
+		}
+		if keys := strings.Join(siblings(loc, true), ","); keys != "bar,child,foo" {
+			t.Fatalf("unexpected keys: %s", keys)
+		}
+		if keys := strings.Join(siblings(bolt.NewLocation(tx, []byte("widgets")), true), ","); keys != "" {
+			t.Fatalf("unexpected keys: %s", keys)
+		}
+
+		if err := bolt.NewLocation(tx, nil).Siblings(false, func(k, v []byte) error { return nil }); err != bolt.ErrIncompatibleValue {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that a bucket subtree can be copied into another database.
+func TestLocation_CopyTo(t *testing.T) {
+	src := MustOpenDB()
+	defer src.MustClose()
+	dest := MustOpenDB()
+	defer dest.MustClose()
+
+	if err := src.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := b.Put([]byte("foo"), []byte("bar")); err != nil {
+			t.Fatal(err)
+		}
+		child, err := b.CreateBucket([]byte("child"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := child.Put([]byte("baz"), []byte("bat")); err != nil {
+			t.Fatal(err)
+		}
+		if err := child.SetSequence(42); err != nil {
+			t.Fatal(err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := src.View(func(srcTx *bolt.Tx) error {
+		return dest.Update(func(destTx *bolt.Tx) error {
+			if err := bolt.NewLocation(srcTx, nil).CopyTo(bolt.NewLocation(destTx, nil)); err != nil {
+				t.Fatal(err)
+			}
+			srcLoc := bolt.NewLocation(srcTx.Bucket([]byte("widgets")), []byte("child"))
+			if err := srcLoc.CopyTo(bolt.NewLocation(destTx, []byte("gadgets"))); err != nil {
+				t.Fatal(err)
+			}
+			return nil
+		})
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := dest.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("widgets"))
+		if v := b.Get([]byte("foo")); !bytes.Equal(v, []byte("bar")) {
+			t.Fatalf("unexpected value: %v", v)
+		}
+		for _, child := range []*bolt.Bucket{b.Bucket([]byte("child")), tx.Bucket([]byte("gadgets"))} {
+			if v := child.Get([]byte("baz")); !bytes.Equal(v, []byte("bat")) {
+				t.Fatalf("unexpected value: %v", v)
+			}
+			if seq := child.Sequence(); seq != 42 {
+				t.Fatalf("unexpected sequence: %d", seq)
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that a multi-segment path resolves to a location within nested buckets.
+func TestNavigate(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		child, err := b.CreateBucket([]byte("child"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := child.Put([]byte("foo"), []byte("bar")); err != nil {
+			t.Fatal(err)
+		}
+
+		loc, err := bolt.Navigate(tx, [][]byte{[]byte("widgets"), []byte("child"), []byte("foo")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v := loc.GetHere(); !bytes.Equal(v, []byte("bar")) {
+			t.Fatalf("unexpected value: %v", v)
+		}
+
+		loc, err = bolt.Navigate(tx, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if loc.RootBucketHere() != tx {
+			t.Fatal("expected location at the root")
+		}
+
+		if _, err := bolt.Navigate(tx, [][]byte{[]byte("widgets"), []byte("missing"), []byte("foo")}); err != bolt.ErrBucketNotFound {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, err := bolt.Navigate(tx, [][]byte{[]byte("widgets"), []byte("child"), []byte("foo"), []byte("bar")}); err != bolt.ErrBucketNotFound {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that locations resolved within UpdateLocations commit or roll back together.
+func TestDB_UpdateLocations(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	path := func(segments ...string) (p [][]byte) {
+		for _, s := range segments {
+			p = append(p, []byte(s))
+		}
+		return p
+	}
+
+	if err := db.UpdateLocations(func(resolve func([][]byte, bool) (*bolt.Location, error)) error {
+		if _, err := resolve(path("widgets", "foo"), false); err != bolt.ErrBucketNotFound {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		for _, p := range [][][]byte{path("widgets", "foo"), path("gadgets", "nested", "bar")} {
+			loc, err := resolve(p, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := loc.PutHere([]byte("value")); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	errRollback := errors.New("rollback")
+	if err := db.UpdateLocations(func(resolve func([][]byte, bool) (*bolt.Location, error)) error {
+		loc, err := resolve(path("widgets", "baz"), false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := loc.PutHere([]byte("value")); err != nil {
+			t.Fatal(err)
+		}
+		return errRollback
+	}); err != errRollback {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := db.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket([]byte("widgets")).Get([]byte("foo")); !bytes.Equal(v, []byte("value")) {
+			t.Fatalf("unexpected value: %v", v)
+		}
+		if v := tx.Bucket([]byte("gadgets")).Bucket([]byte("nested")).Get([]byte("bar")); !bytes.Equal(v, []byte("value")) {
+			t.Fatalf("unexpected value: %v", v)
+		}
+		if v := tx.Bucket([]byte("widgets")).Get([]byte("baz")); v != nil {
+			t.Fatalf("unexpected value: %v", v)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
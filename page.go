@@ -137,8 +137,48 @@ func (n *leafPageElement) value() []byte {
 type PageInfo struct {
 	ID            int
 	Type          string
+	Flags         int
 	Count         int
 	OverflowCount int
+
+	// Elements describes the elements of branch and leaf pages, in order.
+	Elements []PageElementInfo
+
+	// Meta holds the decoded contents of a meta page.
+	Meta *MetaInfo
+}
+
+// MetaInfo describes the contents of a meta page.
+type MetaInfo struct {
+	Version  int
+	PageSize int
+	Root     int // page id of the root bucket
+	Freelist int // page id of the freelist
+	PageN    int // high water mark of allocated pages
+	TxID     int
+	Checksum uint64
+
+	// Valid reports whether the page carries the Bolt magic number, a
+	// supported version and a checksum matching its contents.
+	Valid bool
+}
+
+// PageElementInfo describes an element of a branch or leaf page.
+type PageElementInfo struct {
+	// Offset is the position of the element's key within the page, counted
+	// from the start of the page header. Its value follows the key.
+	Offset int
+
+	// Key is a copy of the element's key, including the page's key prefix.
+	Key []byte
+
+	// ValueSize is the size of a leaf element's value, and IsBucket reports
+	// whether the value is a nested bucket header.
+	ValueSize int
+	IsBucket  bool
+
+	// Child is the id of the page a branch element points to.
+	Child int
 }
 
 type pgids []pgid
@@ -1,6 +1,7 @@
 package bbolt
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"os"
@@ -31,6 +32,10 @@ type Tx struct {
 	stats          TxStats
 	commitHandlers []func()
 
+	// commitChecks are run before a commit writes anything, and abort it
+	// if any of them fails.
+	commitChecks []func() error
+
 	// WriteFlag specifies the flag for write-related methods like WriteTo().
 	// Tx opens the database file with the specified flag to copy the data.
 	//
@@ -94,6 +99,17 @@ func (tx *Tx) Stats() TxStats {
 	return tx.stats
 }
 
+// BucketStats returns the statistics of all top-level buckets added together.
+// The pages of the root, which only hold bucket headers, are not included.
+func (tx *Tx) BucketStats() BucketStats {
+	var s BucketStats
+	_ = tx.ForEachBucket(func(_ []byte, b *Bucket) error {
+		s.Add(b.Stats())
+		return nil
+	})
+	return s
+}
+
 // Bucket retrieves a bucket by name.
 // Returns nil if the bucket does not exist.
 // The bucket instance is only valid for the lifetime of the transaction.
@@ -121,6 +137,54 @@ func (tx *Tx) DeleteBucket(name []byte) error {
 	return tx.root.DeleteBucket(name)
 }
 
+// RenameBucket moves the bucket oldName to newName, along with its whole
+// subtree and sequence, as Bucket.RenameBucket does.
+func (tx *Tx) RenameBucket(oldName, newName []byte) error {
+	return tx.root.RenameBucket(oldName, newName)
+}
+
+// CopyBucket creates the bucket dstName as a deep copy of the bucket srcName,
+// including all of its keys, nested buckets and sequence numbers.
+// Returns ErrBucketNotFound if srcName does not exist, and ErrBucketExists if
+// dstName already does.
+func (tx *Tx) CopyBucket(srcName, dstName []byte) error {
+	src := tx.Bucket(srcName)
+	if src == nil {
+		return ErrBucketNotFound
+	}
+
+	dst, err := tx.CreateBucket(dstName)
+	if err != nil {
+		return err
+	}
+
+	return copyBucket(src, dst)
+}
+
+// Clear deletes every bucket in the root.
+func (tx *Tx) Clear() error {
+	if tx.db == nil {
+		return ErrTxClosed
+	} else if !tx.writable {
+		return ErrTxNotWritable
+	}
+
+	var names [][]byte
+	if err := tx.root.ForEach(func(k, v []byte) error {
+		names = append(names, cloneBytes(k))
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		if err := tx.DeleteBucket(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // ForEachBucket executes a function for each bucket in the root.
 // If the provided function returns an error then the iteration is stopped and
 // the error is returned to the caller.
@@ -130,13 +194,108 @@ func (tx *Tx) ForEachBucket(fn func(name []byte, b *Bucket) error) error {
 	})
 }
 
+// ForEachScalar is a no-op on the root, which only contains buckets.
+func (tx *Tx) ForEachScalar(fn func(k, v []byte) error) error {
+	return nil
+}
+
 // ForEach executes a function for each key/value pair in the root.
 // The root only contains buckets, and so all values passed to the function are nil.
 func (tx *Tx) ForEach(fn func(k, v []byte) error) error {
 	return tx.root.ForEach(fn)
 }
 
+// ForEachContext executes a function for each key/value pair in the root,
+// stopping once ctx is done.
+// The root only contains buckets, and so all values passed to the function are nil.
+func (tx *Tx) ForEachContext(ctx context.Context, fn func(k, v []byte) error) error {
+	return tx.root.ForEachContext(ctx, fn)
+}
+
+// ForEachReverse executes a function for each key/value pair in the root in
+// descending key order.
+// The root only contains buckets, and so all values passed to the function are nil.
+func (tx *Tx) ForEachReverse(fn func(k, v []byte) error) error {
+	return tx.root.ForEachReverse(fn)
+}
+
+// ForEachRange executes a function for each key/value pair in the root whose
+// key falls within [start, end).
+// The root only contains buckets, and so all values passed to the function are nil.
+func (tx *Tx) ForEachRange(start, end []byte, fn func(k, v []byte) error) error {
+	return tx.root.ForEachRange(start, end, fn)
+}
+
+// ForEachPrefix executes a function for each key/value pair in the root whose
+// key begins with prefix.
+// The root only contains buckets, and so all values passed to the function are nil.
+func (tx *Tx) ForEachPrefix(prefix []byte, fn func(k, v []byte) error) error {
+	return tx.root.ForEachPrefix(prefix, fn)
+}
+
+// MultiPutPairs is not supported on the root, which only contains buckets.
+// It always returns ErrIncompatibleValue.
+func (tx *Tx) MultiPutPairs(pairs ...WritePair) error {
+	return ErrIncompatibleValue
+}
+
+// Has always returns false, as the root only contains buckets.
+func (tx *Tx) Has(key []byte) bool {
+	return false
+}
+
+// First returns the name of the first bucket in the root, with a nil value,
+// or nil if there are no buckets.
+func (tx *Tx) First() (k, v []byte) {
+	return tx.Cursor().First()
+}
+
+// Last returns the name of the last bucket in the root, with a nil value,
+// or nil if there are no buckets.
+func (tx *Tx) Last() (k, v []byte) {
+	return tx.Cursor().Last()
+}
+
+// ReserveSequence is not supported on the root, which has no sequence.
+// It always returns ErrIncompatibleValue.
+func (tx *Tx) ReserveSequence(n uint64) (uint64, error) {
+	return 0, ErrIncompatibleValue
+}
+
+// GetOrPut is not supported on the root, which only contains buckets.
+// It always returns ErrIncompatibleValue.
+func (tx *Tx) GetOrPut(key, defaultValue []byte) ([]byte, bool, error) {
+	return nil, false, ErrIncompatibleValue
+}
+
+// PutIfAbsent is not supported on the root, which only contains buckets.
+// It always returns ErrIncompatibleValue.
+func (tx *Tx) PutIfAbsent(key, value []byte) (bool, error) {
+	return false, ErrIncompatibleValue
+}
+
+// Modify is not supported on the root, which only contains buckets.
+// It always returns ErrIncompatibleValue.
+func (tx *Tx) Modify(key []byte, fn func(old []byte) ([]byte, error)) error {
+	return ErrIncompatibleValue
+}
+
+// MultiDelete is not supported on the root, which only contains buckets.
+// It always returns ErrIncompatibleValue.
+func (tx *Tx) MultiDelete(keys ...[]byte) error {
+	return ErrIncompatibleValue
+}
+
+// DeletePrefix is not supported on the root, which only contains buckets.
+// It always returns ErrIncompatibleValue.
+func (tx *Tx) DeletePrefix(prefix []byte) (int, error) {
+	return 0, ErrIncompatibleValue
+}
+
 // OnCommit adds a handler function to be executed after the transaction successfully commits.
+// Handlers run in the order they were added, once the transaction's locks have
+// been released, so they observe the committed state. Handlers are discarded if
+// the transaction is rolled back.
 func (tx *Tx) OnCommit(fn func()) {
 	tx.commitHandlers = append(tx.commitHandlers, fn)
 }
@@ -152,6 +311,15 @@ func (tx *Tx) Commit() error {
 		return ErrTxNotWritable
 	}
 
+	// Refuse to commit changes that a check, such as one registered by an
+	// Index, reports as inconsistent.
+	for _, fn := range tx.commitChecks {
+		if err := fn(); err != nil {
+			tx.rollback()
+			return err
+		}
+	}
+
 	// TODO(benbjohnson): Use vectorized I/O to write out dirty pages.
 
 	// Rebalance nodes which have had deletions.
@@ -221,13 +389,32 @@ func (tx *Tx) Commit() error {
 	tx.close()
 
 	// Execute commit handlers now that the locks have been removed.
-	for _, fn := range tx.commitHandlers {
-		fn()
-	}
+	tx.runCommitHandlers()
 
 	return nil
 }
 
+// runCommitHandlers calls the commit handlers in registration order. A handler
+// that panics does not stop the remaining handlers from running; the first
+// panic is re-raised once they have all run.
+func (tx *Tx) runCommitHandlers() {
+	var p interface{}
+	panicked := false
+	for _, fn := range tx.commitHandlers {
+		func() {
+			defer func() {
+				if r := recover(); r != nil && !panicked {
+					p, panicked = r, true
+				}
+			}()
+			fn()
+		}()
+	}
+	if panicked {
+		panic(p)
+	}
+}
+
 func (tx *Tx) commitFreelist() error {
 	// Allocate new pages for the new free list. This will overestimate
 	// the size of the freelist but not underestimate the size (which would be bad).
@@ -339,16 +526,20 @@ func (tx *Tx) Copy(w io.Writer) error {
 // WriteTo writes the entire database to a writer.
 // If err == nil then exactly tx.Size() bytes will be written into the writer.
 func (tx *Tx) WriteTo(w io.Writer) (n int64, err error) {
-	// Attempt to open reader with WriteFlag
-	f, err := tx.db.openFile(tx.db.path, os.O_RDONLY|tx.WriteFlag, 0)
-	if err != nil {
-		return 0, err
-	}
-	defer func() {
-		if cerr := f.Close(); err == nil {
-			err = cerr
+	// An in-memory database has no file to read, so copy from its arena.
+	var f *os.File
+	if !tx.db.memOnly {
+		// Attempt to open reader with WriteFlag
+		f, err = tx.db.openFile(tx.db.path, os.O_RDONLY|tx.WriteFlag, 0)
+		if err != nil {
+			return 0, err
 		}
-	}()
+		defer func() {
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+		}()
+	}
 
 	// Generate a meta page. We use the same page data for both meta pages.
 	buf := make([]byte, tx.db.pageSize)
@@ -375,6 +566,12 @@ func (tx *Tx) WriteTo(w io.Writer) (n int64, err error) {
 		return n, fmt.Errorf("meta 1 copy: %s", err)
 	}
 
+	if tx.db.memOnly {
+		nn, err = w.Write(tx.db.dataref[tx.db.pageSize*2 : tx.Size()])
+		n += int64(nn)
+		return n, err
+	}
+
 	// Move past the meta pages in the file.
 	if _, err := f.Seek(int64(tx.db.pageSize*2), io.SeekStart); err != nil {
 		return n, fmt.Errorf("seek: %s", err)
@@ -453,7 +650,7 @@ func (tx *Tx) check(ch chan error) {
 	for i := pgid(0); i < tx.meta.pgid; i++ {
 		_, isReachable := reachable[i]
 		if !isReachable && !freed[i] {
-			ch <- fmt.Errorf("page %d: unreachable unfreed", int(i))
+			ch <- &UnreachablePageError{ID: int(i)}
 		}
 	}
 
@@ -468,9 +665,11 @@ func (tx *Tx) checkBucket(b *Bucket, reachable map[pgid]*page, freed map[pgid]bo
 	}
 
 	// Check every page used by this bucket.
+	valid := true
 	b.tx.forEachPage(b.root, 0, func(p *page, _ int) {
 		if p.id > tx.meta.pgid {
 			ch <- fmt.Errorf("page %d: out of bounds: %d", int(p.id), int(b.tx.meta.pgid))
+			valid = false
 		}
 
 		// Ensure each page is only referenced once.
@@ -487,9 +686,16 @@ func (tx *Tx) checkBucket(b *Bucket, reachable map[pgid]*page, freed map[pgid]bo
 			ch <- fmt.Errorf("page %d: reachable freed", int(p.id))
 		} else if (p.flags&branchPageFlag) == 0 && (p.flags&leafPageFlag) == 0 {
 			ch <- fmt.Errorf("page %d: invalid type: %s", int(p.id), p.typ())
+			valid = false
 		}
 	})
 
+	// The keys of a bucket with invalid pages cannot be read safely, so its
+	// nested buckets are not checked.
+	if !valid {
+		return
+	}
+
 	// Check each bucket within this bucket.
 	_ = b.ForEach(func(k, v []byte) error {
 		if child := b.Bucket(k); child != nil {
@@ -642,33 +848,169 @@ func (tx *Tx) forEachPage(pgid pgid, depth int, fn func(*page, int)) {
 	}
 }
 
-// Page returns page information for a given page number.
+// BucketPages returns the number of pages used by each top-level bucket,
+// including overflow pages and the pages of its nested buckets, keyed by
+// bucket name. Buckets stored inline in their parent use no pages of their
+// own. Changes made by a writable transaction are only reflected once they
+// have been written to pages, that is, after the transaction commits.
+func (tx *Tx) BucketPages() (map[string]int, error) {
+	if tx.db == nil {
+		return nil, ErrTxClosed
+	}
+
+	pages := make(map[string]int)
+	err := tx.ForEachBucket(func(name []byte, b *Bucket) error {
+		pages[string(name)] = b.pageCount()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return pages, nil
+}
+
+// ForEachPage calls fn for every page below the high water mark, in page id
+// order, with the page's type ("meta", "freelist", "branch", "leaf" or "free")
+// and its number of overflow pages. Overflow pages are not visited on their
+// own but reported as part of the page they belong to. Iteration stops at the
+// first error returned by fn, and that error is returned.
+// This is only safe for concurrent use when used by a writable transaction.
+func (tx *Tx) ForEachPage(fn func(id int, typ string, overflow int) error) error {
+	if tx.db == nil {
+		return ErrTxClosed
+	}
+
+	// Force loading free list if opened in ReadOnly mode.
+	tx.db.loadFreelist()
+
+	for id := pgid(0); id < tx.meta.pgid; id++ {
+		if tx.db.freelist.freed(id) {
+			if err := fn(int(id), "free", 0); err != nil {
+				return err
+			}
+			continue
+		}
+
+		p := tx.page(id)
+		if err := fn(int(id), p.typ(), int(p.overflow)); err != nil {
+			return err
+		}
+		id += pgid(p.overflow)
+	}
+	return nil
+}
+
+// Page returns page information for a given page number, including the
+// decoded contents of meta, branch and leaf pages. Returns nil if the page
+// lies beyond the end of the database. Pages holding the continuation of a
+// multi-page node are reported with the type "overflow" and are not decoded,
+// and an error is returned if a page's elements do not fit within it. The
+// returned PageInfo holds copies of the page data and remains valid after
+// the transaction is closed.
 // This is only safe for concurrent use when used by a writable transaction.
 func (tx *Tx) Page(id int) (*PageInfo, error) {
 	if tx.db == nil {
 		return nil, ErrTxClosed
-	} else if pgid(id) >= tx.meta.pgid {
+	} else if id < 0 || pgid(id) >= tx.meta.pgid {
 		return nil, nil
 	}
 
+	// Determine if the page is free, loading the freelist if the database
+	// was opened in ReadOnly mode.
+	tx.db.loadFreelist()
+	if tx.db.freelist.freed(pgid(id)) {
+		return &PageInfo{ID: id, Type: "free"}, nil
+	}
+
+	// Walk the pages preceding id, as it may be the continuation of an
+	// earlier page whose overflow covers it.
+	for pid := pgid(0); pid < pgid(id); pid++ {
+		if tx.db.freelist.freed(pid) {
+			continue
+		}
+		if pid += pgid(tx.db.page(pid).overflow); pid >= pgid(id) {
+			return &PageInfo{ID: id, Type: "overflow"}, nil
+		}
+	}
+
 	// Build the page info.
 	p := tx.db.page(pgid(id))
 	info := &PageInfo{
 		ID:            id,
+		Type:          p.typ(),
+		Flags:         int(p.flags),
 		Count:         int(p.count),
 		OverflowCount: int(p.overflow),
 	}
+	if uint64(id)+uint64(p.overflow) >= uint64(tx.meta.pgid) {
+		return nil, fmt.Errorf("page %d: overflow out of bounds: %d", id, int(p.overflow))
+	}
 
-	// Determine the type (or if it's free).
-	if tx.db.freelist.freed(pgid(id)) {
-		info.Type = "free"
-	} else {
-		info.Type = p.typ()
+	// Decode the contents of meta, branch and leaf pages, checking that
+	// every element lies within the page before reading it.
+	size := (1 + uint64(p.overflow)) * uint64(tx.db.pageSize)
+	base := uintptr(unsafe.Pointer(p))
+	if (p.flags & metaPageFlag) != 0 {
+		m := p.meta()
+		info.Meta = &MetaInfo{
+			Version:  int(m.version),
+			PageSize: int(m.pageSize),
+			Root:     int(m.root.root),
+			Freelist: int(m.freelist),
+			PageN:    int(m.pgid),
+			TxID:     int(m.txid),
+			Checksum: m.checksum,
+			Valid:    m.validate() == nil,
+		}
+	} else if (p.flags & branchPageFlag) != 0 {
+		if err := checkPageHeader(id, p, branchPageElementSize, size); err != nil {
+			return nil, err
+		}
+		for i := uint16(0); i < p.count; i++ {
+			elem := p.branchPageElement(i)
+			off := uint64(uintptr(unsafe.Pointer(elem))-base) + uint64(elem.pos)
+			if off+uint64(elem.ksize) > size {
+				return nil, fmt.Errorf("page %d: element %d out of bounds", id, int(i))
+			}
+			info.Elements = append(info.Elements, PageElementInfo{
+				Offset: int(off),
+				Key:    append(cloneBytes(p.keyPrefix()), elem.key()...),
+				Child:  int(elem.pgid),
+			})
+		}
+	} else if (p.flags & leafPageFlag) != 0 {
+		if err := checkPageHeader(id, p, leafPageElementSize, size); err != nil {
+			return nil, err
+		}
+		for i := uint16(0); i < p.count; i++ {
+			elem := p.leafPageElement(i)
+			off := uint64(uintptr(unsafe.Pointer(elem))-base) + uint64(elem.pos)
+			if off+uint64(elem.ksize)+uint64(elem.vsize) > size {
+				return nil, fmt.Errorf("page %d: element %d out of bounds", id, int(i))
+			}
+			info.Elements = append(info.Elements, PageElementInfo{
+				Offset:    int(off),
+				Key:       append(cloneBytes(p.keyPrefix()), elem.key()...),
+				ValueSize: int(elem.vsize),
+				IsBucket:  (elem.flags & bucketLeafFlag) != 0,
+			})
+		}
 	}
 
 	return info, nil
 }
 
+// checkPageHeader returns an error if the element headers or the key prefix
+// of branch or leaf page id extend past the size of the page in bytes.
+func checkPageHeader(id int, p *page, elemSize int, size uint64) error {
+	if uint64(pageHeaderSize)+uint64(p.count)*uint64(elemSize) > size {
+		return fmt.Errorf("page %d: element count out of bounds: %d", id, int(p.count))
+	} else if uint64(pageHeaderSize)+uint64(p.prefixpos)+uint64(p.prefixsize) > size {
+		return fmt.Errorf("page %d: key prefix out of bounds", id)
+	}
+	return nil
+}
+
 // TxStats represents statistics about the actions performed by the transaction.
 type TxStats struct {
 	// Page statistics.
@@ -439,6 +439,89 @@ func TestTx_DeleteBucket_NotFound(t *testing.T) {
 	}
 }
 
+// Ensure that a bucket can be copied along with its subtree and sequences.
+func TestTx_CopyBucket(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := b.SetSequence(7); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 1000; i++ {
+			if err := b.Put(u64tob(uint64(i)), make([]byte, 100)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		child, err := b.CreateBucket([]byte("child"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := child.SetSequence(42); err != nil {
+			t.Fatal(err)
+		}
+		grandchild, err := child.CreateBucket([]byte("grandchild"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := grandchild.Put([]byte("foo"), []byte("bar")); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tx.CreateBucket([]byte("gadgets")); err != nil {
+			t.Fatal(err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		if err := tx.CopyBucket([]byte("widgets"), []byte("widgets-copy")); err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.CopyBucket([]byte("widgets"), []byte("gadgets")); err != bolt.ErrBucketExists {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := tx.CopyBucket([]byte("missing"), []byte("other")); err != bolt.ErrBucketNotFound {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Compare the copy against the original, recursively.
+	var compare func(path string, a, b *bolt.Bucket)
+	compare = func(path string, a, b *bolt.Bucket) {
+		if a.Sequence() != b.Sequence() {
+			t.Fatalf("%s: unexpected sequence: %d != %d", path, b.Sequence(), a.Sequence())
+		}
+		ca, cb := a.Cursor(), b.Cursor()
+		ka, va := ca.First()
+		kb, vb := cb.First()
+		for ka != nil || kb != nil {
+			if !bytes.Equal(ka, kb) || !bytes.Equal(va, vb) || (va == nil) != (vb == nil) {
+				t.Fatalf("%s: mismatch: %q=%x, %q=%x", path, ka, va, kb, vb)
+			}
+			if va == nil {
+				compare(path+"/"+string(ka), a.Bucket(ka), b.Bucket(kb))
+			}
+			ka, va = ca.Next()
+			kb, vb = cb.Next()
+		}
+	}
+	if err := db.View(func(tx *bolt.Tx) error {
+		compare("widgets", tx.Bucket([]byte("widgets")), tx.Bucket([]byte("widgets-copy")))
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
 // Ensure that no error is returned when a tx.ForEach function does not return
 // an error.
 func TestTx_ForEachBucket_NoError(t *testing.T) {
@@ -489,6 +572,201 @@ func TestTx_ForEachBucket_WithError(t *testing.T) {
 	}
 }
 
+// Ensure that pages are attributed to the top-level bucket that owns them.
+func TestTx_BucketPages(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	value := bytes.Repeat([]byte("x"), 100)
+	if err := db.Update(func(tx *bolt.Tx) error {
+		widgets, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 200; i++ {
+			if err := widgets.Put(u64tob(uint64(i)), value); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// Nested bucket pages count towards their top-level bucket.
+		gadgets, err := tx.CreateBucket([]byte("gadgets"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		sub, err := gadgets.CreateBucket([]byte("sub"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 100; i++ {
+			if err := sub.Put(u64tob(uint64(i)), value); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := sub.Put([]byte("big"), bytes.Repeat([]byte("y"), 3*db.Info().PageSize)); err != nil {
+			t.Fatal(err)
+		}
+
+		// Small buckets are stored inline in the root page.
+		small, err := tx.CreateBucket([]byte("small"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return small.Put([]byte("foo"), []byte("bar"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.View(func(tx *bolt.Tx) error {
+		pages, err := tx.BucketPages()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(pages) != 3 {
+			t.Fatalf("unexpected buckets: %v", pages)
+		} else if pages["small"] != 0 {
+			t.Fatalf("unexpected inline bucket pages: %d", pages["small"])
+		} else if pages["widgets"] < 5 || pages["gadgets"] < 6 {
+			t.Fatalf("unexpected page counts: %v", pages)
+		}
+
+		// Every page is either owned by a bucket, the root, the freelist,
+		// a meta page, or free.
+		freeN, pendingN, _ := db.FreelistStats()
+		total := pages["widgets"] + pages["gadgets"] + 1 + 1 + 2 + freeN + pendingN
+		if want := int(tx.Size()) / db.Info().PageSize; total != want {
+			t.Fatalf("unexpected total pages: %d != %d", total, want)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A closed transaction cannot be walked.
+	tx, err := db.Begin(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.BucketPages(); err != bolt.ErrTxClosed {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// Ensure that the root reports the combined stats of its top-level buckets.
+func TestTx_BucketStats(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		for _, name := range []string{"widgets", "gadgets"} {
+			b, err := tx.CreateBucket([]byte(name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < 100; i++ {
+				if err := b.Put(u64tob(uint64(i)), make([]byte, 100)); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.View(func(tx *bolt.Tx) error {
+		var want bolt.BucketStats
+		want.Add(tx.Bucket([]byte("widgets")).Stats())
+		want.Add(tx.Bucket([]byte("gadgets")).Stats())
+
+		var bish bolt.Bucketish = tx
+		if got := bish.BucketStats(); got != want {
+			t.Fatalf("unexpected root stats: %+v != %+v", got, want)
+		}
+		bish = tx.Bucket([]byte("widgets"))
+		if got := bish.BucketStats(); got != tx.Bucket([]byte("widgets")).Stats() {
+			t.Fatalf("unexpected bucket stats: %+v", got)
+		}
+		if s := tx.BucketStats(); s.KeyN != 200 || s.BucketN != 2 {
+			t.Fatalf("unexpected stats: %+v", s)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Ensure that Tx.ForEachPage visits every allocated page exactly once.
+func TestTx_ForEachPage(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 500; i++ {
+			if err := b.Put(u64tob(uint64(i)), make([]byte, 100)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return b.Put([]byte("big"), make([]byte, 3*db.Info().PageSize))
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Free some pages.
+	if err := db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("widgets")).Delete([]byte("big"))
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.View(func(tx *bolt.Tx) error {
+		next := 0
+		types := make(map[string]int)
+		if err := tx.ForEachPage(func(id int, typ string, overflow int) error {
+			if id != next {
+				t.Fatalf("unexpected page id: %d != %d", id, next)
+			}
+			next = id + 1 + overflow
+			types[typ]++
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		if want := int(tx.Size()) / db.Info().PageSize; next != want {
+			t.Fatalf("unexpected high water mark: %d != %d", next, want)
+		}
+		freeN, pendingN, _ := db.FreelistStats()
+		if types["meta"] != 2 || types["freelist"] != 1 || types["branch"] == 0 || types["leaf"] == 0 {
+			t.Fatalf("unexpected page types: %v", types)
+		} else if types["free"] != freeN+pendingN {
+			t.Fatalf("unexpected free pages: %d != %d", types["free"], freeN+pendingN)
+		}
+
+		// An error from fn stops the walk.
+		marker := errors.New("marker")
+		var n int
+		if err := tx.ForEachPage(func(id int, typ string, overflow int) error {
+			n++
+			return marker
+		}); err != marker {
+			t.Fatalf("unexpected error: %v", err)
+		} else if n != 1 {
+			t.Fatalf("unexpected call count: %d", n)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
 // Ensure that Tx commit handlers are called after a transaction successfully commits.
 func TestTx_OnCommit(t *testing.T) {
 	db := MustOpenDB()
@@ -529,6 +807,72 @@ func TestTx_OnCommit_Rollback(t *testing.T) {
 	}
 }
 
+// Ensure that Tx commit handlers run in order and observe the committed state.
+func TestTx_OnCommit_Order(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	var calls []string
+	if err := db.Update(func(tx *bolt.Tx) error {
+		tx.OnCommit(func() { calls = append(calls, "first") })
+		tx.OnCommit(func() {
+			if err := db.View(func(tx *bolt.Tx) error {
+				if tx.Bucket([]byte("widgets")) == nil {
+					t.Fatal("expected committed bucket")
+				}
+				return nil
+			}); err != nil {
+				t.Fatal(err)
+			}
+			calls = append(calls, "second")
+		})
+		_, err := tx.CreateBucket([]byte("widgets"))
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(calls) != 2 || calls[0] != "first" || calls[1] != "second" {
+		t.Fatalf("unexpected calls: %v", calls)
+	}
+}
+
+// Ensure that a panicking commit handler does not prevent the others from running.
+func TestTx_OnCommit_Panic(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	var x int
+	func() {
+		defer func() {
+			if r := recover(); r != "omg" {
+				t.Fatalf("unexpected recover: %v", r)
+			}
+		}()
+
+		_ = db.Update(func(tx *bolt.Tx) error {
+			tx.OnCommit(func() { x += 1 })
+			tx.OnCommit(func() { panic("omg") })
+			tx.OnCommit(func() { panic("again") })
+			tx.OnCommit(func() { x += 2 })
+			_, err := tx.CreateBucket([]byte("widgets"))
+			return err
+		})
+	}()
+	if x != 3 {
+		t.Fatalf("unexpected x: %d", x)
+	}
+
+	// Verify that the commit took effect and no locks are held.
+	if err := db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket([]byte("widgets")) == nil {
+			t.Fatal("expected bucket")
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
 // Ensure that the database can be copied to a file path.
 func TestTx_CopyFile(t *testing.T) {
 	db := MustOpenDB()
@@ -0,0 +1,100 @@
+//go:build go1.18
+// +build go1.18
+
+package bbolt
+
+import "encoding/json"
+
+// Codec converts the keys and values of a TypedBucket to and from the bytes
+// stored in the underlying bucket.
+//
+// Decode functions are handed slices that are only valid for the life of the
+// transaction, and must copy anything they retain.
+type Codec[K, V any] struct {
+	EncodeKey   func(K) ([]byte, error)
+	DecodeKey   func([]byte) (K, error)
+	EncodeValue func(V) ([]byte, error)
+	DecodeValue func([]byte) (V, error)
+}
+
+// JSONCodec returns a Codec that stores keys and values as JSON documents.
+// Note that keys are ordered by their JSON encoding, which for numbers does
+// not match numeric order.
+func JSONCodec[K, V any]() Codec[K, V] {
+	return Codec[K, V]{
+		EncodeKey:   func(k K) ([]byte, error) { return json.Marshal(k) },
+		DecodeKey:   func(data []byte) (k K, err error) { err = json.Unmarshal(data, &k); return },
+		EncodeValue: func(v V) ([]byte, error) { return json.Marshal(v) },
+		DecodeValue: func(data []byte) (v V, err error) { err = json.Unmarshal(data, &v); return },
+	}
+}
+
+// TypedBucket wraps a Bucket, encoding and decoding its keys and values with
+// a Codec. It does not change how data is stored, so the same bucket can be
+// read through Bucket and TypedBucket alike.
+type TypedBucket[K, V any] struct {
+	bucket *Bucket
+	codec  Codec[K, V]
+}
+
+// Typed returns a TypedBucket over b using codec.
+func Typed[K, V any](b *Bucket, codec Codec[K, V]) TypedBucket[K, V] {
+	return TypedBucket[K, V]{bucket: b, codec: codec}
+}
+
+// Bucket returns the underlying bucket.
+func (tb TypedBucket[K, V]) Bucket() *Bucket {
+	return tb.bucket
+}
+
+// Get retrieves and decodes the value for a key.
+// Returns ErrKeyNotFound if the key does not exist or is a nested bucket.
+func (tb TypedBucket[K, V]) Get(key K) (V, error) {
+	var zero V
+
+	k, err := tb.codec.EncodeKey(key)
+	if err != nil {
+		return zero, err
+	}
+
+	v := tb.bucket.Get(k)
+	if v == nil {
+		return zero, ErrKeyNotFound
+	}
+	return tb.codec.DecodeValue(v)
+}
+
+// Put encodes and sets the value for a key, as Bucket.Put does.
+func (tb TypedBucket[K, V]) Put(key K, value V) error {
+	k, err := tb.codec.EncodeKey(key)
+	if err != nil {
+		return err
+	}
+	v, err := tb.codec.EncodeValue(value)
+	if err != nil {
+		return err
+	}
+	return tb.bucket.Put(k, v)
+}
+
+// ForEach decodes and executes a function for each key/value pair in the
+// bucket, in key order. Nested buckets are skipped. If the provided function
+// or a decoder returns an error then the iteration is stopped and the error
+// is returned to the caller.
+func (tb TypedBucket[K, V]) ForEach(fn func(K, V) error) error {
+	return tb.bucket.ForEach(func(k, v []byte) error {
+		if v == nil {
+			return nil
+		}
+
+		key, err := tb.codec.DecodeKey(k)
+		if err != nil {
+			return err
+		}
+		value, err := tb.codec.DecodeValue(v)
+		if err != nil {
+			return err
+		}
+		return fn(key, value)
+	})
+}
@@ -0,0 +1,69 @@
+//go:build go1.18
+// +build go1.18
+
+package bbolt_test
+
+import (
+	"reflect"
+	"testing"
+
+	bolt "github.com/covalenthq/bbolt"
+)
+
+type widget struct {
+	Name  string
+	Count int
+}
+
+// Ensure that a typed bucket round-trips keys and values through its codec.
+func TestTypedBucket(t *testing.T) {
+	db := MustOpenDB()
+	defer db.MustClose()
+
+	if err := db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket([]byte("widgets"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := b.CreateBucket([]byte("child")); err != nil {
+			t.Fatal(err)
+		}
+
+		tb := bolt.Typed(b, bolt.JSONCodec[string, widget]())
+		if err := tb.Put("foo", widget{Name: "foo", Count: 1}); err != nil {
+			t.Fatal(err)
+		}
+		if err := tb.Put("bar", widget{Name: "bar", Count: 2}); err != nil {
+			t.Fatal(err)
+		}
+
+		if w, err := tb.Get("foo"); err != nil {
+			t.Fatal(err)
+		} else if w != (widget{Name: "foo", Count: 1}) {
+			t.Fatalf("unexpected value: %+v", w)
+		}
+		if _, err := tb.Get("baz"); err != bolt.ErrKeyNotFound {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if v := b.Get([]byte(`"foo"`)); string(v) != `{"Name":"foo","Count":1}` {
+			t.Fatalf("unexpected stored value: %s", v)
+		}
+
+		var keys []string
+		if err := tb.ForEach(func(k string, w widget) error {
+			if k != w.Name {
+				t.Fatalf("unexpected pair: %s %+v", k, w)
+			}
+			keys = append(keys, k)
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(keys, []string{"bar", "foo"}) {
+			t.Fatalf("unexpected keys: %v", keys)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
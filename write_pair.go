@@ -11,3 +11,14 @@ func WritablePair(key, value []byte) WritePair {
 		value: cloneBytes(value),
 	}
 }
+
+// Key returns the key of the pair.
+func (p WritePair) Key() []byte {
+	return p.key
+}
+
+// Value returns the value of the pair, or nil if the pair refers to a nested
+// bucket.
+func (p WritePair) Value() []byte {
+	return p.value
+}